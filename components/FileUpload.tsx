@@ -1,5 +1,5 @@
 "use client";
-import { useState } from "react";
+import { useRef, useState } from "react";
 import { UploadHeader } from "./upload/UploadHeader";
 import { NetworkStatus } from "./upload/NetworkStatus";
 import { ProgressDisplay } from "./upload/ProgressDisplay";
@@ -25,6 +25,7 @@ import { useUploadLogic } from "@/hooks/useUploadLogic";
 import { useAdaptiveNetworkMonitor } from "@/hooks/useAdaptiveNetworkMonitor";
 import { useUploadTelemetry } from "@/hooks/useUploadTelemetry";
 import { handleFileChange as handleFileChangeUtil } from "@/utils/helpers/fileHandlers";
+import { reportTelemetry } from "@/utils/helpers/file";
 import { UploadModeToggle } from "./upload/UploadModeToggle";
 
 export default function FileUpload() {
@@ -48,6 +49,10 @@ export default function FileUpload() {
     startTime: state.metrics.startTime,
   });
 
+  // Completion callbacks run inside the upload closure; read the latest metrics
+  const telemetryRef = useRef(telemetry.telemetry);
+  telemetryRef.current = telemetry.telemetry;
+
   const { startUpload } = useUploadLogic({
     isCancelling: state.isCancelling,
     setIsCompressing: state.setIsCompressing,
@@ -77,6 +82,14 @@ export default function FileUpload() {
     },
     onNetworkDegradation: telemetry.recordNetworkDegradation,
     onNetworkRecovery: telemetry.recordNetworkRecovery,
+    onUploadComplete: (uploadID: string, chunkSize: number, fileSize: number) => {
+      reportTelemetry(
+        uploadID,
+        { ...telemetryRef.current, totalBytes: fileSize, endTime: Date.now() },
+        chunkSize,
+        useAdaptiveMode
+      );
+    },
   });
 
   const handleFileChange = async (event: React.ChangeEvent<HTMLInputElement>) => {
@@ -1,6 +1,6 @@
 "use client";
 
-import { useEffect, useState } from "react";
+import { useEffect, useRef, useState } from "react";
 import { Clock, Upload, FileCheck, Loader2 } from "lucide-react";
 
 interface ActiveUpload {
@@ -17,6 +17,12 @@ interface CompletedFile {
   filename: string;
   file_size: number;
   completed_at: string;
+  // Set when an operator force-completed the upload: the file may be damaged
+  forced?: {
+    filled_chunks?: number[];
+    expected_hash?: string;
+    forced_at: string;
+  };
 }
 
 interface RoomState {
@@ -29,7 +35,14 @@ interface RoomState {
 }
 
 interface RoomEvent {
-  type: "upload_start" | "chunk_received" | "upload_complete" | "room_state";
+  type:
+    | "upload_start"
+    | "chunk_received"
+    | "upload_complete"
+    | "room_state"
+    | "sink_progress"
+    | "sink_complete"
+    | "sink_failed";
   share_id: string;
   upload_id?: string;
   filename?: string;
@@ -37,6 +50,15 @@ interface RoomEvent {
   timestamp: string;
 }
 
+// Copy of a completed file pushed to a sink such as Cloudinary
+interface SinkCopy {
+  filename: string;
+  sink: string;
+  percent: number;
+  url?: string;
+  error?: string;
+}
+
 interface RoomDashboardProps {
   shareId: string;
   endpoint: string;
@@ -48,6 +70,8 @@ export function RoomDashboard({ shareId, endpoint, onFilesChange }: RoomDashboar
   const [loading, setLoading] = useState(true);
   const [expiresIn, setExpiresIn] = useState<number>(0);
   const [eventSource, setEventSource] = useState<EventSource | null>(null);
+  const lastEventIdRef = useRef<string>("");
+  const [sinkCopies, setSinkCopies] = useState<Record<string, SinkCopy>>({});
 
   // Format time remaining
   const formatTimeRemaining = (seconds: number) => {
@@ -99,9 +123,14 @@ export function RoomDashboard({ shareId, endpoint, onFilesChange }: RoomDashboar
   useEffect(() => {
     if (!shareId) return;
 
-    const es = new EventSource(`${endpoint}/room/${shareId}/events`);
+    // Resume from the last event seen so nothing is missed across reconnects
+    const resume = lastEventIdRef.current ? `?last_event_id=${lastEventIdRef.current}` : "";
+    const es = new EventSource(`${endpoint}/room/${shareId}/events${resume}`);
 
     es.onmessage = (event) => {
+      if (event.lastEventId) {
+        lastEventIdRef.current = event.lastEventId;
+      }
       try {
         const roomEvent: RoomEvent = JSON.parse(event.data);
         
@@ -138,6 +167,29 @@ export function RoomDashboard({ shareId, endpoint, onFilesChange }: RoomDashboar
               setRoomState(data);
               setExpiresIn(data.expires_in);
             });
+        } else if (
+          (roomEvent.type === "sink_progress" ||
+            roomEvent.type === "sink_complete" ||
+            roomEvent.type === "sink_failed") &&
+          roomEvent.upload_id &&
+          roomEvent.data
+        ) {
+          const key = `${roomEvent.upload_id}/${roomEvent.data.sink}`;
+          setSinkCopies((prev) => ({
+            ...prev,
+            [key]: {
+              filename: roomEvent.filename || prev[key]?.filename || "",
+              sink: roomEvent.data.sink,
+              percent:
+                roomEvent.type === "sink_progress"
+                  ? roomEvent.data.percent
+                  : roomEvent.type === "sink_complete"
+                  ? 100
+                  : prev[key]?.percent ?? 0,
+              url: roomEvent.type === "sink_complete" ? roomEvent.data.url : undefined,
+              error: roomEvent.type === "sink_failed" ? roomEvent.data.reason : undefined,
+            },
+          }));
         } else if (roomEvent.type === "upload_complete" && roomState) {
           // Refresh full state on completion
           fetch(`${endpoint}/room/${shareId}`)
@@ -156,8 +208,8 @@ export function RoomDashboard({ shareId, endpoint, onFilesChange }: RoomDashboar
     };
 
     es.onerror = () => {
-      console.error("SSE connection error");
-      es.close();
+      // The browser reconnects on its own, sending Last-Event-ID
+      console.error("SSE connection error, reconnecting");
     };
 
     setEventSource(es);
@@ -193,6 +245,7 @@ export function RoomDashboard({ shareId, endpoint, onFilesChange }: RoomDashboar
 
   const hasActiveUploads = roomState.active_uploads?.length > 0;
   const hasCompletedFiles = roomState.completed_files?.length > 0;
+  const forcedFiles = roomState.completed_files?.filter((f) => f.forced) ?? [];
 
   return (
     <div className="mb-4 sm:mb-6 lg:mb-8 space-y-3 sm:space-y-4">
@@ -273,6 +326,34 @@ export function RoomDashboard({ shareId, endpoint, onFilesChange }: RoomDashboar
           <p className="text-xs sm:text-sm text-zinc-400">
             {roomState.completed_files.length} file{roomState.completed_files.length !== 1 ? "s" : ""} available below
           </p>
+          {forcedFiles.length > 0 && (
+            <p className="mt-2 text-xs sm:text-sm text-amber-400">
+              Completed by an operator and possibly damaged: {forcedFiles.map((f) => f.filename).join(", ")}
+            </p>
+          )}
+          {Object.entries(sinkCopies).length > 0 && (
+            <ul className="mt-3 space-y-1 text-xs text-zinc-400">
+              {Object.entries(sinkCopies).map(([key, copy]) => (
+                <li key={key} className="flex items-center justify-between gap-2">
+                  <span className="truncate">
+                    {copy.filename} → {copy.sink}
+                  </span>
+                  {copy.url ? (
+                    <a href={copy.url} target="_blank" rel="noreferrer" className="text-green-400 hover:underline whitespace-nowrap">
+                      CDN copy ready
+                    </a>
+                  ) : copy.error ? (
+                    <span className="text-red-400 whitespace-nowrap">Copy failed</span>
+                  ) : (
+                    <span className="flex items-center gap-1 whitespace-nowrap">
+                      <Loader2 className="w-3 h-3 animate-spin" />
+                      {copy.percent}%
+                    </span>
+                  )}
+                </li>
+              ))}
+            </ul>
+          )}
         </div>
       )}
 
@@ -2,7 +2,7 @@ import { useState, useCallback, useRef } from 'react';
 import { FileUploadState, MultiFileUploadState, MultiFileUploadCallbacks } from '@/types/MultiFileUpload';
 import { UploadMetrics, CostComparison, COST_PER_MB, WASTED_MULTIPLIER } from '@/types/UploadMetrics';
 import { NetworkProfile } from '@/types/NetworkProfile';
-import { bufferToHex, uploadChunk } from '@/utils/helpers/file';
+import { bufferToHex, endUploadTrace, RetryAfterError, traceHeaders, uploadChunk } from '@/utils/helpers/file';
 import { AdaptiveConcurrency } from '@/utils/AdaptiveConcurrency';
 import xxhashWasm from 'xxhash-wasm';
 
@@ -27,6 +27,8 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
 
   const isCancellingRef = useRef(false);
   const activeUploadsRef = useRef<Map<string, AbortController>>(new Map());
+  // Chunk size the server advised during the latest finished upload
+  const advisedChunkSizeRef = useRef<number | null>(null);
 
   // Update individual file state
   const updateFileState = useCallback((uploadId: string, updates: Partial<FileUploadState>) => {
@@ -66,8 +68,8 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
     activeUploadsRef.current.set(uploadId, abortController);
 
     try {
-      // Lock chunk size at start
-      const CHUNK_SIZE = currentProfile.chunkSize;
+      // Lock chunk size at start; earlier server advice wins over the profile
+      const CHUNK_SIZE = advisedChunkSizeRef.current ?? currentProfile.chunkSize;
       const chunks = Math.ceil(file.size / CHUNK_SIZE);
 
       updateFileState(uploadId, {
@@ -111,7 +113,7 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
 
       const initRes = await fetch(`${DEFAULT_ENDPOINT}/init`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: { 'Content-Type': 'application/json', ...traceHeaders(uploadId) },
         body: JSON.stringify(metadata),
         signal: abortController.signal,
       });
@@ -130,6 +132,7 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
 
       // Check for already uploaded chunks
       const statusRes = await fetch(`${DEFAULT_ENDPOINT}/status/${uploadId}`, {
+        headers: traceHeaders(uploadId),
         signal: abortController.signal,
       });
       let received: number[] = [];
@@ -173,16 +176,22 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
             workers: adaptiveManager.getConcurrency(),
           };
 
-          await Promise.race([
+          const serverBackoff = adaptiveManager.getServerBackoff();
+          if (serverBackoff > 0) {
+            await new Promise(r => setTimeout(r, serverBackoff));
+          }
+
+          const advice = await Promise.race([
             uploadChunk(uploadId, idx, blob, lockedProfile, DEFAULT_ENDPOINT),
             new Promise((_, reject) => {
               timeoutController.signal.addEventListener('abort', () => {
                 reject(new Error(`Chunk ${idx} timed out after ${timeout}ms`));
               });
             }),
-          ]);
+          ]) as Awaited<ReturnType<typeof uploadChunk>>;
 
           clearTimeout(timeoutId);
+          if (advice) adaptiveManager.applyServerAdvice(advice);
 
           const chunkDuration = performance.now() - chunkStartTime;
           adaptiveManager.recordChunkSuccess(chunkId, chunkDuration, attempt - 1);
@@ -216,7 +225,9 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
 
           if (attempt < 6) {
             const isTimeout = errorMessage.includes('timed out');
-            const backoffMs = isTimeout ? attempt * 1000 : attempt * 400;
+            const backoffMs = err instanceof RetryAfterError
+              ? err.retryAfterMs
+              : isTimeout ? attempt * 1000 : attempt * 400;
             await new Promise(r => setTimeout(r, backoffMs));
             return uploadWithRetry(idx, attempt + 1);
           }
@@ -251,12 +262,15 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
       }
 
       adaptiveManager.stop();
+      advisedChunkSizeRef.current = adaptiveManager.getAdvisedChunkSize() ?? advisedChunkSizeRef.current;
 
       // Complete upload
       const completeRes = await fetch(`${DEFAULT_ENDPOINT}/complete/${uploadId}`, {
         method: 'POST',
+        headers: traceHeaders(uploadId),
         signal: abortController.signal,
       });
+      endUploadTrace(uploadId);
 
       if (!completeRes.ok) {
         const errorData = await completeRes.json().catch(() => ({}));
@@ -339,7 +353,7 @@ export function useMultiFileUpload(params: UseMultiFileUploadParams = {}) {
     // Initialize file states
     const fileStates: FileUploadState[] = files.map((file, index) => ({
       file,
-      uploadId: `${file.name.replace(/[^a-z0-9.-_]/gi, '')}-${Date.now()}-${index}`,
+      uploadId: `${file.name.replace(/[^a-z0-9._-]/gi, '').replace(/\.{2,}/g, '.').slice(0, 100)}-${Date.now()}-${index}`,
       shareId: '',
       status: 'pending',
       progress: 0,
@@ -1,4 +1,5 @@
-import { bufferToHex, uploadChunk } from "@/utils/helpers/file";
+import { useRef } from "react";
+import { bufferToHex, endUploadTrace, RetryAfterError, traceHeaders, uploadChunk } from "@/utils/helpers/file";
 import { UploadMetrics, CostComparison, COST_PER_MB, WASTED_MULTIPLIER } from "@/types/UploadMetrics";
 import { NetworkProfile } from "@/types/NetworkProfile";
 import { AdaptiveConcurrency } from "@/utils/AdaptiveConcurrency";
@@ -27,9 +28,13 @@ interface UploadLogicParams {
     onConcurrencyChange?: (newValue: number, oldValue: number, reason: string) => void;
     onNetworkDegradation?: (reason: string) => void;
     onNetworkRecovery?: (reason: string) => void;
+    onUploadComplete?: (uploadID: string, chunkSize: number, fileSize: number) => void;
 }
 
 export function useUploadLogic(params: UploadLogicParams) {
+    // Chunk size the server advised during the previous upload
+    const advisedChunkSize = useRef<number | null>(null);
+
     const performUpload = async (
         file: File,
         startTime: number,
@@ -38,7 +43,8 @@ export function useUploadLogic(params: UploadLogicParams) {
     ) => {
         // CRITICAL: Lock in the chunk size at the START of upload
         // This prevents issues when adaptive mode changes the profile during upload
-        const CHUNK_SIZE = currentProfile.chunkSize;
+        // The server's advice from the previous upload wins over the profile
+        const CHUNK_SIZE = advisedChunkSize.current ?? currentProfile.chunkSize;
         const chunks = Math.ceil(file.size / CHUNK_SIZE);
         params.setTotalChunks(chunks);
 
@@ -96,7 +102,7 @@ export function useUploadLogic(params: UploadLogicParams) {
 
         // Start upload immediately without computing file hash upfront
         // The server will verify chunks individually, and we'll compute file hash at the end
-        const uploadID = `${file.name.replace(/[^a-z0-9.-_]/gi, "")}-${Date.now()}`;
+        const uploadID = `${file.name.replace(/[^a-z0-9._-]/gi, "").replace(/\.{2,}/g, ".").slice(0, 100)}-${Date.now()}`;
         const metadata: any = {
             upload_id: uploadID,
             filename: file.name,
@@ -113,7 +119,7 @@ export function useUploadLogic(params: UploadLogicParams) {
 
         const initRes = await fetch(`${DEFAULT_ENDPOINT}/init`, {
             method: "POST",
-            headers: { "Content-Type": "application/json" },
+            headers: { "Content-Type": "application/json", ...traceHeaders(uploadID) },
             body: JSON.stringify(metadata),
         });
 
@@ -122,7 +128,7 @@ export function useUploadLogic(params: UploadLogicParams) {
         const initData = await initRes.json() as { upload_id: string; share_id: string };
         const shareId = initData.share_id;
 
-        const statusRes = await fetch(`${DEFAULT_ENDPOINT}/status/${uploadID}`);
+        const statusRes = await fetch(`${DEFAULT_ENDPOINT}/status/${uploadID}`, { headers: traceHeaders(uploadID) });
         let received: number[] = [];
         if (statusRes.ok) {
             const parsed = await statusRes.json() as { received_chunks: number[] };
@@ -171,17 +177,23 @@ export function useUploadLogic(params: UploadLogicParams) {
                     workers: adaptiveManager.getConcurrency() // Use adaptive concurrency
                 };
                 
+                const serverBackoff = adaptiveManager.getServerBackoff();
+                if (serverBackoff > 0) {
+                    await new Promise((r) => setTimeout(r, serverBackoff));
+                }
+
                 // Race between upload and timeout
-                await Promise.race([
+                const advice = await Promise.race([
                     uploadChunk(uploadID, idx, blob, lockedProfile, DEFAULT_ENDPOINT),
                     new Promise((_, reject) => {
                         abortController.signal.addEventListener('abort', () => {
                             reject(new Error(`Chunk ${idx} timed out after ${timeout}ms`));
                         });
                     })
-                ]);
+                ]) as Awaited<ReturnType<typeof uploadChunk>>;
                 
                 clearTimeout(timeoutId);
+                if (advice) adaptiveManager.applyServerAdvice(advice);
                 
                 // Record success
                 const chunkDuration = performance.now() - chunkStartTime;
@@ -228,7 +240,10 @@ export function useUploadLogic(params: UploadLogicParams) {
                 // Retry logic with exponential backoff
                 if (attempt < 6) {
                     const isTimeout = errorMessage.includes('timed out');
-                    const backoffMs = isTimeout ? attempt * 1000 : attempt * 400;
+                    // Honour the server's retry hint when it throttles us
+                    const backoffMs = err instanceof RetryAfterError
+                        ? err.retryAfterMs
+                        : isTimeout ? attempt * 1000 : attempt * 400;
                     
                     console.log(`⚠️ Chunk ${idx} failed (attempt ${attempt}): ${errorMessage}. Retrying in ${backoffMs}ms...`);
                     
@@ -361,6 +376,7 @@ export function useUploadLogic(params: UploadLogicParams) {
         // Stop adaptive manager and log final metrics
         adaptiveManager.stop();
         unsubscribe();
+        advisedChunkSize.current = adaptiveManager.getAdvisedChunkSize() ?? advisedChunkSize.current;
 
         const finalMetrics = adaptiveManager.getMetrics();
         if (finalMetrics) {
@@ -371,10 +387,15 @@ export function useUploadLogic(params: UploadLogicParams) {
             });
         }
 
-        const completeRes = await fetch(`${DEFAULT_ENDPOINT}/complete/${uploadID}`, { method: "POST" });
+        const completeRes = await fetch(`${DEFAULT_ENDPOINT}/complete/${uploadID}`, {
+            method: "POST",
+            headers: traceHeaders(uploadID),
+        });
+        endUploadTrace(uploadID);
         if (!completeRes.ok) throw new Error(`complete failed: ${completeRes.status}`);
 
         console.log(`✅ Upload completed successfully: ${uploadID}`);
+        params.onUploadComplete?.(uploadID, CHUNK_SIZE, file.size);
 
         const fileSizeMB = file.size / 1024 / 1024;
         const retryRate = totalRetries / chunks;
@@ -0,0 +1,67 @@
+package bus
+
+import "sync"
+
+// Message is an event published by one orchestrator node for the others
+type Message struct {
+	Node   string `json:"node"`   // publishing node, used to skip our own messages
+	Stream string `json:"stream"` // event log stream, e.g. "room/<shareID>"
+	Key    string `json:"key"`    // globally unique event ID, "<node>:<id>"
+	Data   string `json:"data"`
+}
+
+// EventBus fans events out to every subscribed node
+type EventBus interface {
+	Publish(msg Message) error
+	// Subscribe calls handler for every published message until the
+	// returned func is called
+	Subscribe(handler func(Message)) (func(), error)
+	Close() error
+}
+
+// Memory is an in-process EventBus. Handlers run synchronously on the
+// publishing goroutine, so several event logs in one process (tests, local
+// tools) behave like separate nodes.
+type Memory struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]func(Message)
+}
+
+// NewMemory creates an empty in-process bus
+func NewMemory() *Memory {
+	return &Memory{handlers: make(map[int]func(Message))}
+}
+
+func (m *Memory) Publish(msg Message) error {
+	m.mu.RLock()
+	handlers := make([]func(Message), 0, len(m.handlers))
+	for _, h := range m.handlers {
+		handlers = append(handlers, h)
+	}
+	m.mu.RUnlock()
+	for _, h := range handlers {
+		h(msg)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(handler func(Message)) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.next
+	m.next++
+	m.handlers[id] = handler
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.handlers, id)
+	}, nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = make(map[int]func(Message))
+	return nil
+}
@@ -0,0 +1,71 @@
+package bus
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultSubject is the NATS subject events are published on
+const DefaultSubject = "aetherlink.events"
+
+// NATS is an EventBus backed by core NATS pub/sub. Delivery is at most once;
+// a node that misses messages while disconnected recovers through the
+// room_state snapshot sent to every (re)connecting SSE client.
+type NATS struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATS connects to the NATS server at url and keeps reconnecting forever
+func NewNATS(url, subject, name string) (*NATS, error) {
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	conn, err := nats.Connect(url,
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			slog.Warn("event bus disconnected", "event", "event_bus", "error", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			slog.Info("event bus reconnected", "event", "event_bus", "server", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{conn: conn, subject: subject}, nil
+}
+
+func (n *NATS) Publish(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return n.conn.Publish(n.subject, data)
+}
+
+func (n *NATS) Subscribe(handler func(Message)) (func(), error) {
+	sub, err := n.conn.Subscribe(n.subject, func(m *nats.Msg) {
+		var msg Message
+		if err := json.Unmarshal(m.Data, &msg); err != nil {
+			slog.Warn("dropping malformed event bus message", "event", "event_bus", "error", err)
+			return
+		}
+		handler(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = sub.Unsubscribe() }, nil
+}
+
+func (n *NATS) Close() error {
+	if err := n.conn.Drain(); err != nil {
+		n.conn.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+// Package client uploads to and downloads from an orchestrator. It speaks the
+// same protocol as the web client: files are declared at /init with the
+// xxhash of every chunk, chunks are PUT in parallel and /complete assembles
+// them. Requests go to the /v1 API, are retried with exponential backoff and
+// stop when their context ends.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"aetherlink/models"
+)
+
+// apiPrefix is where the orchestrator serves the versioned API
+const apiPrefix = "/v1"
+
+// RetryPolicy controls how failed requests are retried. Only failures that
+// may pass are retried: transport errors, 408, 422 (a chunk damaged on the
+// way), 429 and 5xx other than 507.
+type RetryPolicy struct {
+	Attempts  int           // tries per request, at least 1
+	BaseDelay time.Duration // pause before the first retry, doubled for each one after
+	MaxDelay  time.Duration // longest pause, unless the server's Retry-After asks for more
+}
+
+// DefaultRetryPolicy is used unless WithRetry says otherwise
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  6,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  30 * time.Second,
+}
+
+// Client talks to one orchestrator. It is safe for concurrent use.
+type Client struct {
+	baseURL  string
+	http     *http.Client
+	priority string
+	retry    RetryPolicy
+
+	mu     sync.Mutex
+	advice *models.ChunkAdvice // latest advice, sizes the chunks of new uploads
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc. It should not set a Timeout,
+// which would cut off downloads and event streams; use contexts instead.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetry replaces DefaultRetryPolicy
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithPriority sends X-Priority ("high", "normal" or "bulk") with uploads
+// and downloads
+func WithPriority(priority string) Option {
+	return func(c *Client) { c.priority = priority }
+}
+
+// NewClient returns a client of the orchestrator at baseURL, e.g.
+// http://localhost:8080
+func NewClient(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q", baseURL)
+	}
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    http.DefaultClient,
+		retry:   DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retry.Attempts < 1 {
+		c.retry.Attempts = 1
+	}
+	return c, nil
+}
+
+// BaseURL returns the server URL the client was created with
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Advice returns the server's latest chunk advice, if any was received
+func (c *Client) Advice() (models.ChunkAdvice, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.advice == nil {
+		return models.ChunkAdvice{}, false
+	}
+	return *c.advice, true
+}
+
+func (c *Client) setAdvice(a models.ChunkAdvice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advice = &a
+}
+
+// Error is a response outside 2xx
+type Error struct {
+	StatusCode int
+	Code       string // one of the models.Code constants, empty if the body was not an error envelope
+	Message    string // the error message, else the body
+	Details    map[string]interface{}
+	Body       []byte
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Message == "":
+		return fmt.Sprintf("server returned %d", e.StatusCode)
+	case e.Code == "":
+		return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("server returned %d: %s (%s)", e.StatusCode, e.Message, e.Code)
+}
+
+// Temporary reports whether the request may succeed when sent again: the
+// server was busy, draining or failed, or a chunk was damaged on the way
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusUnprocessableEntity:
+		return true
+	case http.StatusInsufficientStorage:
+		return false
+	}
+	return e.StatusCode >= 500
+}
+
+// IsStatus reports whether err is an *Error with the given status code
+func IsStatus(err error, code int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == code
+}
+
+// IsCode reports whether err is an *Error with the given error code
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// newRequest builds a request for path relative to the server's /v1 API
+func (c *Client) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPrefix+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if c.priority != "" {
+		req.Header.Set("X-Priority", c.priority)
+	}
+	return req, nil
+}
+
+// send performs req once and returns the response of a 2xx status; any
+// other status is read into an *Error
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &Error{StatusCode: resp.StatusCode, Body: body}
+	var envelope models.ErrorEnvelope
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Code != "" {
+		e.Code = envelope.Error.Code
+		e.Message = envelope.Error.Message
+		e.Details = envelope.Error.Details
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return nil, e
+}
+
+// call sends in as JSON (or no body when nil) and decodes the JSON response
+// into out, retrying temporary failures
+func (c *Client) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	return c.withRetry(ctx, func() error {
+		req, err := c.newRequest(ctx, method, path, body)
+		if err != nil {
+			return err
+		}
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := c.send(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	})
+}
+
+// withRetry runs fn until it succeeds, fails permanently or runs out of
+// attempts, backing off exponentially with jitter between tries. A
+// Retry-After sent by the server is waited out in full.
+func (c *Client) withRetry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < c.retry.Attempts; attempt++ {
+		if attempt > 0 {
+			if werr := sleep(ctx, c.backoff(attempt, err)); werr != nil {
+				return werr
+			}
+		}
+		if err = fn(); err == nil || !retryable(ctx, err) {
+			return err
+		}
+	}
+	return err
+}
+
+// permanentError marks a failure on the client's side that sending the
+// request again cannot fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var p permanentError
+	if errors.As(err, &p) {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Temporary()
+	}
+	// Transport errors: refused or reset connections, a server restarting
+	return true
+}
+
+// backoff returns the pause before the given retry
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	d := c.retry.BaseDelay << (attempt - 1)
+	if d > c.retry.MaxDelay || d <= 0 {
+		d = c.retry.MaxDelay
+	}
+	if d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	var e *Error
+	if errors.As(err, &e) && e.RetryAfter > d {
+		d = e.RetryAfter
+	}
+	return d
+}
+
+// sleep waits for d, or until ctx ends
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
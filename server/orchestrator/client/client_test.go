@@ -0,0 +1,416 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"aetherlink/client"
+	"aetherlink/config"
+	"aetherlink/middleware"
+	"aetherlink/models"
+	"aetherlink/routes"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestMain runs the tests in a scratch directory, since storage and event
+// logs live under the working directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aetherlink-client")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(config.StorageRoot, 0755); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fault answers matching requests in place of the orchestrator
+type fault struct {
+	method     string
+	path       *regexp.Regexp
+	times      int // requests to refuse; negative refuses until cleared
+	status     int
+	retryAfter int // seconds, 0 sends no Retry-After
+}
+
+// testServer is an orchestrator behind an httptest server. Fiber serves the
+// routes on a listener of its own, since net/http adapters buffer whole
+// responses and would hold back event streams; the httptest server proxies
+// to it, logs every request and injects faults.
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	faults   []*fault
+	requests []string // "METHOD /path" as the client sent them
+}
+
+func newTestServer(t *testing.T) *testServer {
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler, DisableStartupMessage: true})
+	routes.SetupRoutes(app)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: ln.Addr().String()})
+	proxy.FlushInterval = -1 // pass events on as they are written
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		ts.requests = append(ts.requests, r.Method+" "+r.URL.Path)
+		f := ts.match(r)
+		ts.mu.Unlock()
+		if f == nil {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		if f.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(f.retryAfter))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.status)
+		w.Write([]byte(`{"error": {"code": "injected", "message": "injected fault"}}`))
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+		app.ShutdownWithTimeout(time.Second)
+	})
+	return ts
+}
+
+// match returns the fault for r and counts it down; ts.mu is held
+func (ts *testServer) match(r *http.Request) *fault {
+	for _, f := range ts.faults {
+		if f.times != 0 && r.Method == f.method && f.path.MatchString(r.URL.Path) {
+			if f.times > 0 {
+				f.times--
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+func (ts *testServer) inject(f fault) *fault {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.faults = append(ts.faults, &f)
+	return &f
+}
+
+func (ts *testServer) clear(f *fault) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	f.times = 0
+}
+
+// count returns how many requests matched method and the path pattern
+func (ts *testServer) count(method, pattern string) int {
+	re := regexp.MustCompile(pattern)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n := 0
+	for _, req := range ts.requests {
+		if m, path, _ := strings.Cut(req, " "); m == method && re.MatchString(path) {
+			n++
+		}
+	}
+	return n
+}
+
+// newClient returns a client of ts that retries quickly
+func newClient(t *testing.T, ts *testServer) *client.Client {
+	c, err := client.NewClient(ts.URL, client.WithRetry(client.RetryPolicy{
+		Attempts:  4,
+		BaseDelay: time.Millisecond,
+		MaxDelay:  10 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func content(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+const testChunkSize = 1024
+
+func TestUploadListAndDownload(t *testing.T) {
+	ts := newTestServer(t)
+	c := newClient(t, ts)
+	ctx := context.Background()
+	data := content(3*testChunkSize + 500)
+
+	var last client.Progress
+	res, err := c.Upload(ctx, bytes.NewReader(data), client.UploadOptions{
+		Filename:  "report.bin",
+		ChunkSize: testChunkSize,
+		Progress:  func(p client.Progress) { last = p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Complete.Status != "assembled" || res.Complete.FileHash != res.Session.FileHash {
+		t.Errorf("complete = %+v, want hash %s", res.Complete, res.Session.FileHash)
+	}
+	if res.Session.ShareID == "" || len(res.Session.ChunkHashes) != 4 {
+		t.Errorf("session = %+v", res.Session)
+	}
+	if last.Sent != int64(len(data)) || last.Total != int64(len(data)) || last.Resumed != 0 {
+		t.Errorf("last progress = %+v", last)
+	}
+	if n := ts.count(http.MethodPut, "^/v1/upload/"); n != 4 {
+		t.Errorf("%d chunk PUTs, want 4", n)
+	}
+
+	files, err := c.ListFiles(ctx, res.Session.ShareID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].UploadID != res.Session.UploadID || files[0].Status != models.FileComplete || files[0].FileSize != int64(len(data)) {
+		t.Fatalf("files = %+v", files)
+	}
+	if other, err := c.ListFiles(ctx, "no-such-share"); err != nil || len(other) != 0 {
+		t.Errorf("files of another share = %+v, %v", other, err)
+	}
+
+	var got bytes.Buffer
+	n, err := c.Download(ctx, res.Session.ShareID, res.Session.UploadID, "report.bin", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("downloaded %d bytes, want the %d uploaded", n, len(data))
+	}
+
+	_, err = c.Download(ctx, "wrong-share", res.Session.UploadID, "report.bin", &got)
+	if !client.IsStatus(err, http.StatusForbidden) && !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("download from another share: %v", err)
+	}
+}
+
+func TestResumeAfterPartialUpload(t *testing.T) {
+	ts := newTestServer(t)
+	c := newClient(t, ts)
+	ctx := context.Background()
+	data := content(5*testChunkSize + 10)
+
+	// Chunk 3 is refused for good, ending the first attempt
+	refused := ts.inject(fault{method: http.MethodPut, path: regexp.MustCompile(`/3$`), times: -1, status: http.StatusBadRequest})
+	var sess client.Session
+	_, err := c.Upload(ctx, bytes.NewReader(data), client.UploadOptions{
+		Filename:   "partial.bin",
+		ChunkSize:  testChunkSize,
+		Workers:    1,
+		MaxWorkers: 1, // chunks go one by one, so 0 to 2 arrive before 3 fails
+		OnSession:  func(s client.Session) { sess = s },
+	})
+	if !client.IsStatus(err, http.StatusBadRequest) {
+		t.Fatalf("first attempt: %v, want the injected 400", err)
+	}
+	if sess.UploadID == "" {
+		t.Fatal("OnSession was not called")
+	}
+	ts.clear(refused)
+
+	status, err := c.Status(ctx, sess.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := append([]int(nil), status.ReceivedChunks...)
+	sort.Ints(held)
+	if fmt.Sprint(held) != "[0 1 2]" {
+		t.Fatalf("server holds chunks %v after the first attempt, want [0 1 2]", held)
+	}
+
+	before := ts.count(http.MethodPut, "^/v1/upload/")
+	var last client.Progress
+	res, err := c.Resume(ctx, bytes.NewReader(data), sess, client.UploadOptions{
+		Progress: func(p client.Progress) { last = p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Complete.Status != "assembled" || res.Complete.FileHash != sess.FileHash {
+		t.Errorf("complete = %+v", res.Complete)
+	}
+	if sent := ts.count(http.MethodPut, "^/v1/upload/") - before; sent != 6-len(held) {
+		t.Errorf("resume sent %d chunks, want the %d missing", sent, 6-len(held))
+	}
+	if last.Resumed != int64(len(held))*testChunkSize || last.Resumed+last.Sent != int64(len(data)) {
+		t.Errorf("last progress = %+v with chunks %v held", last, held)
+	}
+
+	var got bytes.Buffer
+	if _, err := c.Download(ctx, sess.ShareID, sess.UploadID, sess.Filename, &got); err != nil || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("download after resume: %v", err)
+	}
+
+	// A session the server no longer holds has to start over
+	sess.UploadID = "resume-forgotten"
+	if _, err := c.Resume(ctx, bytes.NewReader(data), sess, client.UploadOptions{}); !client.IsCode(err, models.CodeUploadNotFound) {
+		t.Errorf("resume of an unknown session: %v, want upload_not_found", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ts := newTestServer(t)
+	c := newClient(t, ts)
+	ctx := context.Background()
+
+	first, err := c.Upload(ctx, bytes.NewReader(content(100)), client.UploadOptions{Filename: "first.txt", ChunkSize: testChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shareID := first.Session.ShareID
+
+	watchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	events, err := c.Watch(watchCtx, shareID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() models.RoomEvent {
+		t.Helper()
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatal("event stream closed")
+			}
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event within 5s")
+		}
+		return models.RoomEvent{}
+	}
+	if ev := next(); ev.Type != "room_state" || ev.ShareID != shareID {
+		t.Fatalf("first event = %+v, want room_state", ev)
+	}
+
+	second, err := c.Upload(ctx, bytes.NewReader(content(2*testChunkSize)), client.UploadOptions{
+		Filename:  "second.txt",
+		ShareID:   shareID,
+		ChunkSize: testChunkSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]int{}
+	for seen[models.EventUploadComplete] == 0 {
+		ev := next()
+		if ev.UploadID != second.Session.UploadID {
+			continue
+		}
+		seen[ev.Type]++
+	}
+	if seen[models.EventUploadStart] != 1 || seen["chunk_received"] != 2 {
+		t.Errorf("events of the second upload = %v", seen)
+	}
+
+	stop()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("event channel still open after its context ended")
+		}
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	ts := newTestServer(t)
+	c := newClient(t, ts)
+
+	// Every chunk is turned away for a minute; only the context ends the wait
+	ts.inject(fault{method: http.MethodPut, path: regexp.MustCompile(`^/v1/upload/`), times: -1, status: http.StatusServiceUnavailable, retryAfter: 60})
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Upload(ctx, bytes.NewReader(content(2*testChunkSize)), client.UploadOptions{Filename: "stuck.bin", ChunkSize: testChunkSize})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("upload: %v, want the context's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("upload returned %s after its context ended", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.ListFiles(cancelled, "any"); !errors.Is(err, context.Canceled) {
+		t.Errorf("list with a cancelled context: %v", err)
+	}
+}
+
+func TestRetriesHonourRetryAfter(t *testing.T) {
+	ts := newTestServer(t)
+	c := newClient(t, ts)
+	ctx := context.Background()
+
+	init := regexp.MustCompile(`^/v1/init$`)
+	ts.inject(fault{method: http.MethodPost, path: init, times: 1, status: http.StatusTooManyRequests, retryAfter: 1})
+	ts.inject(fault{method: http.MethodPost, path: init, times: 1, status: http.StatusServiceUnavailable, retryAfter: 1})
+	ts.inject(fault{method: http.MethodPut, path: regexp.MustCompile(`/0$`), times: 1, status: http.StatusServiceUnavailable})
+
+	start := time.Now()
+	res, err := c.Upload(ctx, bytes.NewReader(content(testChunkSize+1)), client.UploadOptions{Filename: "busy.bin", ChunkSize: testChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The policy's own delays are milliseconds; the server asked for a second twice
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("upload took %s, want the 2s of Retry-After waited out", elapsed)
+	}
+	if n := ts.count(http.MethodPost, "^/v1/init$"); n != 3 {
+		t.Errorf("%d init requests, want 3", n)
+	}
+	if n := ts.count(http.MethodPut, "/0$"); n != 2 {
+		t.Errorf("chunk 0 sent %d times, want 2", n)
+	}
+	if res.Complete.Status != "assembled" {
+		t.Errorf("complete = %+v", res.Complete)
+	}
+
+	// A full disk does not pass by retrying
+	ts.inject(fault{method: http.MethodPost, path: init, times: -1, status: http.StatusInsufficientStorage})
+	before := ts.count(http.MethodPost, "^/v1/init$")
+	_, err = c.Upload(ctx, bytes.NewReader(content(10)), client.UploadOptions{Filename: "full.bin"})
+	if !client.IsStatus(err, http.StatusInsufficientStorage) {
+		t.Errorf("upload to a full server: %v", err)
+	}
+	if n := ts.count(http.MethodPost, "^/v1/init$") - before; n != 1 {
+		t.Errorf("507 was sent %d times, want 1", n)
+	}
+}
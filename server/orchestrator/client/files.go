@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"aetherlink/models"
+)
+
+// ListFiles returns the uploads of a share, newest first
+func (c *Client) ListFiles(ctx context.Context, shareID string) ([]models.FileMetadata, error) {
+	var resp models.FilesResponse
+	if err := c.call(ctx, http.MethodGet, "/files?share_id="+url.QueryEscape(shareID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Files, nil
+}
+
+// FileInfo returns one upload of a share, including its sink pushes
+func (c *Client) FileInfo(ctx context.Context, shareID, uploadID string) (*models.FileMetadata, error) {
+	var file models.FileMetadata
+	path := "/file/" + url.PathEscape(uploadID) + "?share_id=" + url.QueryEscape(shareID)
+	if err := c.call(ctx, http.MethodGet, path, nil, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Download writes a completed file of a share to w and returns the bytes
+// written. A transfer cut off midway is resumed from where it stopped, with
+// a Range request where the server honours one.
+func (c *Client) Download(ctx context.Context, shareID, uploadID, filename string, w io.Writer) (int64, error) {
+	path := fmt.Sprintf("/download/%s/%s?share_id=%s",
+		url.PathEscape(uploadID), url.PathEscape(filename), url.QueryEscape(shareID))
+	var written int64
+	err := c.withRetry(ctx, func() error {
+		req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return err
+		}
+		if written > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(written, 10)+"-")
+		}
+		resp, err := c.send(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body := io.Reader(resp.Body)
+		if written > 0 && resp.StatusCode != http.StatusPartialContent {
+			// The whole file again; skip what w already has
+			if _, err := io.CopyN(io.Discard, body, written); err != nil {
+				return err
+			}
+		}
+		ew := &errWriter{w: w}
+		n, err := io.Copy(ew, body)
+		written += n
+		if ew.err != nil {
+			return permanentError{ew.err}
+		}
+		return err
+	})
+	return written, err
+}
+
+// errWriter remembers a failed write, telling it apart from a failed read
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	n, err := ew.w.Write(p)
+	if err != nil {
+		ew.err = err
+	}
+	return n, err
+}
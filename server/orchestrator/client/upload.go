@@ -0,0 +1,508 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"aetherlink/models"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// DefaultChunkSize is used until the server advises otherwise
+	DefaultChunkSize = 1 << 20
+	minChunkSize     = 256 << 10
+	maxChunkSize     = 64 << 20
+	// Very large files get bigger chunks rather than more of them
+	maxChunksPerFile = 10000
+
+	defaultWorkers    = 4
+	defaultMaxWorkers = 16
+)
+
+var (
+	// ErrContentChanged means the data no longer matches the hashes declared
+	// when its upload started
+	ErrContentChanged = errors.New("content changed since its upload started")
+	// ErrUnknownSize means the size of an upload was neither given nor
+	// discoverable from its reader
+	ErrUnknownSize = errors.New("upload size unknown, set UploadOptions.Size")
+)
+
+// Session is what an interrupted upload needs to resume: the session it
+// opened and the chunk layout it declared. Callers persist it (it marshals
+// to JSON) and pass it to Resume.
+type Session struct {
+	UploadID    string   `json:"upload_id"`
+	ShareID     string   `json:"share_id"`
+	Filename    string   `json:"filename"`
+	Size        int64    `json:"size"`
+	ChunkSize   int64    `json:"chunk_size"`
+	ChunkHashes []string `json:"chunk_hashes"`
+	FileHash    string   `json:"file_hash"`
+}
+
+// Progress reports an upload's bytes on the server
+type Progress struct {
+	UploadID string
+	Resumed  int64 // bytes the server held before this call
+	Sent     int64 // bytes sent by this call
+	Total    int64
+}
+
+// UploadOptions configure Upload and Resume
+type UploadOptions struct {
+	Filename string // name of the file on the server, required by Upload
+	Size     int64  // bytes to upload; found from the reader when it has a Size method or is an *os.File
+	ShareID  string // share to upload into; empty opens a new share
+	UploadID string // defaults to the file name and a timestamp
+
+	// ChunkSize fixes the chunk size; by default the server's latest advice
+	// or DefaultChunkSize is used, grown so a file needs at most 10000 chunks
+	ChunkSize int64
+	// Workers is the number of chunks sent in parallel at first (default 4);
+	// the server's advice moves it between 1 and MaxWorkers (default 16)
+	Workers    int
+	MaxWorkers int
+
+	// OnSession is called once the session was opened, before any chunk is
+	// sent, so the caller can persist it for Resume
+	OnSession func(Session)
+	// Progress is called after every chunk; calls are serialized
+	Progress func(Progress)
+}
+
+// Result describes a completed upload
+type Result struct {
+	Session  Session
+	Complete models.CompleteResult // zero when an earlier attempt already assembled the file
+}
+
+// Upload hashes the data of r, opens an upload session, sends the chunks in
+// parallel and has the server assemble them
+func (c *Client) Upload(ctx context.Context, r io.ReaderAt, opts UploadOptions) (*Result, error) {
+	if opts.Filename == "" {
+		return nil, errors.New("UploadOptions.Filename required")
+	}
+	size, err := readerSize(r, opts.Size)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := c.chunkSize(opts.ChunkSize, size)
+	hashes, fileHash, err := hashChunks(r, size, chunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("hash: %w", err)
+	}
+
+	uploadID := opts.UploadID
+	if uploadID == "" {
+		uploadID = NewUploadID(opts.Filename)
+	}
+	md := models.Metadata{
+		UploadID:    uploadID,
+		Filename:    opts.Filename,
+		TotalChunks: len(hashes),
+		ChunkSize:   chunkSize,
+		ChunkHashes: hashes,
+		FileHash:    fileHash,
+		ShareID:     opts.ShareID,
+		FileSize:    size,
+		Priority:    c.priority,
+	}
+	var resp models.InitResponse
+	if err := c.call(ctx, http.MethodPost, "/init", md, &resp); err != nil {
+		return nil, fmt.Errorf("init: %w", err)
+	}
+
+	sess := Session{
+		UploadID:    resp.UploadID,
+		ShareID:     resp.ShareID,
+		Filename:    opts.Filename,
+		Size:        size,
+		ChunkSize:   chunkSize,
+		ChunkHashes: hashes,
+		FileHash:    fileHash,
+	}
+	if opts.OnSession != nil {
+		opts.OnSession(sess)
+	}
+	return c.newUpload(r, sess, opts).run(ctx, nil)
+}
+
+// Resume continues the upload of sess from r, sending only the chunks the
+// server is missing. Every chunk sent is checked against the hash declared
+// at /init, so a changed source fails with ErrContentChanged. When the
+// server no longer holds the session the error satisfies
+// IsCode(err, models.CodeUploadNotFound) and the upload must start over.
+func (c *Client) Resume(ctx context.Context, r io.ReaderAt, sess Session, opts UploadOptions) (*Result, error) {
+	status, err := c.Status(ctx, sess.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	return c.newUpload(r, sess, opts).run(ctx, status.ReceivedChunks)
+}
+
+// Status returns the chunks the server received for an upload
+func (c *Client) Status(ctx context.Context, uploadID string) (*models.UploadStatus, error) {
+	var status models.UploadStatus
+	if err := c.call(ctx, http.MethodGet, "/status/"+url.PathEscape(uploadID), nil, &status); err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+	return &status, nil
+}
+
+// Cancel deletes an incomplete upload from the server
+func (c *Client) Cancel(ctx context.Context, uploadID string) error {
+	return c.call(ctx, http.MethodDelete, "/cleanup/"+url.PathEscape(uploadID), nil, nil)
+}
+
+// chunkSize picks the chunk size of a new upload: the caller's, else the
+// server's latest advice, else the default, grown so very large files do
+// not need more than maxChunksPerFile chunks
+func (c *Client) chunkSize(fixed, size int64) int64 {
+	if fixed > 0 {
+		return fixed
+	}
+	cs := int64(DefaultChunkSize)
+	if a, ok := c.Advice(); ok && a.ChunkSize > 0 {
+		cs = a.ChunkSize
+	}
+	if least := (size + maxChunksPerFile - 1) / maxChunksPerFile; cs < least {
+		cs = least
+	}
+	return min(max(cs, minChunkSize), maxChunkSize)
+}
+
+// upload sends the chunks of one session
+type upload struct {
+	c    *Client
+	r    io.ReaderAt
+	sess Session
+	opts UploadOptions
+
+	mu       sync.Mutex
+	progress Progress
+}
+
+func (c *Client) newUpload(r io.ReaderAt, sess Session, opts UploadOptions) *upload {
+	if opts.Workers < 1 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.MaxWorkers < 1 {
+		opts.MaxWorkers = defaultMaxWorkers
+	}
+	if opts.MaxWorkers < opts.Workers {
+		opts.MaxWorkers = opts.Workers
+	}
+	return &upload{
+		c:        c,
+		r:        r,
+		sess:     sess,
+		opts:     opts,
+		progress: Progress{UploadID: sess.UploadID, Total: sess.Size},
+	}
+}
+
+// run sends every chunk not in received and completes the upload
+func (u *upload) run(ctx context.Context, received []int) (*Result, error) {
+	done := make(map[int]bool, len(received))
+	for _, idx := range received {
+		if idx >= 0 && idx < len(u.sess.ChunkHashes) && !done[idx] {
+			done[idx] = true
+			u.progress.Resumed += u.chunkLen(idx)
+		}
+	}
+	var missing []int
+	for idx := range u.sess.ChunkHashes {
+		if !done[idx] {
+			missing = append(missing, idx)
+		}
+	}
+	u.report(0)
+
+	if err := u.sendChunks(ctx, missing); err != nil {
+		return nil, err
+	}
+	complete, err := u.complete(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Session: u.sess, Complete: complete}, nil
+}
+
+func (u *upload) report(sent int64) {
+	if u.opts.Progress == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.progress.Sent += sent
+	u.opts.Progress(u.progress)
+}
+
+// sendChunks uploads the given chunks in parallel. The number of chunks in
+// flight starts at Workers and follows the server's advice.
+func (u *upload) sendChunks(ctx context.Context, idxs []int) error {
+	if len(idxs) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lim := newLimiter(u.opts.Workers)
+
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failErr  error
+	)
+	jobs := make(chan int)
+	for i := 0; i < u.opts.MaxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf []byte
+			for idx := range jobs {
+				if err := lim.acquire(ctx); err != nil {
+					return
+				}
+				if buf == nil {
+					buf = make([]byte, u.sess.ChunkSize)
+				}
+				n, advice, err := u.sendChunk(ctx, idx, buf)
+				lim.release()
+				if err != nil {
+					failOnce.Do(func() {
+						failErr = fmt.Errorf("chunk %d: %w", idx, err)
+						cancel()
+					})
+					return
+				}
+				u.report(n)
+				if advice != nil {
+					u.c.setAdvice(*advice)
+					if advice.MaxWorkers > 0 {
+						lim.setLimit(min(advice.MaxWorkers, u.opts.MaxWorkers))
+					}
+					if sleep(ctx, time.Duration(advice.BackoffMs)*time.Millisecond) != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, idx := range idxs {
+		select {
+		case jobs <- idx:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if failErr != nil {
+		return failErr
+	}
+	return ctx.Err()
+}
+
+// sendChunk reads chunk idx into buf, checks it against the hash declared
+// at /init and PUTs it, retrying temporary failures
+func (u *upload) sendChunk(ctx context.Context, idx int, buf []byte) (int64, *models.ChunkAdvice, error) {
+	n := u.chunkLen(idx)
+	data := buf[:n]
+	if _, err := u.r.ReadAt(data, int64(idx)*u.sess.ChunkSize); err != nil && !errors.Is(err, io.EOF) {
+		return 0, nil, err
+	}
+	if HashChunk(data) != u.sess.ChunkHashes[idx] {
+		return 0, nil, ErrContentChanged
+	}
+
+	var receipt models.ChunkReceipt
+	path := fmt.Sprintf("/upload/%s/%d", url.PathEscape(u.sess.UploadID), idx)
+	err := u.c.withRetry(ctx, func() error {
+		req, err := u.c.newRequest(ctx, http.MethodPut, path, data)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := u.c.send(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return json.NewDecoder(resp.Body).Decode(&receipt)
+	})
+	if IsCode(err, models.CodeUploadCompleted) {
+		// Assembled by an earlier attempt whose /complete response was lost
+		return n, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, &receipt.Advice, nil
+}
+
+// complete asks the server to assemble the file. Chunks the server reports
+// missing are sent again once before giving up.
+func (u *upload) complete(ctx context.Context) (models.CompleteResult, error) {
+	var result models.CompleteResult
+	path := "/complete/" + url.PathEscape(u.sess.UploadID)
+	for attempt := 0; ; attempt++ {
+		err := u.c.call(ctx, http.MethodPost, path, nil, &result)
+		var e *Error
+		if !errors.As(err, &e) {
+			return result, err
+		}
+		switch {
+		case e.Code == models.CodeUploadCompleted:
+			return result, nil // already assembled
+		case e.Code == models.CodeChunksMissing && attempt == 0:
+			var incomplete struct {
+				Error struct {
+					Details struct {
+						MissingChunks []int `json:"missing_chunks"`
+					} `json:"details"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(e.Body, &incomplete) != nil || len(incomplete.Error.Details.MissingChunks) == 0 {
+				return result, fmt.Errorf("complete: %w", err)
+			}
+			if err := u.sendChunks(ctx, incomplete.Error.Details.MissingChunks); err != nil {
+				return result, err
+			}
+		default:
+			return result, fmt.Errorf("complete: %w", err)
+		}
+	}
+}
+
+// chunkLen returns the length of chunk idx, the last one may be short
+func (u *upload) chunkLen(idx int) int64 {
+	return min(u.sess.ChunkSize, u.sess.Size-int64(idx)*u.sess.ChunkSize)
+}
+
+// readerSize returns size when set, else the size r reports
+func readerSize(r io.ReaderAt, size int64) (int64, error) {
+	if size > 0 {
+		return size, nil
+	}
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), nil
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	return 0, ErrUnknownSize
+}
+
+// hashChunks returns the xxhash of every chunk and of the whole data
+func hashChunks(r io.ReaderAt, size, chunkSize int64) ([]string, string, error) {
+	total := xxhash.New()
+	buf := make([]byte, min(chunkSize, max(size, 1)))
+	hashes := make([]string, 0, (size+chunkSize-1)/chunkSize)
+	for off := int64(0); off < size; off += chunkSize {
+		n := min(chunkSize, size-off)
+		if _, err := r.ReadAt(buf[:n], off); err != nil && !errors.Is(err, io.EOF) {
+			return nil, "", err
+		}
+		hashes = append(hashes, HashChunk(buf[:n]))
+		total.Write(buf[:n])
+	}
+	return hashes, hex.EncodeToString(total.Sum(nil)), nil
+}
+
+// HashChunk returns the hex xxhash the server checks chunks and files against
+func HashChunk(data []byte) string {
+	h := xxhash.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewUploadID derives an upload ID from a file name, like the web client
+func NewUploadID(filename string) string {
+	clean := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return -1
+	}, filename)
+	// The server refuses IDs containing ".." or longer than 128 bytes
+	for strings.Contains(clean, "..") {
+		clean = strings.ReplaceAll(clean, "..", ".")
+	}
+	if len(clean) > 100 {
+		clean = clean[:100]
+	}
+	return clean + "-" + strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+// limiter bounds the chunks in flight; the bound can change while workers
+// wait on it
+type limiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+}
+
+func newLimiter(limit int) *limiter {
+	l := &limiter{limit: max(limit, 1)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits for a free slot, or until ctx ends
+func (l *limiter) acquire(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.active++
+	return nil
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Signal()
+}
+
+func (l *limiter) setLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = max(n, 1)
+	l.cond.Broadcast()
+}
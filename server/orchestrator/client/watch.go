@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"aetherlink/models"
+)
+
+// defaultReconnectDelay is waited before reconnecting a dropped stream
+// unless the server sent a retry: field
+const defaultReconnectDelay = time.Second
+
+// Watch follows the events of a share's room: a room_state snapshot, then
+// upload_start, chunk_received, upload_complete and the other room events
+// as they happen. A dropped connection is resumed from the last event
+// received, so nothing the server still holds is missed, and every
+// reconnect starts with a fresh room_state. The channel is closed when ctx
+// ends or the stream cannot be resumed.
+func (c *Client) Watch(ctx context.Context, shareID string) (<-chan models.RoomEvent, error) {
+	ch := make(chan models.RoomEvent, 64)
+	err := c.watch(ctx, "/room/"+url.PathEscape(shareID)+"/events", func(data []byte) bool {
+		var ev models.RoomEvent
+		if json.Unmarshal(data, &ev) != nil {
+			return true
+		}
+		select {
+		case ch <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(ch) })
+	return ch, err
+}
+
+// WatchUpload follows the progress of one upload, starting with its current
+// state. Like Watch it reconnects on its own and closes the channel when
+// ctx ends or the stream cannot be resumed.
+func (c *Client) WatchUpload(ctx context.Context, uploadID string) (<-chan models.UploadProgress, error) {
+	ch := make(chan models.UploadProgress, 64)
+	err := c.watch(ctx, "/events/"+url.PathEscape(uploadID), func(data []byte) bool {
+		var msg struct {
+			models.UploadProgress
+			Type string `json:"type"`
+		}
+		// Typed messages are notices such as server_shutdown, not progress
+		if json.Unmarshal(data, &msg) != nil || msg.Type != "" {
+			return true
+		}
+		select {
+		case ch <- msg.UploadProgress:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(ch) })
+	return ch, err
+}
+
+// eventStream is one SSE stream followed across reconnects
+type eventStream struct {
+	c       *Client
+	path    string
+	lastID  string        // id of the last event read, sent as Last-Event-ID
+	delay   time.Duration // reconnect delay, set by the server's retry: field
+	deliver func(data []byte) bool
+}
+
+// errStopped means the consumer stopped taking events
+var errStopped = errors.New("stream stopped")
+
+// watch connects to an SSE stream and hands the data of every event to
+// deliver from a goroutine until ctx ends, the stream cannot be reconnected
+// or deliver returns false; done is called then. Only the first connection
+// attempt's error is returned.
+func (c *Client) watch(ctx context.Context, path string, deliver func([]byte) bool, done func()) error {
+	s := &eventStream{c: c, path: path, delay: defaultReconnectDelay, deliver: deliver}
+	body, err := s.connect(ctx)
+	if err != nil {
+		done()
+		return err
+	}
+	go func() {
+		defer done()
+		for {
+			err := s.read(body)
+			body.Close()
+			if errors.Is(err, errStopped) || ctx.Err() != nil {
+				return
+			}
+			if sleep(ctx, s.delay) != nil {
+				return
+			}
+			if body, err = s.connect(ctx); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// connect opens the stream, resuming after lastID when set
+func (s *eventStream) connect(ctx context.Context) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := s.c.withRetry(ctx, func() error {
+		req, err := s.c.newRequest(ctx, http.MethodGet, s.path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if s.lastID != "" {
+			req.Header.Set("Last-Event-ID", s.lastID)
+		}
+		resp, err := s.c.send(req)
+		if err != nil {
+			return err
+		}
+		body = resp.Body
+		return nil
+	})
+	return body, err
+}
+
+// read dispatches the events of one connection until it ends
+func (s *eventStream) read(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var data []string
+	var id string
+	hasID := false
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			// A blank line ends the event
+			if hasID {
+				s.lastID = id
+			}
+			if len(data) > 0 && !s.deliver([]byte(strings.Join(data, "\n"))) {
+				return errStopped
+			}
+			data, id, hasID = data[:0], "", false
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment, e.g. keepalive
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "id":
+			id, hasID = value, true
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				s.delay = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
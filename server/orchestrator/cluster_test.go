@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+	"aetherlink/services"
+)
+
+// testNode is one orchestrator process of a local test cluster
+type testNode struct {
+	id   string
+	url  string
+	root string // working directory; storage is root/storage
+}
+
+// clusterAdminToken is the admin token every test node shares
+const clusterAdminToken = "cluster-admin"
+
+// startCluster builds the orchestrator and runs n processes on localhost,
+// each in its own directory so no storage, event log or vault is shared
+func startCluster(t *testing.T, n int) []testNode {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("starts orchestrator processes")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "aetherlink")
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+
+	nodes := make([]testNode, n)
+	var members []string
+	for i := range nodes {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		nodes[i] = testNode{
+			id:   fmt.Sprintf("n%d", i),
+			url:  "http://" + addr,
+			root: filepath.Join(dir, fmt.Sprintf("n%d", i)),
+		}
+		members = append(members, nodes[i].id+"="+nodes[i].url)
+		if err := os.MkdirAll(nodes[i].root, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, node := range nodes {
+		cmd := exec.Command(bin)
+		cmd.Dir = node.root
+		cmd.Env = append(os.Environ(),
+			config.EnvServerAddr+"="+strings.TrimPrefix(node.url, "http://"),
+			config.EnvClusterNodes+"="+strings.Join(members, ","),
+			config.EnvNodeID+"="+node.id,
+			config.EnvClusterSecret+"=test-secret",
+			config.EnvAdminToken+"="+clusterAdminToken,
+			"CLOUDINARY_CLOUD_NAME=",
+			"LOG_LEVEL=warn",
+		)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+	}
+
+	for _, node := range nodes {
+		deadline := time.Now().Add(20 * time.Second)
+		for {
+			resp, err := http.Get(node.url + "/health")
+			if err == nil {
+				resp.Body.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("node %s did not start: %v", node.id, err)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return nodes
+}
+
+func doJSON(t *testing.T, method, url string, header http.Header, body []byte, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func TestClusterRoutesChunksToOwner(t *testing.T) {
+	nodes := startCluster(t, 3)
+
+	var members []config.ClusterNode
+	for _, n := range nodes {
+		members = append(members, config.ClusterNode{ID: n.id, URL: n.url})
+	}
+	services.InitCluster(config.ClusterSettings{NodeID: nodes[0].id, Nodes: members})
+	defer func() { services.Cluster = nil }()
+
+	// Pick an upload owned by the last node, so the first one forwards
+	var uploadID string
+	for i := 0; uploadID == ""; i++ {
+		if id := fmt.Sprintf("cluster-%d", i); services.Cluster.Owner(id).ID == nodes[2].id {
+			uploadID = id
+		}
+	}
+	owner := nodes[2]
+
+	chunks := [][]byte{[]byte("alpha-"), []byte("bravo-"), []byte("char")}
+	var hashes []string
+	for _, c := range chunks {
+		hashes = append(hashes, helpers.HashChunk(c))
+	}
+	md := models.Metadata{
+		UploadID:    uploadID,
+		Filename:    "cluster.txt",
+		TotalChunks: len(chunks),
+		ChunkSize:   int64(len(chunks[0])),
+		ChunkHashes: hashes,
+		FileSize:    int64(len(bytes.Join(chunks, nil))),
+	}
+	body, _ := json.Marshal(md)
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	var init models.InitResponse
+	if code := doJSON(t, http.MethodPost, nodes[0].url+"/v1/init", jsonHeader, body, &init); code != http.StatusCreated {
+		t.Fatalf("init through %s: status %d", nodes[0].id, code)
+	}
+
+	// Each chunk enters through a different node
+	for i, c := range chunks {
+		node := nodes[i%len(nodes)]
+		url := fmt.Sprintf("%s/v1/upload/%s/%d", node.url, uploadID, i)
+		if code := doJSON(t, http.MethodPut, url, nil, c, nil); code != http.StatusOK {
+			t.Fatalf("chunk %d through %s: status %d", i, node.id, code)
+		}
+	}
+
+	// A client claiming to be a peer is still forwarded
+	spoofed := http.Header{services.HeaderForwardedBy: {nodes[2].id}}
+	url := fmt.Sprintf("%s/v1/upload/%s/0", nodes[1].url, uploadID)
+	if code := doJSON(t, http.MethodPut, url, spoofed, chunks[0], nil); code != http.StatusOK {
+		t.Fatalf("spoofed chunk: status %d", code)
+	}
+
+	var status models.UploadStatus
+	if code := doJSON(t, http.MethodGet, nodes[1].url+"/v1/status/"+uploadID, nil, nil, &status); code != http.StatusOK {
+		t.Fatalf("status: %d", code)
+	}
+	sort.Ints(status.ReceivedChunks)
+	if fmt.Sprint(status.ReceivedChunks) != "[0 1 2]" {
+		t.Fatalf("owner received %v, want [0 1 2]", status.ReceivedChunks)
+	}
+	for _, n := range nodes {
+		_, err := os.Stat(filepath.Join(n.root, "storage", uploadID))
+		if stored := err == nil; stored != (n.id == owner.id) {
+			t.Errorf("node %s stores the upload: %v", n.id, stored)
+		}
+	}
+
+	if code := doJSON(t, http.MethodPost, nodes[0].url+"/v1/complete/"+uploadID, nil, nil, nil); code != http.StatusOK {
+		t.Fatalf("complete: status %d", code)
+	}
+
+	// Listings through a node that owns nothing include the owner's upload
+	var files models.FilesResponse
+	doJSON(t, http.MethodGet, nodes[1].url+"/v1/files?share_id="+init.ShareID, nil, nil, &files)
+	if files.Count != 1 || files.Files[0].UploadID != uploadID || files.Files[0].Status != models.FileComplete {
+		t.Errorf("files through %s: %+v", nodes[1].id, files)
+	}
+	var room models.RoomState
+	doJSON(t, http.MethodGet, nodes[0].url+"/v1/room/"+init.ShareID, nil, nil, &room)
+	if len(room.CompletedFiles) != 1 || room.CompletedFiles[0].UploadID != uploadID {
+		t.Errorf("room through %s: %+v", nodes[0].id, room)
+	}
+
+	// So do the admin listings, which each peer authorizes itself
+	admin := http.Header{"Authorization": {"Bearer " + clusterAdminToken}}
+	var uploads struct {
+		Uploads []models.UploadSummary `json:"uploads"`
+	}
+	if code := doJSON(t, http.MethodGet, nodes[1].url+"/v1/admin/uploads?state=completed", admin, nil, &uploads); code != http.StatusOK {
+		t.Fatalf("admin uploads: status %d", code)
+	}
+	if len(uploads.Uploads) != 1 || uploads.Uploads[0].UploadID != uploadID {
+		t.Errorf("admin uploads through %s: %+v", nodes[1].id, uploads)
+	}
+	var shares struct {
+		Shares []models.ShareSummary `json:"shares"`
+	}
+	doJSON(t, http.MethodGet, nodes[1].url+"/v1/admin/shares", admin, nil, &shares)
+	if len(shares.Shares) != 1 || shares.Shares[0].ShareID != init.ShareID || shares.Shares[0].Completed != 1 {
+		t.Errorf("admin shares through %s: %+v", nodes[1].id, shares)
+	}
+
+	// Event streams are served by the owner
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(nodes[0].url + "/v1/events/" + uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); resp.StatusCode != http.StatusTemporaryRedirect || !strings.HasPrefix(loc, owner.url) {
+		t.Errorf("events through %s: %d %q, want redirect to %s", nodes[0].id, resp.StatusCode, loc, owner.url)
+	}
+}
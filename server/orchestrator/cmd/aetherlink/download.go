@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"aetherlink/client"
+	"aetherlink/models"
+)
+
+const downloadUsage = `usage: aetherlink download -share ID [flags] [name|upload_id...]
+
+Downloads the completed files of a share into -o, all of them or those
+named by file name or upload ID. Files already present with the expected
+size are skipped, so an interrupted download can be run again.
+`
+
+func downloadCommand(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("aetherlink download", flag.ContinueOnError)
+	server := flags.String("server", defaultServer(), "orchestrator URL, also "+EnvServer)
+	shareID := flags.String("share", "", "share to download from (required)")
+	outDir := flags.String("o", ".", "directory to write the files to")
+	decompress := flags.Bool("decompress", false, "gunzip .gz files uploaded with -compress")
+	priority := flags.String("priority", "", "scheduling priority: high, normal or bulk")
+	retries := flags.Int("retries", client.DefaultRetryPolicy.Attempts, "attempts per request before giving up")
+	quiet := flags.Bool("q", false, "do not draw progress bars")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), downloadUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseStatus(err)
+	}
+	if *shareID == "" {
+		flags.Usage()
+		return 2
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return fail(err)
+	}
+
+	c, err := newClient(*server, *priority, *retries)
+	if err != nil {
+		return fail(err)
+	}
+	files, err := c.ListFiles(ctx, *shareID)
+	if err != nil {
+		return fail(fmt.Errorf("list share: %w", err))
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range flags.Args() {
+		wanted[name] = true
+	}
+	var downloaded int
+	for _, file := range files {
+		if len(wanted) > 0 && !wanted[file.Filename] && !wanted[file.UploadID] {
+			continue
+		}
+		if file.Status != models.FileComplete {
+			fmt.Fprintf(os.Stderr, "skipping %s: upload %s is %s\n", file.Filename, file.UploadID, file.Status)
+			continue
+		}
+		path, err := download(ctx, c, *shareID, file, *outDir, *decompress, *quiet)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", file.Filename, err))
+		}
+		fmt.Println(path)
+		downloaded++
+	}
+	if downloaded == 0 {
+		return fail(errors.New("no completed files to download"))
+	}
+	return 0
+}
+
+// download fetches one file into dir through a .part file and returns the
+// path written
+func download(ctx context.Context, c *client.Client, shareID string, file models.FileMetadata, dir string, decompress, quiet bool) (string, error) {
+	// The name comes from the server; never let it leave dir
+	name := filepath.Base(filepath.Clean("/" + file.Filename))
+	gunzip := decompress && strings.HasSuffix(name, ".gz")
+	dest := filepath.Join(dir, name)
+	if gunzip {
+		dest = strings.TrimSuffix(dest, ".gz")
+	} else if info, err := os.Stat(dest); err == nil && info.Size() == file.FileSize {
+		fmt.Fprintf(os.Stderr, "%s already downloaded\n", name)
+		return dest, nil
+	}
+
+	part := filepath.Join(dir, name+".part")
+	out, err := os.Create(part)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(part)
+	bar := newProgress(os.Stderr, name, file.FileSize, quiet)
+	_, err = c.Download(ctx, shareID, file.UploadID, file.Filename, io.MultiWriter(out, bar))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && gunzip {
+		err = gunzipFile(part, dest+".part")
+		part = dest + ".part"
+		defer os.Remove(part)
+	}
+	if err != nil {
+		bar.finish("failed")
+		return "", err
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return "", err
+	}
+	bar.finish("done")
+	return dest, nil
+}
+
+// gunzipFile decompresses src into dst
+func gunzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, gz); err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
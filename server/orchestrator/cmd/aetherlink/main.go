@@ -0,0 +1,85 @@
+// Command aetherlink uploads files to an orchestrator and downloads the files
+// of a share. Uploads are split into xxhash-verified chunks sent in parallel;
+// an interrupted upload resumes from the state file written next to the file.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"aetherlink/client"
+)
+
+// EnvServer names the default orchestrator URL
+const EnvServer = "AETHERLINK_SERVER"
+
+const usage = `usage: aetherlink <command> [flags] [args]
+
+  upload    upload files into a share, resuming interrupted uploads
+  download  download the completed files of a share
+
+Run aetherlink <command> -h for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var code int
+	switch os.Args[1] {
+	case "upload":
+		code = uploadCommand(ctx, os.Args[2:])
+	case "download":
+		code = downloadCommand(ctx, os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		code = 2
+	}
+	stop()
+	os.Exit(code)
+}
+
+// defaultServer returns the server URL from the environment, or localhost
+func defaultServer() string {
+	if v := os.Getenv(EnvServer); v != "" {
+		return v
+	}
+	return "http://localhost:8080"
+}
+
+// newClient returns a client of server retrying each request attempts times
+func newClient(server, priority string, attempts int) (*client.Client, error) {
+	policy := client.DefaultRetryPolicy
+	policy.Attempts = attempts
+	return client.NewClient(server, client.WithPriority(priority), client.WithRetry(policy))
+}
+
+// parseStatus maps a flag parse error to an exit code; -h is not a failure
+func parseStatus(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// fail prints err and returns the exit code for it; an interrupt exits 130
+func fail(err error) int {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		return 130
+	}
+	fmt.Fprintln(os.Stderr, "aetherlink:", err)
+	return 1
+}
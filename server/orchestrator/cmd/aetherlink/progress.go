@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	progressWidth    = 24
+	progressInterval = 100 * time.Millisecond
+)
+
+// progress draws a single-line progress bar for one transfer. On anything
+// but a terminal only the final line is printed.
+type progress struct {
+	mu      sync.Mutex
+	w       io.Writer
+	live    bool
+	label   string
+	total   int64
+	done    int64
+	skipped int64 // part of done moved before this run
+	start   time.Time
+	drawn   time.Time
+	lastLen int
+}
+
+func newProgress(w io.Writer, label string, total int64, quiet bool) *progress {
+	p := &progress{w: w, label: label, total: total, start: time.Now()}
+	if quiet {
+		p.w = io.Discard
+	} else if f, ok := w.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			p.live = true
+		}
+	}
+	return p
+}
+
+// set moves the bar to done bytes, of which skipped were transferred
+// before, e.g. by an earlier run, and are not credited to the rate
+func (p *progress) set(done, skipped int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done, p.skipped = done, skipped
+	if p.live && time.Since(p.drawn) >= progressInterval {
+		p.draw("")
+	}
+}
+
+// add records n transferred bytes
+func (p *progress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if p.live && time.Since(p.drawn) >= progressInterval {
+		p.draw("")
+	}
+}
+
+// Write lets the bar count bytes copied through an io.Writer
+func (p *progress) Write(b []byte) (int, error) {
+	p.add(int64(len(b)))
+	return len(b), nil
+}
+
+// finish draws the final state of the bar followed by msg and a newline
+func (p *progress) finish(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw(msg)
+	fmt.Fprintln(p.w)
+}
+
+func (p *progress) draw(msg string) {
+	p.drawn = time.Now()
+	frac := 1.0
+	if p.total > 0 {
+		frac = float64(p.done) / float64(p.total)
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	filled := int(frac * progressWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+
+	elapsed := time.Since(p.start)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.done-p.skipped) / elapsed.Seconds()
+	}
+	line := fmt.Sprintf("%s [%s] %3.0f%% %s/%s %s/s", p.label, bar, frac*100,
+		formatBytes(p.done), formatBytes(p.total), formatBytes(int64(rate)))
+	if msg != "" {
+		line += " " + msg
+	} else if rate > 0 && p.done < p.total {
+		eta := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+		line += " ETA " + eta.Round(time.Second).String()
+	}
+
+	pad := ""
+	if n := p.lastLen - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	p.lastLen = len(line)
+	if p.live {
+		fmt.Fprint(p.w, "\r"+line+pad)
+	} else if msg != "" {
+		fmt.Fprint(p.w, line)
+	}
+}
+
+// formatBytes renders n with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
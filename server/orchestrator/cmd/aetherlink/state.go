@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"aetherlink/client"
+)
+
+// stateSuffix is appended to a file's path to name its state file
+const stateSuffix = ".aetherlink"
+
+// uploadState is written next to a file once its upload session opened and
+// removed when the upload completed. It ties the session to the file as it
+// was, so a changed file starts over instead of resuming.
+type uploadState struct {
+	Server     string         `json:"server"`
+	Size       int64          `json:"size"`
+	ModTime    time.Time      `json:"mod_time"`
+	Compressed bool           `json:"compressed,omitempty"`
+	Session    client.Session `json:"session"`
+}
+
+func statePath(path string) string {
+	return path + stateSuffix
+}
+
+// loadState reads the state file of path; a missing file is not an error
+func loadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(statePath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st uploadState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// matches reports whether st belongs to an upload of the same, unchanged
+// file to the same server
+func (st *uploadState) matches(server string, info os.FileInfo, compressed bool) bool {
+	return st.Server == server &&
+		st.Size == info.Size() &&
+		st.ModTime.Equal(info.ModTime()) &&
+		st.Compressed == compressed &&
+		st.Session.UploadID != ""
+}
+
+// save writes the state file atomically, so an interrupt cannot leave half
+func (st *uploadState) save(path string) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(statePath(path))+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), statePath(path))
+}
+
+func removeState(path string) error {
+	if err := os.Remove(statePath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
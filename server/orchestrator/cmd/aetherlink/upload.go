@@ -0,0 +1,243 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"aetherlink/client"
+	"aetherlink/models"
+)
+
+const uploadUsage = `usage: aetherlink upload [flags] file...
+
+Uploads each file into one share; the first file opens a new share unless
+-share is given. Chunks are sent in parallel and the number of workers
+follows the server's advice, between 1 and -max-workers. The server's
+suggested chunk size is used for the files that follow.
+
+An interrupted upload leaves <file>.aetherlink behind; running the same
+command again resumes it, sending only the chunks the server is missing.
+`
+
+type uploadOptions struct {
+	shareID    string
+	chunkSize  int64 // 0 sizes chunks automatically
+	workers    int
+	maxWorkers int
+	compress   bool
+	restart    bool
+	quiet      bool
+}
+
+func uploadCommand(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("aetherlink upload", flag.ContinueOnError)
+	server := flags.String("server", defaultServer(), "orchestrator URL, also "+EnvServer)
+	priority := flags.String("priority", "", "scheduling priority: high, normal or bulk")
+	retries := flags.Int("retries", client.DefaultRetryPolicy.Attempts, "attempts per request before giving up")
+	var opts uploadOptions
+	var chunkSize byteSize
+	flags.StringVar(&opts.shareID, "share", "", "share to upload into")
+	flags.Var(&chunkSize, "chunk-size", "chunk size, e.g. 4M (default: chosen from the file size and server advice)")
+	flags.IntVar(&opts.workers, "workers", 4, "parallel chunk uploads to start with")
+	flags.IntVar(&opts.maxWorkers, "max-workers", 16, "most parallel chunk uploads the server may ask for")
+	flags.BoolVar(&opts.compress, "compress", false, "gzip each file and upload it as <name>.gz")
+	flags.BoolVar(&opts.restart, "restart", false, "ignore state files and start every upload over")
+	flags.BoolVar(&opts.quiet, "q", false, "do not draw progress bars")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), uploadUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseStatus(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	opts.chunkSize = int64(chunkSize)
+
+	c, err := newClient(*server, *priority, *retries)
+	if err != nil {
+		return fail(err)
+	}
+	for _, path := range flags.Args() {
+		sess, err := upload(ctx, c, path, &opts)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", path, err))
+		}
+		fmt.Printf("%s\tupload_id=%s\tshare_id=%s\tfile_hash=%s\n", path, sess.UploadID, sess.ShareID, sess.FileHash)
+	}
+	fmt.Fprintf(os.Stderr, "download with: aetherlink download -server %s -share %s\n", c.BaseURL(), opts.shareID)
+	return 0
+}
+
+// upload sends one file, resuming its earlier upload when a state file
+// matches. Later files join the share the first one opened.
+func upload(ctx context.Context, c *client.Client, path string, opts *uploadOptions) (*client.Session, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+
+	src, name := path, filepath.Base(path)
+	if opts.compress {
+		tmp, err := compressFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("compress: %w", err)
+		}
+		defer os.Remove(tmp)
+		src, name = tmp, name+".gz"
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	srcInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	bar := newProgress(os.Stderr, name, srcInfo.Size(), opts.quiet)
+	uploadOpts := client.UploadOptions{
+		Filename:   name,
+		Size:       srcInfo.Size(),
+		ShareID:    opts.shareID,
+		ChunkSize:  opts.chunkSize,
+		Workers:    opts.workers,
+		MaxWorkers: opts.maxWorkers,
+		OnSession: func(sess client.Session) {
+			st := &uploadState{
+				Server:     c.BaseURL(),
+				Size:       info.Size(),
+				ModTime:    info.ModTime(),
+				Compressed: opts.compress,
+				Session:    sess,
+			}
+			if err := st.save(path); err != nil {
+				fmt.Fprintln(os.Stderr, "warning: upload cannot be resumed:", err)
+			}
+		},
+		Progress: func(p client.Progress) {
+			bar.set(p.Resumed+p.Sent, p.Resumed)
+		},
+	}
+
+	var result *client.Result
+	if st := resumable(c, path, info, opts); st != nil {
+		result, err = c.Resume(ctx, f, st.Session, uploadOpts)
+		if client.IsCode(err, models.CodeUploadNotFound) {
+			// Cleaned up or expired on the server
+			result, err = nil, nil
+		}
+	}
+	if result == nil && err == nil {
+		result, err = c.Upload(ctx, f, uploadOpts)
+	}
+	if errors.Is(err, client.ErrContentChanged) {
+		err = fmt.Errorf("%w, run again with -restart", err)
+	}
+	if err != nil {
+		bar.finish("failed")
+		return nil, err
+	}
+	bar.finish("done")
+	if err := removeState(path); err != nil {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+	if opts.shareID == "" {
+		opts.shareID = result.Session.ShareID
+	}
+	return &result.Session, nil
+}
+
+// resumable returns the state of an earlier upload of path that can be
+// resumed, or nil to start over
+func resumable(c *client.Client, path string, info os.FileInfo, opts *uploadOptions) *uploadState {
+	if opts.restart {
+		return nil
+	}
+	st, err := loadState(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: ignoring unreadable %s: %v\n", statePath(path), err)
+		return nil
+	}
+	if st == nil || !st.matches(c.BaseURL(), info, opts.compress) {
+		return nil
+	}
+	if opts.shareID != "" && st.Session.ShareID != opts.shareID {
+		return nil
+	}
+	return st
+}
+
+// compressFile gzips path into a temporary file. The output carries no
+// timestamp, so compressing the same file again yields the same chunks and
+// an interrupted upload can resume.
+func compressFile(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp("", "aetherlink-*.gz")
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(out)
+	gz.Name = filepath.Base(path)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// byteSize is a flag value in bytes accepting K, M and G suffixes
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	mult := int64(1)
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"aetherlink/config"
+)
+
+const configUsage = `usage: aetherlink config <print|validate> [flags]
+
+  print     print the effective configuration, secrets redacted
+  validate  check the configuration and report every problem
+
+Both accept the server's configuration flags, e.g. -config aetherlink.yaml.
+`
+
+// configCommand runs `aetherlink config ...` and returns the exit code
+func configCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	flags := config.NewFlags("aetherlink config " + args[0])
+	switch args[0] {
+	case "print":
+		format := flags.String("format", "yaml", "output format, yaml or toml")
+		if err := flags.Parse(args[1:]); err != nil {
+			return parseStatus(err)
+		}
+		cfg, err := flags.Load()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := config.Encode(os.Stdout, cfg.Redacted(), *format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+
+	case "validate":
+		if err := flags.Parse(args[1:]); err != nil {
+			return parseStatus(err)
+		}
+		if _, err := flags.Load(); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid configuration:")
+			var joined interface{ Unwrap() []error }
+			if errors.As(err, &joined) {
+				for _, e := range joined.Unwrap() {
+					fmt.Fprintln(os.Stderr, "  -", e)
+				}
+			} else {
+				fmt.Fprintln(os.Stderr, "  -", err)
+			}
+			return 1
+		}
+		fmt.Println("configuration ok")
+		return 0
+
+	case "-h", "-help", "--help", "help":
+		fmt.Print(configUsage)
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command %q\n\n%s", args[0], configUsage)
+		return 2
+	}
+}
+
+// parseStatus maps a flag parse error to an exit code; -h is not a failure
+func parseStatus(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
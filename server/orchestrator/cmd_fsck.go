@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+	"aetherlink/services"
+)
+
+const fsckUsage = `usage: aetherlink fsck [-fix] [-json] [flags]
+
+Checks every upload below the storage root for debris of interrupted writes,
+chunks that fail their hash, missing or stale .xxhash sidecars and
+received.json entries that disagree with the chunks on disk. Without -fix
+problems are only reported. -fix refuses to run while a server holds the
+storage root.
+
+Accepts the server's configuration flags, e.g. -config aetherlink.yaml.
+`
+
+// fsckCommand runs `aetherlink fsck ...` and returns the exit code: 0 when
+// storage is consistent (or was repaired), 1 when problems remain
+func fsckCommand(args []string) int {
+	flags := config.NewFlags("aetherlink fsck")
+	fix := flags.Bool("fix", false, "repair what can be repaired")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), fsckUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseStatus(err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *fix {
+		release, err := helpers.LockDir(cfg.Storage.Root)
+		if errors.Is(err, helpers.ErrLocked) {
+			fmt.Fprintf(os.Stderr, "%s is in use by a running server; stop it before repairing\n", cfg.Storage.Root)
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer release()
+	}
+
+	report, err := services.ScanStorage(cfg.Storage.Root, *fix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printRecovery(report)
+	}
+	if len(report.Issues) > report.Fixed() {
+		return 1
+	}
+	return 0
+}
+
+func printRecovery(report models.RecoveryReport) {
+	if len(report.Issues) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "UPLOAD\tKIND\tPATH\tCHUNK\tDETAIL\tFIXED")
+		for _, issue := range report.Issues {
+			chunk := "-"
+			if issue.Chunk != nil {
+				chunk = strconv.Itoa(*issue.Chunk)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", issue.UploadID, issue.Kind, issue.Path, chunk, issue.Detail, issue.Fixed)
+		}
+		w.Flush()
+	}
+	fmt.Printf("%d uploads scanned, %d issues, %d fixed\n", report.Uploads, len(report.Issues), report.Fixed())
+}
+
+// logRecovery logs the repairs made by the startup recovery pass
+func logRecovery(report models.RecoveryReport) {
+	for _, issue := range report.Issues {
+		attrs := []interface{}{"event", "recovery", "upload_id", issue.UploadID, "kind", issue.Kind, "path", issue.Path, "fixed", issue.Fixed}
+		if issue.Chunk != nil {
+			attrs = append(attrs, "chunk", *issue.Chunk)
+		}
+		if issue.Detail != "" {
+			attrs = append(attrs, "detail", issue.Detail)
+		}
+		if issue.Fixed {
+			slog.Info("storage issue repaired", attrs...)
+		} else {
+			slog.Warn("storage issue needs attention", attrs...)
+		}
+	}
+	slog.Info("storage recovery finished", "event", "recovery", "uploads", report.Uploads,
+		"issues", len(report.Issues), "fixed", report.Fixed())
+}
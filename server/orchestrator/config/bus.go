@@ -0,0 +1,31 @@
+package config
+
+import "os"
+
+// Replicas share SSE and room events over NATS when bus.url
+// (EVENT_BUS_URL) is set, e.g. "nats://10.0.0.5:4222". The replica is
+// named on the bus by cluster.node_id (NODE_ID), defaulting to the
+// hostname.
+const (
+	EnvEventBusURL     = "EVENT_BUS_URL"
+	EnvEventBusSubject = "EVENT_BUS_SUBJECT"
+)
+
+type BusSettings struct {
+	URL     string
+	Subject string
+	NodeID  string
+}
+
+// Settings resolves the bus section for the node named nodeID.
+// It returns nil when events stay local to this process.
+func (c BusConfig) Settings(nodeID string) *BusSettings {
+	if c.URL == "" {
+		return nil
+	}
+	settings := &BusSettings{URL: c.URL, Subject: c.Subject, NodeID: nodeID}
+	if settings.NodeID == "" {
+		settings.NodeID, _ = os.Hostname()
+	}
+	return settings
+}
@@ -1,62 +1,34 @@
 package config
 
 import (
-	"context"
-	"log"
-	"os"
+	"log/slog"
 
 	"github.com/cloudinary/cloudinary-go/v2"
-	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+const (
+	EnvCloudinaryName   = "CLOUDINARY_CLOUD_NAME"
+	EnvCloudinaryKey    = "CLOUDINARY_API_KEY"
+	EnvCloudinarySecret = "CLOUDINARY_API_SECRET"
+	// CLOUDINARY_UPLOAD_PREFIX overrides the upload API base URL
+	// (https://api.cloudinary.com), e.g. to point at a local stand-in
+	EnvCloudinaryUploadPrefix = "CLOUDINARY_UPLOAD_PREFIX"
 )
 
 var Cloudinary *cloudinary.Cloudinary
 
-func InitCloudinary() error {
-	var err error
-	Cloudinary, err = cloudinary.NewFromParams(
-		os.Getenv("CLOUDINARY_CLOUD_NAME"),
-		os.Getenv("CLOUDINARY_API_KEY"),
-		os.Getenv("CLOUDINARY_API_SECRET"),
-	)
+// InitCloudinary builds the shared Cloudinary client from the process-wide
+// credentials
+func InitCloudinary(c CloudinaryConfig) error {
+	client, err := cloudinary.NewFromParams(c.CloudName, c.APIKey, c.APISecret)
 	if err != nil {
-		log.Println("Failed to initialize Cloudinary:", err)
+		slog.Error("failed to initialize cloudinary", "error", err)
 		return err
 	}
-	return nil
-}
-
-func InitCloudinaryAsService(cloudName, apiKey, apiSecret string) error {
-	var err error
-	Cloudinary, err = cloudinary.NewFromParams(cloudName, apiKey, apiSecret)
-	if err != nil {
-		log.Println("Failed to initialize Cloudinary:", err)
-		return err
+	if c.UploadPrefix != "" {
+		client.Config.API.UploadPrefix = c.UploadPrefix
+		client.Upload.Config.API.UploadPrefix = c.UploadPrefix
 	}
+	Cloudinary = client
 	return nil
 }
-
-func UploadFileToCloudinary(filePath string) (string, error) {
-	err := InitCloudinary()
-	if err != nil {
-		return "", err
-	}
-	uploadResult, err := Cloudinary.Upload.Upload(context.Background(), filePath, uploader.UploadParams{})
-	if err != nil {
-		log.Println("Failed to upload file to Cloudinary:", err)
-		return "", err
-	}
-	return uploadResult.SecureURL, nil
-}
-
-func UploadFileToCloudinaryAsService(filepath string, cloudname string, apikey string, apisecret string) (string, error) {
-	err := InitCloudinaryAsService(cloudname, apikey, apisecret)
-	if err != nil {
-		return "", err
-	}
-	uploadResult, err := Cloudinary.Upload.Upload(context.Background(), filepath, uploader.UploadParams{})
-	if err != nil {
-		log.Println("Failed to upload file to Cloudinary:", err)
-		return "", err
-	}
-	return uploadResult.SecureURL, nil
-}
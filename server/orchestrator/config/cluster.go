@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Cluster mode is enabled by listing every orchestrator node in
+// cluster.nodes, or CLUSTER_NODES as "id=url" pairs, e.g.
+// "a=http://10.0.0.1:8080,b=http://10.0.0.2:8080", and naming this
+// process with cluster.node_id or NODE_ID. Requests forwarded between nodes
+// are signed with cluster.secret (CLUSTER_SECRET); without it they are
+// trusted by the peer's address.
+const (
+	EnvClusterNodes  = "CLUSTER_NODES"
+	EnvNodeID        = "NODE_ID"
+	EnvClusterSecret = "CLUSTER_SECRET"
+)
+
+type ClusterNode struct {
+	ID  string `yaml:"id" toml:"id"`
+	URL string `yaml:"url" toml:"url"`
+}
+
+type ClusterSettings struct {
+	NodeID string
+	Nodes  []ClusterNode
+	Secret string // shared by every node
+}
+
+// Settings resolves the cluster section. It returns nil when the server
+// runs as a single node.
+func (c ClusterConfig) Settings() (*ClusterSettings, error) {
+	if len(c.Nodes) == 0 {
+		return nil, nil
+	}
+	if c.NodeID == "" {
+		return nil, errors.New("cluster.node_id is required when cluster.nodes is set")
+	}
+
+	settings := &ClusterSettings{NodeID: c.NodeID, Secret: c.Secret}
+	seen := make(map[string]bool)
+	self := false
+	for _, node := range c.Nodes {
+		if node.ID == "" || node.URL == "" {
+			return nil, fmt.Errorf("cluster.nodes: node %q needs both an id and a url", node.ID+"="+node.URL)
+		}
+		if seen[node.ID] {
+			return nil, fmt.Errorf("cluster.nodes: duplicate node id %q", node.ID)
+		}
+		seen[node.ID] = true
+		if node.ID == c.NodeID {
+			self = true
+		}
+		settings.Nodes = append(settings.Nodes, ClusterNode{ID: node.ID, URL: strings.TrimRight(node.URL, "/")})
+	}
+	if !self {
+		return nil, fmt.Errorf("cluster.node_id %q is not listed in cluster.nodes", c.NodeID)
+	}
+	return settings, nil
+}
+
+// parseClusterNodes reads the "id=url,..." form of CLUSTER_NODES
+func parseClusterNodes(v string) ([]ClusterNode, error) {
+	var nodes []ClusterNode
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		id, url, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q is not id=url", entry)
+		}
+		nodes = append(nodes, ClusterNode{ID: id, URL: url})
+	}
+	return nodes, nil
+}
@@ -1,7 +1,50 @@
 package config
 
+import "time"
+
+// Defaults of settings that can be changed through the config file,
+// environment or flags (see settings.go)
 const (
-	StorageRoot   = "./storage"
-	MaxUploadSize = 1 << 30 // 1GB per request limit
-	ServerPort    = ":8080"
+	DefaultStorageRoot   = "./storage"
+	DefaultMaxUploadSize = 1 << 30 // 1GB per request limit
+	ServerPort           = ":8080"
+	DefaultRoomExpiry    = 24 * time.Hour
+	// DefaultShutdownTimeout bounds how long in-flight requests may take to
+	// finish after SIGTERM
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// DefaultLowWatermark is the free space below which new sessions are paused
+	DefaultLowWatermark = 512 << 20
 )
+
+const (
+	TelemetryRoot = "./telemetry" // kept outside StorageRoot, which is served by /static
+	WebhookRoot   = "./webhooks"  // registered webhooks and the delivery queue
+	EventRoot     = "./events"    // per-room and per-upload SSE event logs
+	SinkRoot      = "./sinks"     // per-share sink policies
+	VaultRoot     = "./vault"     // sealed per-tenant sink credentials
+
+	// CapacityReapInterval is how often reservations of expired rooms are released
+	CapacityReapInterval = 5 * time.Minute
+	// RoomExpiryInterval is how often rooms are checked for expiry
+	RoomExpiryInterval = time.Minute
+	// EventPruneInterval is how often event logs of finished uploads are deleted
+	EventPruneInterval = 10 * time.Minute
+	// EventLogSize is how many events each room and upload log retains for replay
+	EventLogSize = 256
+	// ShutdownReconnectDelay is how long clients are told to wait before
+	// reconnecting or retrying while the server drains
+	ShutdownReconnectDelay = 5 * time.Second
+)
+
+// Settings read through package variables, set by Config.Apply
+var (
+	StorageRoot   = DefaultStorageRoot
+	MaxUploadSize = DefaultMaxUploadSize
+	adminToken    string
+)
+
+// AdminToken returns the bearer token for admin routes
+func AdminToken() string {
+	return adminToken
+}
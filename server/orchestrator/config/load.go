@@ -0,0 +1,277 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings without a dedicated file of their own. The others are named
+// next to the code that uses them (quota.go, tls.go, sinks.go, ...).
+const (
+	EnvConfigFile      = "CONFIG_FILE"
+	EnvServerAddr      = "SERVER_ADDR"
+	EnvAdminToken      = "ADMIN_TOKEN"
+	EnvShutdownTimeout = "SHUTDOWN_TIMEOUT"
+	EnvStorageRoot     = "STORAGE_ROOT"
+	EnvLowWatermark    = "STORAGE_LOW_WATERMARK_BYTES"
+	EnvMaxUploadSize   = "MAX_UPLOAD_SIZE"
+	EnvCORSOrigins     = "CORS_ALLOW_ORIGINS"
+	EnvCORSCredentials = "CORS_ALLOW_CREDENTIALS"
+	EnvRoomExpiry      = "ROOM_EXPIRY"
+)
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and the flags in args, then validates it
+func Load(args []string) (*Config, error) {
+	flags := NewFlags("aetherlink")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return flags.Load()
+}
+
+// Flags are the command line overrides of configuration settings. They are
+// held until the file and environment are applied, so flags win over both.
+type Flags struct {
+	*flag.FlagSet
+
+	file        string
+	addr        string
+	storageRoot string
+	maxUpload   int
+	origins     string
+	credentials bool
+	roomExpiry  time.Duration
+	shutdown    time.Duration
+	nodeID      string
+}
+
+// NewFlags returns a flag set carrying the configuration flags; callers may
+// add flags of their own before parsing
+func NewFlags(name string) *Flags {
+	d := Defaults()
+	f := &Flags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.StringVar(&f.file, "config", "", "YAML (.yaml, .yml) or TOML (.toml) config file, also "+EnvConfigFile)
+	f.StringVar(&f.addr, "addr", d.Server.Addr, "listen address")
+	f.StringVar(&f.storageRoot, "storage-root", d.Storage.Root, "directory of upload sessions and assembled files")
+	f.IntVar(&f.maxUpload, "max-upload-size", d.Limits.MaxUploadSize, "largest request body in bytes")
+	f.StringVar(&f.origins, "cors-origins", strings.Join(d.CORS.AllowOrigins, ","), "comma separated CORS origins")
+	f.BoolVar(&f.credentials, "cors-credentials", d.CORS.AllowCredentials, "allow credentialed CORS requests")
+	f.DurationVar(&f.roomExpiry, "room-expiry", time.Duration(d.Rooms.Expiry), "room lifetime after its last upload started")
+	f.DurationVar(&f.shutdown, "shutdown-timeout", time.Duration(d.Server.ShutdownTimeout), "time in-flight requests get to finish after SIGTERM")
+	f.StringVar(&f.nodeID, "node-id", d.Cluster.NodeID, "this node's id in cluster.nodes and on the event bus")
+	return f
+}
+
+// Load builds the configuration after the flags were parsed. Parse errors
+// of the file or environment are returned as is; validation errors are
+// joined.
+func (f *Flags) Load() (*Config, error) {
+	cfg := Defaults()
+	path := f.file
+	if path == "" {
+		path = os.Getenv(EnvConfigFile)
+	}
+	if path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	f.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "addr":
+			cfg.Server.Addr = f.addr
+		case "storage-root":
+			cfg.Storage.Root = f.storageRoot
+		case "max-upload-size":
+			cfg.Limits.MaxUploadSize = f.maxUpload
+		case "cors-origins":
+			cfg.CORS.AllowOrigins = splitList(f.origins)
+		case "cors-credentials":
+			cfg.CORS.AllowCredentials = f.credentials
+		case "room-expiry":
+			cfg.Rooms.Expiry = Duration(f.roomExpiry)
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout = Duration(f.shutdown)
+		case "node-id":
+			cfg.Cluster.NodeID = f.nodeID
+		}
+	})
+	return cfg, cfg.Validate()
+}
+
+// loadFile decodes a YAML or TOML file over cfg, rejecting unknown keys
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config format %q (want .yaml, .yml or .toml)", path, ext)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with the environment variables that are set
+func applyEnv(cfg *Config) error {
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	var errs []error
+	parse := func(name string, set func(string) error) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			if err := set(v); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q", name, v))
+			}
+		}
+	}
+	parseInt := func(name string, dst *int64) {
+		parse(name, func(v string) (err error) {
+			*dst, err = strconv.ParseInt(v, 10, 64)
+			return err
+		})
+	}
+	parseSmallInt := func(name string, dst *int) {
+		parse(name, func(v string) (err error) {
+			*dst, err = strconv.Atoi(v)
+			return err
+		})
+	}
+
+	str(EnvServerAddr, &cfg.Server.Addr)
+	str(EnvAdminToken, &cfg.Server.AdminToken)
+	parse(EnvShutdownTimeout, func(v string) error {
+		return cfg.Server.ShutdownTimeout.UnmarshalText([]byte(v))
+	})
+
+	str(EnvStorageRoot, &cfg.Storage.Root)
+	parse(EnvLowWatermark, func(v string) (err error) {
+		cfg.Storage.LowWatermarkBytes, err = strconv.ParseUint(v, 10, 64)
+		return err
+	})
+
+	parseSmallInt(EnvMaxUploadSize, &cfg.Limits.MaxUploadSize)
+	parseInt(EnvQuotaShareMaxBytes, &cfg.Limits.ShareMaxBytes)
+	parseSmallInt(EnvQuotaShareMaxSessions, &cfg.Limits.ShareMaxSessions)
+	parseSmallInt(EnvQuotaRoomMaxFiles, &cfg.Limits.RoomMaxFiles)
+	parse(EnvRateLimitRPS, func(v string) (err error) {
+		cfg.Limits.RateLimitRPS, err = strconv.ParseFloat(v, 64)
+		return err
+	})
+	parseSmallInt(EnvRateLimitBurst, &cfg.Limits.RateLimitBurst)
+
+	parse(EnvCORSOrigins, func(v string) error {
+		cfg.CORS.AllowOrigins = splitList(v)
+		return nil
+	})
+	parse(EnvCORSCredentials, func(v string) (err error) {
+		cfg.CORS.AllowCredentials, err = strconv.ParseBool(v)
+		return err
+	})
+
+	parse(EnvRoomExpiry, func(v string) error {
+		return cfg.Rooms.Expiry.UnmarshalText([]byte(v))
+	})
+
+	str(EnvTLSCertFile, &cfg.TLS.CertFile)
+	str(EnvTLSKeyFile, &cfg.TLS.KeyFile)
+	str(EnvTLSClientCAFile, &cfg.TLS.ClientCAFile)
+	str(EnvTLSClientAuth, &cfg.TLS.ClientAuth)
+
+	parse(EnvSinksDefault, func(v string) error {
+		cfg.Sinks.Default = splitList(v)
+		return nil
+	})
+	str(EnvSinkDir, &cfg.Sinks.Dir)
+	str(EnvCloudinaryName, &cfg.Sinks.Cloudinary.CloudName)
+	str(EnvCloudinaryKey, &cfg.Sinks.Cloudinary.APIKey)
+	str(EnvCloudinarySecret, &cfg.Sinks.Cloudinary.APISecret)
+	str(EnvCloudinaryFolder, &cfg.Sinks.Cloudinary.Folder)
+	parseInt(EnvCloudinaryChunkSize, &cfg.Sinks.Cloudinary.ChunkSize)
+	str(EnvCloudinaryUploadPrefix, &cfg.Sinks.Cloudinary.UploadPrefix)
+
+	parseSmallInt(EnvSchedSlots, &cfg.Scheduler.Slots)
+	parseSmallInt(EnvSchedReservedHigh, &cfg.Scheduler.ReservedHigh)
+	parseSmallInt(EnvSchedBulkSlots, &cfg.Scheduler.BulkSlots)
+	parseSmallInt(EnvSchedMaxQueue, &cfg.Scheduler.MaxQueue)
+	parse(EnvSchedMaxWait, func(v string) error {
+		return cfg.Scheduler.MaxWait.UnmarshalText([]byte(v))
+	})
+
+	parseInt(EnvThrottleGlobalBPS, &cfg.Throttle.GlobalBytesPerSec)
+	parseInt(EnvThrottleUploadBPS, &cfg.Throttle.UploadBytesPerSec)
+	parseInt(EnvThrottleShareBPS, &cfg.Throttle.ShareBytesPerSec)
+
+	str(EnvVaultKey, &cfg.Vault.Key)
+
+	parse(EnvClusterNodes, func(v string) (err error) {
+		cfg.Cluster.Nodes, err = parseClusterNodes(v)
+		return err
+	})
+	str(EnvNodeID, &cfg.Cluster.NodeID)
+	str(EnvClusterSecret, &cfg.Cluster.Secret)
+
+	str(EnvEventBusURL, &cfg.Bus.URL)
+	str(EnvEventBusSubject, &cfg.Bus.Subject)
+
+	return errors.Join(errs...)
+}
+
+// Encode writes cfg as YAML or TOML
+func Encode(w io.Writer, cfg *Config, format string) error {
+	switch format {
+	case "yaml", "yml", "":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(cfg); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "toml":
+		return toml.NewEncoder(w).Encode(cfg)
+	default:
+		return fmt.Errorf("unsupported format %q (want yaml or toml)", format)
+	}
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
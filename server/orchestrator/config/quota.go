@@ -0,0 +1,36 @@
+package config
+
+// Quotas and rate limits (limits.* in the config file); zero disables a limit.
+const (
+	EnvQuotaShareMaxBytes    = "QUOTA_SHARE_MAX_BYTES"
+	EnvQuotaShareMaxSessions = "QUOTA_SHARE_MAX_SESSIONS"
+	EnvQuotaRoomMaxFiles     = "QUOTA_ROOM_MAX_FILES"
+	EnvRateLimitRPS          = "RATE_LIMIT_RPS"
+	EnvRateLimitBurst        = "RATE_LIMIT_BURST"
+)
+
+type QuotaSettings struct {
+	ShareMaxBytes    int64   `json:"share_max_bytes"`    // total declared bytes across a share's uploads
+	ShareMaxSessions int     `json:"share_max_sessions"` // concurrent incomplete uploads per share
+	RoomMaxFiles     int     `json:"room_max_files"`     // uploads (complete or not) per room
+	RateLimitRPS     float64 `json:"rate_limit_rps"`     // requests per second per client address
+	RateLimitBurst   int     `json:"rate_limit_burst"`   // requests a client may burst above the rate
+}
+
+// QuotaSettings returns the quota and rate limit part of the limits
+func (c LimitsConfig) QuotaSettings() QuotaSettings {
+	s := QuotaSettings{
+		ShareMaxBytes:    c.ShareMaxBytes,
+		ShareMaxSessions: c.ShareMaxSessions,
+		RoomMaxFiles:     c.RoomMaxFiles,
+		RateLimitRPS:     c.RateLimitRPS,
+		RateLimitBurst:   c.RateLimitBurst,
+	}
+	if s.RateLimitRPS > 0 && s.RateLimitBurst == 0 {
+		s.RateLimitBurst = int(s.RateLimitRPS)
+		if s.RateLimitBurst < 1 {
+			s.RateLimitBurst = 1
+		}
+	}
+	return s
+}
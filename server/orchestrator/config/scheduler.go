@@ -0,0 +1,42 @@
+package config
+
+import "time"
+
+// Admission scheduler settings (scheduler.* in the config file)
+const (
+	EnvSchedSlots        = "SCHED_SLOTS"
+	EnvSchedReservedHigh = "SCHED_RESERVED_HIGH"
+	EnvSchedBulkSlots    = "SCHED_BULK_SLOTS"
+	EnvSchedMaxQueue     = "SCHED_MAX_QUEUE"
+	EnvSchedMaxWait      = "SCHED_MAX_WAIT"
+
+	DefaultSchedSlots        = 64
+	DefaultSchedReservedHigh = 8
+	DefaultSchedBulkSlots    = 32
+	DefaultSchedMaxQueue     = 256
+	DefaultSchedMaxWait      = 10 * time.Second
+)
+
+type SchedulerSettings struct {
+	Slots        int           // concurrent init/chunk requests doing I/O
+	ReservedHigh int           // slots only high-priority requests may use
+	BulkSlots    int           // most slots bulk requests may hold at once
+	MaxQueue     int           // queued requests per priority before shedding
+	MaxWait      time.Duration // longest a request waits for a slot
+}
+
+// Settings resolves the scheduler section, capping bulk requests to the
+// slots that are not reserved
+func (c SchedulerConfig) Settings() SchedulerSettings {
+	s := SchedulerSettings{
+		Slots:        c.Slots,
+		ReservedHigh: c.ReservedHigh,
+		BulkSlots:    c.BulkSlots,
+		MaxQueue:     c.MaxQueue,
+		MaxWait:      time.Duration(c.MaxWait),
+	}
+	if s.BulkSlots <= 0 || s.BulkSlots > s.Slots-s.ReservedHigh {
+		s.BulkSlots = s.Slots - s.ReservedHigh
+	}
+	return s
+}
@@ -0,0 +1,288 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is the orchestrator's typed configuration. It is built from
+// defaults, an optional YAML or TOML file, the environment and command line
+// flags, in that order of precedence.
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Storage   StorageConfig   `yaml:"storage" toml:"storage"`
+	Limits    LimitsConfig    `yaml:"limits" toml:"limits"`
+	CORS      CORSConfig      `yaml:"cors" toml:"cors"`
+	Rooms     RoomsConfig     `yaml:"rooms" toml:"rooms"`
+	TLS       TLSConfig       `yaml:"tls" toml:"tls"`
+	Sinks     SinksConfig     `yaml:"sinks" toml:"sinks"`
+	Scheduler SchedulerConfig `yaml:"scheduler" toml:"scheduler"`
+	Throttle  ThrottleConfig  `yaml:"throttle" toml:"throttle"`
+	Vault     VaultConfig     `yaml:"vault" toml:"vault"`
+	Cluster   ClusterConfig   `yaml:"cluster" toml:"cluster"`
+	Bus       BusConfig       `yaml:"bus" toml:"bus"`
+}
+
+type ServerConfig struct {
+	Addr            string   `yaml:"addr" toml:"addr"`
+	AdminToken      string   `yaml:"admin_token" toml:"admin_token"`           // bearer token of /admin, empty disables it
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"` // for in-flight requests after SIGTERM
+}
+
+type StorageConfig struct {
+	Root              string `yaml:"root" toml:"root"`
+	LowWatermarkBytes uint64 `yaml:"low_watermark_bytes" toml:"low_watermark_bytes"`
+}
+
+// LimitsConfig caps request sizes, quotas and request rates; zero disables
+// a quota or rate limit
+type LimitsConfig struct {
+	MaxUploadSize    int     `yaml:"max_upload_size" toml:"max_upload_size"` // bytes per request
+	ShareMaxBytes    int64   `yaml:"share_max_bytes" toml:"share_max_bytes"`
+	ShareMaxSessions int     `yaml:"share_max_sessions" toml:"share_max_sessions"`
+	RoomMaxFiles     int     `yaml:"room_max_files" toml:"room_max_files"`
+	RateLimitRPS     float64 `yaml:"rate_limit_rps" toml:"rate_limit_rps"`
+	RateLimitBurst   int     `yaml:"rate_limit_burst" toml:"rate_limit_burst"`
+}
+
+type CORSConfig struct {
+	AllowOrigins     []string `yaml:"allow_origins" toml:"allow_origins"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials"`
+}
+
+type RoomsConfig struct {
+	Expiry Duration `yaml:"expiry" toml:"expiry"` // since the room's last upload started
+}
+
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile      string `yaml:"key_file" toml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth" toml:"client_auth"` // "none", "optional" or "require"
+}
+
+type SinksConfig struct {
+	Default    []string         `yaml:"default" toml:"default"`
+	Dir        string           `yaml:"dir" toml:"dir"`
+	Cloudinary CloudinaryConfig `yaml:"cloudinary" toml:"cloudinary"`
+}
+
+type CloudinaryConfig struct {
+	CloudName    string `yaml:"cloud_name" toml:"cloud_name"`
+	APIKey       string `yaml:"api_key" toml:"api_key"`
+	APISecret    string `yaml:"api_secret" toml:"api_secret"`
+	Folder       string `yaml:"folder" toml:"folder"`
+	ChunkSize    int64  `yaml:"chunk_size" toml:"chunk_size"` // 0 uses the sink's default
+	UploadPrefix string `yaml:"upload_prefix" toml:"upload_prefix"`
+}
+
+// SchedulerConfig sizes the admission scheduler of init and chunk requests
+type SchedulerConfig struct {
+	Slots        int      `yaml:"slots" toml:"slots"`                 // concurrent init/chunk requests doing I/O
+	ReservedHigh int      `yaml:"reserved_high" toml:"reserved_high"` // slots only high-priority requests may use
+	BulkSlots    int      `yaml:"bulk_slots" toml:"bulk_slots"`       // most slots bulk requests may hold at once
+	MaxQueue     int      `yaml:"max_queue" toml:"max_queue"`         // queued requests per priority before shedding
+	MaxWait      Duration `yaml:"max_wait" toml:"max_wait"`           // longest a request waits for a slot
+}
+
+// ThrottleConfig holds the initial bandwidth limits in bytes per second;
+// zero disables a limit. They can be changed at runtime through the admin
+// API.
+type ThrottleConfig struct {
+	GlobalBytesPerSec int64 `yaml:"global_bytes_per_sec" toml:"global_bytes_per_sec"`
+	UploadBytesPerSec int64 `yaml:"upload_bytes_per_sec" toml:"upload_bytes_per_sec"`
+	ShareBytesPerSec  int64 `yaml:"share_bytes_per_sec" toml:"share_bytes_per_sec"` // default of shares without their own limit
+}
+
+type VaultConfig struct {
+	Key string `yaml:"key" toml:"key"` // 32 bytes, hex or base64; empty disables the vault
+}
+
+// ClusterConfig lists every orchestrator node; no nodes means single-node
+// mode
+type ClusterConfig struct {
+	NodeID string        `yaml:"node_id" toml:"node_id"` // this process, also its name on the event bus
+	Nodes  []ClusterNode `yaml:"nodes" toml:"nodes"`
+	Secret string        `yaml:"secret" toml:"secret"` // signs forwarded requests, shared by every node
+}
+
+type BusConfig struct {
+	URL     string `yaml:"url" toml:"url"` // empty keeps events local to this process
+	Subject string `yaml:"subject" toml:"subject"`
+}
+
+// Enabled reports whether process-wide Cloudinary credentials are set
+func (c CloudinaryConfig) Enabled() bool {
+	return c.CloudName != "" && c.APIKey != ""
+}
+
+// Duration is a time.Duration written as "24h" or "90m" in config files
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Defaults returns the configuration used when nothing is overridden
+func Defaults() *Config {
+	return &Config{
+		Server:  ServerConfig{Addr: ServerPort, ShutdownTimeout: Duration(DefaultShutdownTimeout)},
+		Storage: StorageConfig{Root: DefaultStorageRoot, LowWatermarkBytes: DefaultLowWatermark},
+		Limits:  LimitsConfig{MaxUploadSize: DefaultMaxUploadSize},
+		// Credentials are off: browsers refuse them with the wildcard origin
+		CORS:  CORSConfig{AllowOrigins: []string{"*"}},
+		Rooms: RoomsConfig{Expiry: Duration(DefaultRoomExpiry)},
+		TLS:   TLSConfig{ClientAuth: "none"},
+		Sinks: SinksConfig{
+			Cloudinary: CloudinaryConfig{Folder: DefaultCloudinaryFolder},
+		},
+		Scheduler: SchedulerConfig{
+			Slots:        DefaultSchedSlots,
+			ReservedHigh: DefaultSchedReservedHigh,
+			BulkSlots:    DefaultSchedBulkSlots,
+			MaxQueue:     DefaultSchedMaxQueue,
+			MaxWait:      Duration(DefaultSchedMaxWait),
+		},
+	}
+}
+
+// sinkNames are the sinks a default policy may name
+var sinkNames = []string{"cloudinary", "dir"}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		fail("server.addr is required")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdown_timeout must be positive")
+	}
+	if c.Storage.Root == "" {
+		fail("storage.root is required")
+	}
+
+	if c.Limits.MaxUploadSize <= 0 {
+		fail("limits.max_upload_size must be positive")
+	}
+	if c.Limits.ShareMaxBytes < 0 || c.Limits.ShareMaxSessions < 0 || c.Limits.RoomMaxFiles < 0 {
+		fail("limits quotas must not be negative")
+	}
+	if c.Limits.RateLimitRPS < 0 || c.Limits.RateLimitBurst < 0 {
+		fail("limits rate limits must not be negative")
+	}
+
+	if len(c.CORS.AllowOrigins) == 0 {
+		fail("cors.allow_origins must list at least one origin")
+	}
+	for _, origin := range c.CORS.AllowOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				fail("cors.allow_credentials cannot be used with the wildcard origin")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			fail("cors.allow_origins: %q is not an origin like https://app.example.com", origin)
+		}
+	}
+
+	if c.Rooms.Expiry <= 0 {
+		fail("rooms.expiry must be positive")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls.cert_file and tls.key_file must be set together")
+	}
+	switch c.TLS.ClientAuth {
+	case "", "none":
+	case "optional", "require":
+		if c.TLS.ClientCAFile == "" {
+			fail("tls.client_ca_file is required for client authentication")
+		}
+	default:
+		fail("tls.client_auth %q is invalid (want none, optional or require)", c.TLS.ClientAuth)
+	}
+
+	for _, name := range c.Sinks.Default {
+		if !slices.Contains(sinkNames, name) {
+			fail("sinks.default: unknown sink %q (want %s)", name, strings.Join(sinkNames, ", "))
+		}
+	}
+	if slices.Contains(c.Sinks.Default, "dir") && c.Sinks.Dir == "" {
+		fail("sinks.dir is required when the dir sink is a default")
+	}
+	if size := c.Sinks.Cloudinary.ChunkSize; size != 0 && size < MinCloudinaryChunkSize {
+		fail("sinks.cloudinary.chunk_size must be at least %d bytes", MinCloudinaryChunkSize)
+	}
+	if c.Sinks.Cloudinary.APIKey != "" && c.Sinks.Cloudinary.APISecret == "" {
+		fail("sinks.cloudinary.api_secret is required with an api_key")
+	}
+
+	sched := c.Scheduler
+	if sched.Slots <= 0 || sched.ReservedHigh < 0 || sched.ReservedHigh >= sched.Slots {
+		fail("scheduler.slots must be positive and larger than scheduler.reserved_high")
+	}
+	if sched.BulkSlots < 0 || sched.MaxQueue < 0 {
+		fail("scheduler.bulk_slots and scheduler.max_queue must not be negative")
+	}
+	if sched.MaxWait <= 0 {
+		fail("scheduler.max_wait must be positive")
+	}
+
+	if c.Throttle.GlobalBytesPerSec < 0 || c.Throttle.UploadBytesPerSec < 0 || c.Throttle.ShareBytesPerSec < 0 {
+		fail("throttle limits must not be negative")
+	}
+
+	if _, err := c.Vault.DecodeKey(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.Cluster.Settings(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy with secrets masked, for printing
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.CORS.AllowOrigins = slices.Clone(c.CORS.AllowOrigins)
+	r.Sinks.Default = slices.Clone(c.Sinks.Default)
+	r.Cluster.Nodes = slices.Clone(c.Cluster.Nodes)
+	mask := func(s *string) {
+		if *s != "" {
+			*s = "<redacted>"
+		}
+	}
+	mask(&r.Server.AdminToken)
+	mask(&r.Sinks.Cloudinary.APISecret)
+	mask(&r.Vault.Key)
+	mask(&r.Cluster.Secret)
+	return &r
+}
+
+// Apply publishes the settings that are read through package variables
+func (c *Config) Apply() {
+	StorageRoot = c.Storage.Root
+	MaxUploadSize = c.Limits.MaxUploadSize
+	adminToken = c.Server.AdminToken
+}
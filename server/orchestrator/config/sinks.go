@@ -0,0 +1,18 @@
+package config
+
+// Assembled files are pushed to the sinks named in sinks.default
+// (SINKS_DEFAULT, comma separated, e.g. "cloudinary,dir") unless a share has
+// its own policy. The cloudinary sink needs CLOUDINARY_API_KEY; the dir sink
+// copies files below SINK_DIR. Cloudinary uploads go to
+// CLOUDINARY_FOLDER/<shareID> in requests of CLOUDINARY_CHUNK_SIZE bytes.
+const (
+	EnvSinksDefault         = "SINKS_DEFAULT"
+	EnvSinkDir              = "SINK_DIR"
+	EnvCloudinaryFolder     = "CLOUDINARY_FOLDER"
+	EnvCloudinaryChunkSize  = "CLOUDINARY_CHUNK_SIZE"
+	DefaultCloudinaryFolder = "aetherlink"
+
+	// MinCloudinaryChunkSize is the smallest chunk Cloudinary accepts
+	// before the last one
+	MinCloudinaryChunkSize = 5 << 20
+)
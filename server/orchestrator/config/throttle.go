@@ -0,0 +1,20 @@
+package config
+
+import "aetherlink/models"
+
+// Initial bandwidth limits (throttle.* in the config file)
+const (
+	EnvThrottleGlobalBPS = "THROTTLE_GLOBAL_BPS"
+	EnvThrottleUploadBPS = "THROTTLE_UPLOAD_BPS"
+	EnvThrottleShareBPS  = "THROTTLE_SHARE_BPS"
+)
+
+// Limits returns the throttle section as the limits the throttle service
+// starts with
+func (c ThrottleConfig) Limits() models.ThrottleLimits {
+	return models.ThrottleLimits{
+		GlobalBytesPerSec:       c.GlobalBytesPerSec,
+		UploadBytesPerSec:       c.UploadBytesPerSec,
+		DefaultShareBytesPerSec: c.ShareBytesPerSec,
+	}
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLS settings (tls.* in the config file) can also come from the
+// environment so they live next to the Cloudinary credentials in .env.
+// Leaving the certificate empty keeps the server in plaintext mode (TLS
+// terminated by a proxy).
+const (
+	EnvTLSCertFile     = "TLS_CERT_FILE"
+	EnvTLSKeyFile      = "TLS_KEY_FILE"
+	EnvTLSClientCAFile = "TLS_CLIENT_CA_FILE"
+	EnvTLSClientAuth   = "TLS_CLIENT_AUTH" // "none", "optional" or "require"
+
+	// TLSReloadInterval is how often certificate files are checked for changes
+	TLSReloadInterval = 30 * time.Second
+)
+
+type TLSSettings struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+}
+
+// Settings resolves the TLS section. It returns nil when TLS is not
+// configured.
+func (c TLSConfig) Settings() (*TLSSettings, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+
+	settings := &TLSSettings{
+		CertFile:     c.CertFile,
+		KeyFile:      c.KeyFile,
+		ClientCAFile: c.ClientCAFile,
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	switch c.ClientAuth {
+	case "", "none":
+		if settings.ClientCAFile != "" {
+			// A CA without an explicit mode means callers may present a cert
+			settings.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	case "optional":
+		settings.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		settings.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls.client_auth %q (want none, optional or require)", c.ClientAuth)
+	}
+
+	if settings.ClientAuth != tls.NoClientCert && settings.ClientCAFile == "" {
+		return nil, errors.New("tls.client_ca_file is required for client authentication")
+	}
+	return settings, nil
+}
+
+// CertReloader serves the current certificate and client CA pool and
+// reloads them when the files on disk change. Only new handshakes pick up
+// the reloaded material, so connections carrying uploads are not dropped.
+type CertReloader struct {
+	settings TLSSettings
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+
+	stop chan struct{}
+}
+
+// NewCertReloader loads the configured certificate and client CA
+func NewCertReloader(settings TLSSettings) (*CertReloader, error) {
+	r := &CertReloader{
+		settings: settings,
+		modTimes: make(map[string]time.Time),
+		stop:     make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a TLS 1.3-only config backed by the reloader
+func (r *CertReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS13,
+		GetCertificate: r.getCertificate,
+		ClientAuth:     r.settings.ClientAuth,
+	}
+	if r.settings.ClientAuth == tls.NoClientCert {
+		return base
+	}
+	// Client CAs are resolved per handshake so a rotated CA bundle applies
+	// without rebuilding the listener.
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		r.mu.RLock()
+		cfg.ClientCAs = r.clientCA
+		r.mu.RUnlock()
+		return cfg, nil
+	}
+	return base
+}
+
+// Watch polls the certificate files until Stop is called
+func (r *CertReloader) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.reload(); err != nil {
+					// Keep serving the previous certificate
+					slog.Error("tls reload failed, keeping current certificate", "event", "tls_reload", "error", err)
+					continue
+				}
+				slog.Info("tls certificate reloaded", "event", "tls_reload", "cert_file", r.settings.CertFile)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the file watcher
+func (r *CertReloader) Stop() {
+	close(r.stop)
+}
+
+func (r *CertReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+	return r.cert, nil
+}
+
+func (r *CertReloader) files() []string {
+	files := []string{r.settings.CertFile, r.settings.KeyFile}
+	if r.settings.ClientCAFile != "" {
+		files = append(files, r.settings.ClientCAFile)
+	}
+	return files
+}
+
+func (r *CertReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.settings.CertFile, r.settings.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.settings.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.settings.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("client CA file contains no certificates")
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+// Per-tenant sink credentials are sealed with AES-256-GCM under vault.key
+// (VAULT_KEY), 32 bytes given as 64 hex characters or standard base64.
+// Without a key the vault is disabled and sinks only use the process-wide
+// credentials.
+const EnvVaultKey = "VAULT_KEY"
+
+// DecodeKey returns the vault key. It returns nil when the vault is
+// disabled.
+func (c VaultConfig) DecodeKey() ([]byte, error) {
+	if c.Key == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(c.Key)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(c.Key)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("vault.key must be 32 bytes, hex or base64 encoded")
+	}
+	return key, nil
+}
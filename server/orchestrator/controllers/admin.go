@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"aetherlink/logging"
+	"aetherlink/middleware"
+	"aetherlink/models"
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetThrottleHandler returns the active bandwidth limits
+func GetThrottleHandler(c *fiber.Ctx) error {
+	return c.JSON(services.Throttle.Limits())
+}
+
+// UpdateThrottleHandler replaces the bandwidth limits at runtime
+func UpdateThrottleHandler(c *fiber.Ctx) error {
+	var limits models.ThrottleLimits
+	if err := c.BodyParser(&limits); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid throttle limits: "+err.Error())
+	}
+	if err := services.Throttle.SetLimits(limits); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, err.Error())
+	}
+	return c.JSON(services.Throttle.Limits())
+}
+
+// ListWebhooksHandler returns the registered webhooks (secrets omitted)
+func ListWebhooksHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"webhooks": services.Webhooks.List(),
+	})
+}
+
+// CreateWebhookHandler registers a global or per-share webhook. The signing
+// secret is only returned here.
+func CreateWebhookHandler(c *fiber.Ctx) error {
+	var hook models.Webhook
+	if err := c.BodyParser(&hook); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid webhook: "+err.Error())
+	}
+	hook, err := services.Webhooks.Register(hook)
+	if err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, err.Error())
+	}
+	return c.Status(fiber.StatusCreated).JSON(hook)
+}
+
+// DeleteWebhookHandler removes a webhook and its pending deliveries
+func DeleteWebhookHandler(c *fiber.Ctx) error {
+	if err := services.Webhooks.Remove(middleware.Param(c, "id")); err != nil {
+		if err == services.ErrWebhookNotFound {
+			return apiError(c, fiber.StatusNotFound, models.CodeNotFound, err.Error())
+		}
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, err.Error())
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// WebhookDeliveriesHandler returns the delivery log, newest first, filtered
+// by ?webhook_id= and ?status=pending|delivered|failed
+func WebhookDeliveriesHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"deliveries": services.Webhooks.Deliveries(c.Query("webhook_id"), c.Query("status")),
+	})
+}
+
+// ListSinksHandler returns the configured sinks, the default policy and the
+// per-share policies
+func ListSinksHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"sinks":    services.Sinks.Names(),
+		"default":  services.Sinks.Default(),
+		"policies": services.Sinks.Policies(),
+	})
+}
+
+// SetSinkPolicyHandler replaces the sinks a share's completed files are
+// pushed to; an empty list disables pushes for the share
+func SetSinkPolicyHandler(c *fiber.Ctx) error {
+	var policy models.SinkPolicy
+	if err := c.BodyParser(&policy); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid sink policy: "+err.Error())
+	}
+	policy.ShareID = middleware.Param(c, "shareID")
+	if err := services.Sinks.SetPolicy(policy); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, err.Error())
+	}
+	return c.JSON(policy)
+}
+
+// DeleteSinkPolicyHandler returns a share to the default sink policy
+func DeleteSinkPolicyHandler(c *fiber.Ctx) error {
+	if err := services.Sinks.RemovePolicy(middleware.Param(c, "shareID")); err != nil {
+		if err == services.ErrSinkPolicyNotFound {
+			return apiError(c, fiber.StatusNotFound, models.CodeNotFound, err.Error())
+		}
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, err.Error())
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RetrySinksHandler requeues an upload's failed sink pushes
+func RetrySinksHandler(c *fiber.Ctx) error {
+	md, err := services.Sinks.Retry(middleware.Param(c, "uploadID"))
+	switch {
+	case os.IsNotExist(err):
+		return apiError(c, fiber.StatusNotFound, models.CodeUploadNotFound, "Upload not found")
+	case err == services.ErrNoFailedSinks:
+		return apiError(c, fiber.StatusConflict, models.CodeNoFailedSinks, err.Error())
+	case err != nil:
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, err.Error())
+	}
+	return c.JSON(fiber.Map{
+		"upload_id": md.UploadID,
+		"sinks":     md.Sinks,
+	})
+}
+
+// ListCredentialsHandler returns the vault's sink credentials without their
+// secrets, and the tenants their shares belong to
+func ListCredentialsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"enabled":     services.Vault.Enabled(),
+		"credentials": services.Vault.List(),
+		"tenants":     services.Vault.Tenants(),
+	})
+}
+
+// CreateCredentialHandler seals a sink account for a share or a tenant
+func CreateCredentialHandler(c *fiber.Ctx) error {
+	var cred models.SinkCredential
+	if err := c.BodyParser(&cred); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid credential: "+err.Error())
+	}
+	cred, err := services.Vault.Register(cred)
+	if err != nil {
+		return vaultError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(cred)
+}
+
+// DeleteCredentialHandler removes a sink account from the vault
+func DeleteCredentialHandler(c *fiber.Ctx) error {
+	if err := services.Vault.Remove(middleware.Param(c, "id")); err != nil {
+		return vaultError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SetTenantHandler replaces the shares that use a tenant's credentials
+func SetTenantHandler(c *fiber.Ctx) error {
+	var tenant models.Tenant
+	if err := c.BodyParser(&tenant); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid tenant: "+err.Error())
+	}
+	tenant.ID = middleware.Param(c, "tenant")
+	if err := services.Vault.SetTenant(tenant); err != nil {
+		return vaultError(c, err)
+	}
+	return c.JSON(tenant)
+}
+
+func vaultError(c *fiber.Ctx, err error) error {
+	switch err {
+	case services.ErrVaultDisabled:
+		return apiError(c, fiber.StatusServiceUnavailable, models.CodeVaultDisabled, err.Error())
+	case services.ErrCredentialNotFound:
+		return apiError(c, fiber.StatusNotFound, models.CodeNotFound, err.Error())
+	}
+	return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, err.Error())
+}
+
+// ListSharesHandler returns every share with stored uploads, its sizes and
+// its room's expiry. In cluster mode the other nodes' uploads are counted
+// too.
+func ListSharesHandler(c *fiber.Ctx) error {
+	shares, err := services.ListShares()
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to list shares")
+	}
+	for _, answer := range peerResponses(c) {
+		var peer struct {
+			Shares []models.ShareSummary `json:"shares"`
+		}
+		if err := json.Unmarshal(answer, &peer); err == nil {
+			shares = services.MergeShares(shares, peer.Shares)
+		}
+	}
+	return c.JSON(fiber.Map{
+		"shares": shares,
+	})
+}
+
+// ListUploadsHandler returns stored uploads, optionally filtered by
+// ?share_id= and ?state=active|completed. In cluster mode the other nodes'
+// uploads are listed too.
+func ListUploadsHandler(c *fiber.Ctx) error {
+	state := c.Query("state")
+	if state != "" && state != models.UploadActive && state != models.UploadCompleted {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "state must be active or completed")
+	}
+	uploads, err := services.ListUploads(c.Query("share_id"))
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to list uploads")
+	}
+	if state != "" {
+		filtered := uploads[:0]
+		for _, u := range uploads {
+			if u.State == state {
+				filtered = append(filtered, u)
+			}
+		}
+		uploads = filtered
+	}
+	// In cluster mode the other uploads are stored on their owners
+	for _, answer := range peerResponses(c) {
+		var peer struct {
+			Uploads []models.UploadSummary `json:"uploads"`
+		}
+		if err := json.Unmarshal(answer, &peer); err == nil {
+			uploads = append(uploads, peer.Uploads...)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].UpdatedAt.After(uploads[j].UpdatedAt) })
+	return c.JSON(fiber.Map{
+		"uploads": uploads,
+	})
+}
+
+// ForceCompleteHandler assembles an upload whose client stopped before
+// calling /complete, keeping what arrived: chunks that are missing and
+// cannot be rebuilt from parity become zeros and a file hash mismatch does
+// not fail the assembly. The response lists what the override changed.
+func ForceCompleteHandler(c *fiber.Ctx) error {
+	logging.FromContext(c).Info("upload completion forced by operator", "event", "admin",
+		"upload_id", middleware.Param(c, "uploadID"))
+	return completeUpload(c, true)
+}
+
+// AbortUploadHandler deletes an incomplete upload; its room is told it was
+// aborted
+func AbortUploadHandler(c *fiber.Ctx) error {
+	uploadID := middleware.Param(c, "uploadID")
+	md, err := services.AbortUpload(uploadID, "aborted")
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		return apiError(c, fiber.StatusNotFound, models.CodeUploadNotFound, "Upload not found")
+	case errors.Is(err, services.ErrUploadCompleted):
+		return apiError(c, fiber.StatusConflict, models.CodeUploadCompleted, err.Error())
+	case err != nil:
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, err.Error())
+	}
+	logging.FromContext(c).Info("upload aborted by operator", "event", "admin",
+		"upload_id", uploadID, "share_id", md.ShareID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// roomExpiryRequest moves a room's expiry: to a time, to a duration from
+// now, or by a duration from the current expiry (negative shortens it)
+type roomExpiryRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExpiresIn string     `json:"expires_in"`
+	ExtendBy  string     `json:"extend_by"`
+}
+
+// SetRoomExpiryHandler extends or shortens a room's lifetime. A room moved
+// into the past expires right away.
+func SetRoomExpiryHandler(c *fiber.Ctx) error {
+	shareID := middleware.Param(c, "shareID")
+	exists, err := services.ShareExists(shareID)
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to look up share")
+	}
+	if !exists {
+		return apiError(c, fiber.StatusNotFound, models.CodeNotFound, "Share not found")
+	}
+	var req roomExpiryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid expiry: "+err.Error())
+	}
+
+	var expiresAt time.Time
+	set := 0
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+		set++
+	}
+	for _, rel := range []struct {
+		value string
+		from  time.Time
+	}{
+		{req.ExpiresIn, time.Now()},
+		{req.ExtendBy, services.Room.GetRoomExpiry(shareID)},
+	} {
+		if rel.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(rel.value)
+		if err != nil {
+			return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid duration "+strconv.Quote(rel.value))
+		}
+		expiresAt = rel.from.Add(d)
+		set++
+	}
+	if set != 1 {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "exactly one of expires_at, expires_in and extend_by is required")
+	}
+
+	services.Room.SetRoomExpiry(shareID, expiresAt)
+	if !expiresAt.After(time.Now()) {
+		services.Room.ExpireRooms()
+	}
+	// Connected clients pick up the new countdown
+	services.Room.NotifyRoomStateUpdate(shareID)
+	logging.FromContext(c).Info("room expiry changed by operator", "event", "admin",
+		"share_id", shareID, "expires_at", expiresAt)
+
+	expiresIn := int64(time.Until(expiresAt).Seconds())
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
+	return c.JSON(fiber.Map{
+		"share_id":   shareID,
+		"expires_at": expiresAt,
+		"expires_in": expiresIn,
+	})
+}
+
+// KickRoomClientsHandler disconnects a room's SSE clients. Browsers
+// reconnect on their own and resume from their last event.
+func KickRoomClientsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"kicked": services.EventLog.Kick(services.RoomStream(middleware.Param(c, "shareID"))),
+	})
+}
+
+// KickUploadClientsHandler disconnects an upload's SSE clients
+func KickUploadClientsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"kicked": services.EventLog.Kick(services.UploadStream(middleware.Param(c, "uploadID"))),
+	})
+}
+
+// StorageHandler returns disk usage, reservations and the active quotas
+func StorageHandler(c *fiber.Ctx) error {
+	usage, err := services.Capacity.Usage()
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to read storage usage")
+	}
+	return c.JSON(fiber.Map{
+		"storage": usage,
+		"quotas":  services.Quota.Settings(),
+	})
+}
+
+// RunJanitorHandler runs the maintenance passes without waiting for their
+// timers
+func RunJanitorHandler(c *fiber.Ctx) error {
+	report := services.RunJanitor()
+	logging.FromContext(c).Info("janitor run by operator", "event", "admin",
+		"expired_rooms", len(report.ExpiredRooms), "released_reservations", report.ReleasedReservations,
+		"evicted_streams", report.EvictedStreams)
+	return c.JSON(report)
+}
@@ -0,0 +1,466 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/middleware"
+	"aetherlink/models"
+	"aetherlink/routes"
+	"aetherlink/services"
+	"aetherlink/sinks"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const adminToken = "admin-secret"
+
+// TestMain runs the tests in a scratch directory, since storage and event
+// logs live under the working directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aetherlink-controllers")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	os.Setenv(config.EnvAdminToken, adminToken)
+	cfg, err := config.Load(nil)
+	if err != nil {
+		panic(err)
+	}
+	cfg.Apply()
+	if err := os.MkdirAll(config.StorageRoot, 0755); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newApp() *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	routes.SetupRoutes(app)
+	return app
+}
+
+// call sends a request, as the admin for /admin routes, and decodes the JSON
+// answer into out
+func call(t *testing.T, app *fiber.App, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, _ := json.Marshal(b)
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if _, isJSON := body.([]byte); body != nil && !isJSON {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+adminToken)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: status %d, undecodable answer: %v", method, path, resp.StatusCode, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// startUpload initialises an upload of the given chunks and sends those
+// listed in send
+func startUpload(t *testing.T, app *fiber.App, uploadID, shareID string, chunks [][]byte, send ...int) models.Metadata {
+	t.Helper()
+	md := models.Metadata{
+		UploadID:    uploadID,
+		ShareID:     shareID,
+		Filename:    uploadID + ".txt",
+		TotalChunks: len(chunks),
+		ChunkSize:   int64(len(chunks[0])),
+		FileHash:    helpers.HashChunk(bytes.Join(chunks, nil)),
+		FileSize:    int64(len(bytes.Join(chunks, nil))),
+	}
+	for _, c := range chunks {
+		md.ChunkHashes = append(md.ChunkHashes, helpers.HashChunk(c))
+	}
+	if code := call(t, app, fiber.MethodPost, "/v1/init", md, nil); code != fiber.StatusCreated {
+		t.Fatalf("init %s: status %d", uploadID, code)
+	}
+	for _, idx := range send {
+		path := fmt.Sprintf("/v1/upload/%s/%d", uploadID, idx)
+		if code := call(t, app, fiber.MethodPut, path, chunks[idx], nil); code != fiber.StatusOK {
+			t.Fatalf("chunk %d of %s: status %d", idx, uploadID, code)
+		}
+	}
+	return md
+}
+
+var testChunks = [][]byte{[]byte("first-"), []byte("second"), []byte("third")}
+
+func TestAdminListsUploadsAndShares(t *testing.T) {
+	app := newApp()
+	startUpload(t, app, "list-done", "list-share", testChunks, 0, 1, 2)
+	if code := call(t, app, fiber.MethodPost, "/v1/complete/list-done", nil, nil); code != fiber.StatusOK {
+		t.Fatalf("complete: status %d", code)
+	}
+	startUpload(t, app, "list-active", "list-share", testChunks, 1)
+	startUpload(t, app, "list-other", "other-share", testChunks)
+
+	var listed struct{ Uploads []models.UploadSummary }
+	if code := call(t, app, fiber.MethodGet, "/v1/admin/uploads?share_id=list-share", nil, &listed); code != fiber.StatusOK {
+		t.Fatalf("list uploads: status %d", code)
+	}
+	byID := make(map[string]models.UploadSummary)
+	for _, u := range listed.Uploads {
+		byID[u.UploadID] = u
+	}
+	if len(listed.Uploads) != 2 {
+		t.Fatalf("listed %d uploads of list-share, want 2: %+v", len(listed.Uploads), listed.Uploads)
+	}
+	if u := byID["list-done"]; u.State != models.UploadCompleted || u.ReceivedChunks != 3 || u.StoredBytes < 17 {
+		t.Errorf("completed upload = %+v", u)
+	}
+	if u := byID["list-active"]; u.State != models.UploadActive || u.ReceivedChunks != 1 || u.DeclaredBytes != 17 {
+		t.Errorf("active upload = %+v", u)
+	}
+
+	call(t, app, fiber.MethodGet, "/v1/admin/uploads?share_id=list-share&state=completed", nil, &listed)
+	if len(listed.Uploads) != 1 || listed.Uploads[0].UploadID != "list-done" {
+		t.Errorf("completed uploads = %+v", listed.Uploads)
+	}
+	if code := call(t, app, fiber.MethodGet, "/v1/admin/uploads?state=stalled", nil, nil); code != fiber.StatusBadRequest {
+		t.Errorf("unknown state: status %d, want 400", code)
+	}
+
+	var shares struct{ Shares []models.ShareSummary }
+	if code := call(t, app, fiber.MethodGet, "/v1/admin/shares", nil, &shares); code != fiber.StatusOK {
+		t.Fatalf("list shares: status %d", code)
+	}
+	var share *models.ShareSummary
+	for i := range shares.Shares {
+		if shares.Shares[i].ShareID == "list-share" {
+			share = &shares.Shares[i]
+		}
+	}
+	if share == nil {
+		t.Fatalf("list-share missing from %+v", shares.Shares)
+	}
+	if share.Uploads != 2 || share.Active != 1 || share.Completed != 1 || share.Expired {
+		t.Errorf("share = %+v", *share)
+	}
+	// The completed file counts with what it stores, the active upload with what it declared
+	if want := byID["list-done"].StoredBytes + 17; share.QuotaBytes != want {
+		t.Errorf("quota bytes = %d, want %d", share.QuotaBytes, want)
+	}
+
+	req := httptest.NewRequest(fiber.MethodGet, "/v1/admin/shares", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer wrong")
+	if resp, err := app.Test(req, -1); err != nil || resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("wrong token: %v %v, want 401", resp.StatusCode, err)
+	}
+}
+
+func TestAdminAbortUpload(t *testing.T) {
+	app := newApp()
+	startUpload(t, app, "abort-me", "abort-share", testChunks, 0)
+	room := services.EventLog.Subscribe(services.RoomStream("abort-share"))
+	defer services.EventLog.Unsubscribe(services.RoomStream("abort-share"), room)
+	before := services.EventLog.LastID(services.RoomStream("abort-share"))
+
+	if code := call(t, app, fiber.MethodDelete, "/v1/admin/uploads/abort-me", nil, nil); code != fiber.StatusNoContent {
+		t.Fatalf("abort: status %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(config.StorageRoot, "abort-me")); !os.IsNotExist(err) {
+		t.Errorf("aborted upload is still stored: %v", err)
+	}
+	events, _ := services.EventLog.Since(services.RoomStream("abort-share"), before)
+	if len(events) == 0 {
+		t.Fatal("room was not told")
+	}
+	var ev models.RoomEvent
+	json.Unmarshal([]byte(events[0].Data), &ev)
+	if ev.Type != models.EventUploadFailed || ev.UploadID != "abort-me" {
+		t.Errorf("room event = %s", events[0].Data)
+	}
+
+	var apiErr models.ErrorEnvelope
+	if code := call(t, app, fiber.MethodDelete, "/v1/admin/uploads/abort-me", nil, &apiErr); code != fiber.StatusNotFound || apiErr.Error.Code != models.CodeUploadNotFound {
+		t.Errorf("second abort: status %d %+v, want 404", code, apiErr)
+	}
+
+	startUpload(t, app, "abort-done", "abort-share", testChunks, 0, 1, 2)
+	call(t, app, fiber.MethodPost, "/v1/complete/abort-done", nil, nil)
+	if code := call(t, app, fiber.MethodDelete, "/v1/admin/uploads/abort-done", nil, &apiErr); code != fiber.StatusConflict || apiErr.Error.Code != models.CodeUploadCompleted {
+		t.Errorf("abort of a completed upload: status %d %+v, want 409", code, apiErr)
+	}
+	if _, err := os.Stat(filepath.Join(config.StorageRoot, "abort-done", "abort-done.txt")); err != nil {
+		t.Errorf("completed file was removed: %v", err)
+	}
+}
+
+func TestAdminForceComplete(t *testing.T) {
+	app := newApp()
+	services.Sinks.Register(sinks.NewDir(t.TempDir()))
+	if err := services.Sinks.SetPolicy(models.SinkPolicy{ShareID: "force-share", Sinks: []string{"dir"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer services.Sinks.RemovePolicy("force-share")
+	startUpload(t, app, "force-gap", "force-share", testChunks, 0, 2)
+	room := services.EventLog.Subscribe(services.RoomStream("force-share"))
+	defer services.EventLog.Unsubscribe(services.RoomStream("force-share"), room)
+	before := services.EventLog.LastID(services.RoomStream("force-share"))
+
+	var apiErr models.ErrorEnvelope
+	if code := call(t, app, fiber.MethodPost, "/v1/complete/force-gap", nil, &apiErr); code != fiber.StatusBadRequest || apiErr.Error.Code != models.CodeChunksMissing {
+		t.Fatalf("client complete: status %d %+v, want 400 chunks_missing", code, apiErr)
+	}
+
+	var result models.CompleteResult
+	if code := call(t, app, fiber.MethodPost, "/v1/admin/uploads/force-gap/complete", nil, &result); code != fiber.StatusOK {
+		t.Fatalf("forced complete: status %d", code)
+	}
+	if result.Status != "forced" || fmt.Sprint(result.FilledChunks) != "[1]" {
+		t.Errorf("result = %+v", result)
+	}
+	if want := helpers.HashChunk(bytes.Join(testChunks, nil)); result.ExpectedHash != want || result.FileHash == want {
+		t.Errorf("hashes: file %s, expected %s; want expected %s", result.FileHash, result.ExpectedHash, want)
+	}
+	data, err := os.ReadFile(result.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "first-" + string(make([]byte, 6)) + "third"
+	if string(data) != want {
+		t.Errorf("assembled %q, want %q", data, want)
+	}
+
+	// The file is flagged wherever it shows up, and kept away from sinks
+	var info models.FileMetadata
+	call(t, app, fiber.MethodGet, "/v1/file/force-gap?share_id=force-share", nil, &info)
+	if info.Forced == nil || fmt.Sprint(info.Forced.FilledChunks) != "[1]" || info.Forced.ExpectedHash != result.ExpectedHash {
+		t.Errorf("file info forced = %+v", info.Forced)
+	}
+	if len(info.Sinks) != 0 {
+		t.Errorf("forced file was queued for sinks: %+v", info.Sinks)
+	}
+	var state models.RoomState
+	call(t, app, fiber.MethodGet, "/v1/room/force-share", nil, &state)
+	if len(state.CompletedFiles) != 1 || state.CompletedFiles[0].Forced == nil {
+		t.Errorf("room state completed files = %+v", state.CompletedFiles)
+	}
+	events, _ := services.EventLog.Since(services.RoomStream("force-share"), before)
+	var ev models.RoomEvent
+	if len(events) > 0 {
+		json.Unmarshal([]byte(events[len(events)-1].Data), &ev)
+	}
+	if data, _ := ev.Data.(map[string]interface{}); ev.Type != "upload_complete" || data["forced"] == nil {
+		t.Errorf("room event = %+v", ev)
+	}
+
+	// With every chunk present the override changes nothing
+	startUpload(t, app, "force-full", "force-share", testChunks, 0, 1, 2)
+	var full models.CompleteResult
+	call(t, app, fiber.MethodPost, "/v1/admin/uploads/force-full/complete", nil, &full)
+	if full.Status != "assembled" || full.FilledChunks != nil || full.ExpectedHash != "" {
+		t.Errorf("complete upload forced: %+v", full)
+	}
+	var fullInfo models.FileMetadata
+	call(t, app, fiber.MethodGet, "/v1/file/force-full?share_id=force-share", nil, &fullInfo)
+	if fullInfo.Forced != nil || len(fullInfo.Sinks) != 1 {
+		t.Errorf("intact file: forced %+v, sinks %+v", fullInfo.Forced, fullInfo.Sinks)
+	}
+	if code := call(t, app, fiber.MethodPost, "/v1/admin/uploads/force-full/complete", nil, &apiErr); code != fiber.StatusConflict {
+		t.Errorf("forcing an assembled upload: status %d, want 409", code)
+	}
+	if code := call(t, app, fiber.MethodPost, "/v1/admin/uploads/force-none/complete", nil, nil); code != fiber.StatusNotFound {
+		t.Errorf("forcing an unknown upload: status %d, want 404", code)
+	}
+}
+
+func TestAdminSetRoomExpiry(t *testing.T) {
+	app := newApp()
+	startUpload(t, app, "expiry-upload", "expiry-share", testChunks, 0)
+	path := "/v1/admin/rooms/expiry-share/expiry"
+
+	type expiry struct {
+		ShareID   string    `json:"share_id"`
+		ExpiresAt time.Time `json:"expires_at"`
+		ExpiresIn int64     `json:"expires_in"`
+	}
+	set := func(t *testing.T, body interface{}) expiry {
+		t.Helper()
+		var got expiry
+		if code := call(t, app, fiber.MethodPut, path, body, &got); code != fiber.StatusOK {
+			t.Fatalf("set expiry %v: status %d", body, code)
+		}
+		if stored := services.Room.GetRoomExpiry("expiry-share"); !stored.Equal(got.ExpiresAt) {
+			t.Errorf("room expires at %s, answer says %s", stored, got.ExpiresAt)
+		}
+		return got
+	}
+
+	t.Run("expires_at", func(t *testing.T) {
+		at := time.Now().Add(72 * time.Hour).Truncate(time.Second)
+		got := set(t, map[string]interface{}{"expires_at": at})
+		if !got.ExpiresAt.Equal(at) || got.ShareID != "expiry-share" {
+			t.Errorf("answer = %+v, want expiry %s", got, at)
+		}
+	})
+	t.Run("expires_in", func(t *testing.T) {
+		before := time.Now()
+		got := set(t, map[string]string{"expires_in": "2h"})
+		if got.ExpiresAt.Before(before.Add(2*time.Hour)) || got.ExpiresAt.After(time.Now().Add(2*time.Hour)) {
+			t.Errorf("expires at %s, want 2h from %s", got.ExpiresAt, before)
+		}
+		if got.ExpiresIn < 7190 || got.ExpiresIn > 7200 {
+			t.Errorf("expires_in = %d", got.ExpiresIn)
+		}
+	})
+	t.Run("extend_by", func(t *testing.T) {
+		current := services.Room.GetRoomExpiry("expiry-share")
+		got := set(t, map[string]string{"extend_by": "-30m"})
+		if want := current.Add(-30 * time.Minute); !got.ExpiresAt.Equal(want) {
+			t.Errorf("expires at %s, want %s", got.ExpiresAt, want)
+		}
+	})
+	t.Run("into the past", func(t *testing.T) {
+		got := set(t, map[string]string{"expires_in": "-1m"})
+		if got.ExpiresIn != 0 {
+			t.Errorf("expires_in = %d, want 0", got.ExpiresIn)
+		}
+		var shares struct{ Shares []models.ShareSummary }
+		call(t, app, fiber.MethodGet, "/v1/admin/shares", nil, &shares)
+		for _, s := range shares.Shares {
+			if s.ShareID == "expiry-share" && !s.Expired {
+				t.Errorf("share is not expired: %+v", s)
+			}
+		}
+	})
+
+	for name, body := range map[string]interface{}{
+		"none":         map[string]string{},
+		"two":          map[string]string{"expires_in": "1h", "extend_by": "1h"},
+		"bad duration": map[string]string{"extend_by": "soon"},
+	} {
+		if code := call(t, app, fiber.MethodPut, path, body, nil); code != fiber.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, code)
+		}
+	}
+
+	var apiErr models.ErrorEnvelope
+	code := call(t, app, fiber.MethodPut, "/v1/admin/rooms/no-such-share/expiry", map[string]string{"expires_in": "1h"}, &apiErr)
+	if code != fiber.StatusNotFound || apiErr.Error.Code != models.CodeNotFound {
+		t.Errorf("unknown share: status %d %+v, want 404", code, apiErr)
+	}
+	if services.Room.HasRoom("no-such-share") {
+		t.Error("unknown share got a room")
+	}
+}
+
+func TestAdminKickClients(t *testing.T) {
+	app := newApp()
+	roomStream := services.RoomStream("kick-share")
+	uploadStream := services.UploadStream("kick-upload")
+	subs := []chan struct{}{
+		services.EventLog.Subscribe(roomStream),
+		services.EventLog.Subscribe(roomStream),
+	}
+	upload := services.EventLog.Subscribe(uploadStream)
+	defer services.EventLog.Unsubscribe(uploadStream, upload)
+
+	var kicked struct{ Kicked int }
+	if code := call(t, app, fiber.MethodDelete, "/v1/admin/rooms/kick-share/clients", nil, &kicked); code != fiber.StatusOK || kicked.Kicked != 2 {
+		t.Fatalf("kick room: status %d, kicked %d, want 2", code, kicked.Kicked)
+	}
+	for i, ch := range subs {
+		select {
+		case <-ch:
+		default:
+			t.Errorf("subscriber %d was not woken", i)
+		}
+		if !services.EventLog.Kicked(ch) {
+			t.Errorf("subscriber %d is not kicked", i)
+		}
+		services.EventLog.Unsubscribe(roomStream, ch)
+	}
+	if n := services.EventLog.Subscribers(roomStream); n != 0 {
+		t.Errorf("room still has %d subscribers", n)
+	}
+	if services.EventLog.Kicked(upload) {
+		t.Error("kicking a room kicked an upload's client")
+	}
+
+	call(t, app, fiber.MethodDelete, "/v1/admin/uploads/kick-upload/clients", nil, &kicked)
+	if kicked.Kicked != 1 || !services.EventLog.Kicked(upload) {
+		t.Errorf("kick upload: kicked %d", kicked.Kicked)
+	}
+	call(t, app, fiber.MethodDelete, "/v1/admin/rooms/kick-share/clients", nil, &kicked)
+	if kicked.Kicked != 0 {
+		t.Errorf("second kick: kicked %d, want 0", kicked.Kicked)
+	}
+}
+
+func TestAdminRunJanitor(t *testing.T) {
+	app := newApp()
+	startUpload(t, app, "janitor-upload", "janitor-share", testChunks, 0)
+	logPath := filepath.Join(config.EventRoot, "upload", "janitor-upload.jsonl")
+	if _, err := os.Stat(logPath); err != nil {
+		t.Fatalf("upload has no event log: %v", err)
+	}
+	var storage struct{ Storage models.StorageUsage }
+	call(t, app, fiber.MethodGet, "/v1/admin/storage", nil, &storage)
+	reservations := storage.Storage.Reservations
+	services.Room.SetRoomExpiry("janitor-share", time.Now().Add(-time.Minute))
+
+	var report models.JanitorReport
+	if code := call(t, app, fiber.MethodPost, "/v1/admin/janitor", nil, &report); code != fiber.StatusOK {
+		t.Fatalf("janitor: status %d", code)
+	}
+	expired := false
+	for _, shareID := range report.ExpiredRooms {
+		expired = expired || shareID == "janitor-share"
+	}
+	if !expired {
+		t.Errorf("expired rooms = %v, want janitor-share", report.ExpiredRooms)
+	}
+	if report.ReleasedReservations < 1 {
+		t.Errorf("released %d reservations", report.ReleasedReservations)
+	}
+	if report.PrunedEventLogs < 1 {
+		t.Errorf("pruned %d event logs", report.PrunedEventLogs)
+	}
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("event log of the expired upload is kept: %v", err)
+	}
+
+	call(t, app, fiber.MethodGet, "/v1/admin/storage", nil, &storage)
+	if storage.Storage.Reservations != reservations-report.ReleasedReservations {
+		t.Errorf("%d reservations after releasing %d of %d", storage.Storage.Reservations, report.ReleasedReservations, reservations)
+	}
+
+	// A second run has nothing left to do for the room
+	call(t, app, fiber.MethodPost, "/v1/admin/janitor", nil, &report)
+	for _, shareID := range report.ExpiredRooms {
+		if shareID == "janitor-share" {
+			t.Error("room expired twice")
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"encoding/json"
+	"sync"
+
+	"aetherlink/config"
+	"aetherlink/logging"
+	"aetherlink/middleware"
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// peerResponses sends the request to every other node in cluster mode and
+// returns their answers, so listings include the uploads other nodes own.
+// The caller's credentials go along, so admin listings are checked by each
+// peer. Unreachable peers are logged and left out. Requests forwarded by a
+// peer are answered from local storage only.
+func peerResponses(c *fiber.Ctx) []json.RawMessage {
+	cluster := services.Cluster
+	if cluster == nil || middleware.ForwardedBy(c) != "" {
+		return nil
+	}
+	logger := logging.FromContext(c)
+	uri := c.OriginalURL()
+	authorization := c.Get(fiber.HeaderAuthorization)
+	peers := cluster.Peers()
+	answers := make([]json.RawMessage, len(peers))
+	var wg sync.WaitGroup
+	for i, node := range peers {
+		wg.Add(1)
+		go func(i int, node config.ClusterNode) {
+			defer wg.Done()
+			if err := cluster.Fetch(c.UserContext(), node, uri, authorization, &answers[i]); err != nil {
+				logger.Warn("cluster peer unavailable, listing is partial", "event", "cluster_gather",
+					"node", node.ID, "error", err)
+			}
+		}(i, node)
+	}
+	wg.Wait()
+
+	var out []json.RawMessage
+	for _, a := range answers {
+		if a != nil {
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,13 @@
+package controllers
+
+import (
+	"aetherlink/middleware"
+	"aetherlink/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiError answers with an error in the format of the request's API version
+func apiError(c *fiber.Ctx, status int, code, message string) error {
+	return middleware.SendError(c, models.NewAPIError(status, code, message))
+}
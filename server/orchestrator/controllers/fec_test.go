@@ -0,0 +1,147 @@
+package controllers_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/reedsolomon"
+)
+
+// fecChunks splits content into 4-byte chunks in stripes of 4 data and 2
+// parity chunks, as clients do, and returns the session metadata and every
+// chunk, parity included
+func fecChunks(t *testing.T, uploadID, content string) (models.Metadata, [][]byte) {
+	t.Helper()
+	const chunkSize, dataShards, parityShards = 4, 4, 2
+	var chunks [][]byte
+	for off := 0; off < len(content); off += chunkSize {
+		chunks = append(chunks, []byte(content[off:min(off+chunkSize, len(content))]))
+	}
+	md := models.Metadata{
+		UploadID:     uploadID,
+		ShareID:      "fec-share",
+		Filename:     uploadID + ".txt",
+		TotalChunks:  len(chunks),
+		ChunkSize:    chunkSize,
+		FileSize:     int64(len(content)),
+		FileHash:     helpers.HashChunk([]byte(content)),
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s := 0; s < md.Stripes(); s++ {
+		shards := make([][]byte, dataShards+parityShards)
+		for i := range shards {
+			shards[i] = make([]byte, chunkSize)
+			if idx := s*dataShards + i; i < dataShards && idx < md.TotalChunks {
+				copy(shards[i], chunks[idx])
+			}
+		}
+		if err := enc.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, shards[dataShards:]...)
+	}
+	for idx, c := range chunks {
+		if idx < md.TotalChunks {
+			md.ChunkHashes = append(md.ChunkHashes, helpers.HashChunk(c))
+		} else {
+			md.ParityHashes = append(md.ParityHashes, helpers.HashChunk(c))
+		}
+	}
+	return md, chunks
+}
+
+// sendFEC opens an FEC upload and sends every chunk but those in skip
+func sendFEC(t *testing.T, app *fiber.App, md models.Metadata, chunks [][]byte, skip ...int) {
+	t.Helper()
+	if code := call(t, app, fiber.MethodPost, "/v1/init", md, nil); code != fiber.StatusCreated {
+		t.Fatalf("init %s: status %d", md.UploadID, code)
+	}
+	skipped := make(map[int]bool)
+	for _, idx := range skip {
+		skipped[idx] = true
+	}
+	for idx, c := range chunks {
+		if skipped[idx] {
+			continue
+		}
+		if code := call(t, app, fiber.MethodPut, fmt.Sprintf("/v1/upload/%s/%d", md.UploadID, idx), c, nil); code != fiber.StatusOK {
+			t.Fatalf("chunk %d of %s: status %d", idx, md.UploadID, code)
+		}
+	}
+}
+
+// 22 bytes: six data chunks, the last 2 bytes long, in a full stripe 0
+// (chunks 0-3, parity 6-7) and a short stripe 1 (chunks 4-5, parity 8-9)
+const fecContent = "reed-solomon repairs!!"
+
+func TestCompleteRebuildsChunksFromParity(t *testing.T) {
+	app := newApp()
+	md, chunks := fecChunks(t, "fec-repair", fecContent)
+	// Chunk 1 never arrives, nor does the short last chunk of stripe 1
+	sendFEC(t, app, md, chunks, 1, 5)
+	// Chunk 2 arrived intact but rotted on disk
+	if err := os.WriteFile(helpers.ChunkPath(filepath.Join(config.StorageRoot, md.UploadID), 2), []byte("rot!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var status models.UploadStatus
+	call(t, app, fiber.MethodGet, "/v1/status/fec-repair", nil, &status)
+	if !status.Recoverable || len(status.UnrecoverableStripes) != 0 {
+		t.Errorf("status = %+v, want recoverable", status)
+	}
+
+	var result models.CompleteResult
+	if code := call(t, app, fiber.MethodPost, "/v1/complete/fec-repair", nil, &result); code != fiber.StatusOK {
+		t.Fatalf("complete: status %d", code)
+	}
+	data, err := os.ReadFile(result.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != fecContent || result.FileHash != md.FileHash {
+		t.Errorf("assembled %q (hash %s), want %q (hash %s)", data, result.FileHash, fecContent, md.FileHash)
+	}
+}
+
+func TestStatusReportsUnrecoverableStripes(t *testing.T) {
+	app := newApp()
+	md, chunks := fecChunks(t, "fec-lost", fecContent)
+	// Stripe 0 lost three chunks against two parity; stripe 1 only one
+	sendFEC(t, app, md, chunks, 0, 3, 7, 4)
+
+	var status models.UploadStatus
+	if code := call(t, app, fiber.MethodGet, "/v1/status/fec-lost", nil, &status); code != fiber.StatusOK {
+		t.Fatalf("status: %d", code)
+	}
+	if status.Recoverable || fmt.Sprint(status.UnrecoverableStripes) != "[0]" {
+		t.Errorf("status = %+v, want stripe 0 unrecoverable", status)
+	}
+
+	var apiErr models.ErrorEnvelope
+	if code := call(t, app, fiber.MethodPost, "/v1/complete/fec-lost", nil, &apiErr); code != fiber.StatusBadRequest || apiErr.Error.Code != models.CodeChunksMissing {
+		t.Fatalf("complete: status %d %+v, want 400 chunks_missing", code, apiErr)
+	}
+	missing, _ := apiErr.Error.Details["missing_chunks"].([]interface{})
+	if fmt.Sprint(missing) != "[0 3]" || fmt.Sprint(apiErr.Error.Details["unrecoverable_stripes"]) != "[0]" {
+		t.Errorf("details = %v", apiErr.Error.Details)
+	}
+
+	// Stripe 1 was still rebuilt for a later attempt
+	received, _ := helpers.ReadReceivedChunks(filepath.Join(config.StorageRoot, md.UploadID))
+	if !slices.Contains(received, 4) {
+		t.Errorf("received %v, want rebuilt chunk 4", received)
+	}
+}
@@ -2,40 +2,24 @@ package controllers
 
 import (
 	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/middleware"
 	"aetherlink/models"
+	"aetherlink/services"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-type FileMetadata struct {
-	UploadID             string    `json:"upload_id"`
-	Filename             string    `json:"filename"`
-	TotalChunks          int       `json:"total_chunks"`
-	ReceivedChunks       int       `json:"received_chunks"`
-	FileSize             int64     `json:"file_size"`
-	UploadTime           time.Time `json:"upload_time"`
-	Status               string    `json:"status"`
-	CompletionPercentage float64   `json:"completion_percentage"`
-}
-
-type FilesResponse struct {
-	Files []FileMetadata `json:"files"`
-	Count int            `json:"count"`
-}
-
 // FilesHandler returns a list of files for a specific share ID
 func FilesHandler(c *fiber.Ctx) error {
 	shareID := c.Query("share_id")
 	if shareID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "share_id is required. Please provide a share_id query parameter.",
-		})
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "share_id is required. Please provide a share_id query parameter.")
 	}
 
 	storageRoot := config.StorageRoot
@@ -43,12 +27,10 @@ func FilesHandler(c *fiber.Ctx) error {
 	// Read all directories in storage root
 	entries, err := ioutil.ReadDir(storageRoot)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read storage directory",
-		})
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to read storage directory")
 	}
 
-	var files []FileMetadata
+	var files []models.FileMetadata
 
 	for _, entry := range entries {
 		if !entry.IsDir() {
@@ -86,7 +68,7 @@ func FilesHandler(c *fiber.Ctx) error {
 		if receivedData, err := ioutil.ReadFile(receivedPath); err == nil {
 			var receivedIndices []int
 			if err := json.Unmarshal(receivedData, &receivedIndices); err == nil {
-				receivedChunks = len(receivedIndices)
+				receivedChunks = len(helpers.DataChunks(receivedIndices, metadata.TotalChunks))
 			}
 		}
 
@@ -111,11 +93,11 @@ func FilesHandler(c *fiber.Ctx) error {
 		}
 
 		// Determine status
-		status := "incomplete"
+		status := models.FileIncomplete
 		if receivedChunks >= metadata.TotalChunks {
 			// Check if complete file exists
 			if _, err := os.Stat(completedFilePath); err == nil {
-				status = "complete"
+				status = models.FileComplete
 			}
 		}
 
@@ -128,7 +110,7 @@ func FilesHandler(c *fiber.Ctx) error {
 		// Get upload time (use directory creation time)
 		uploadTime := entry.ModTime()
 
-		files = append(files, FileMetadata{
+		files = append(files, models.FileMetadata{
 			UploadID:             uploadID,
 			Filename:             metadata.Filename,
 			TotalChunks:          metadata.TotalChunks,
@@ -137,15 +119,24 @@ func FilesHandler(c *fiber.Ctx) error {
 			UploadTime:           uploadTime,
 			Status:               status,
 			CompletionPercentage: completionPercentage,
+			Forced:               metadata.Forced,
 		})
 	}
 
+	// In cluster mode the share's other uploads are stored on their owners
+	for _, answer := range peerResponses(c) {
+		var peer models.FilesResponse
+		if err := json.Unmarshal(answer, &peer); err == nil {
+			files = append(files, peer.Files...)
+		}
+	}
+
 	// Sort by upload time (newest first)
 	sort.Slice(files, func(i, j int) bool {
 		return files[i].UploadTime.After(files[j].UploadTime)
 	})
 
-	return c.JSON(FilesResponse{
+	return c.JSON(models.FilesResponse{
 		Files: files,
 		Count: len(files),
 	})
@@ -153,19 +144,15 @@ func FilesHandler(c *fiber.Ctx) error {
 
 // FileInfoHandler returns detailed information about a specific file
 func FileInfoHandler(c *fiber.Ctx) error {
-	uploadID := c.Params("uploadID")
+	uploadID := middleware.Param(c, "uploadID")
 	shareID := c.Query("share_id")
 
 	if uploadID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "upload_id is required",
-		})
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "upload_id is required")
 	}
 
 	if shareID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "share_id is required",
-		})
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "share_id is required")
 	}
 
 	storageRoot := config.StorageRoot
@@ -174,31 +161,23 @@ func FileInfoHandler(c *fiber.Ctx) error {
 
 	// Check if metadata exists
 	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "File not found",
-		})
+		return apiError(c, fiber.StatusNotFound, models.CodeFileNotFound, "File not found")
 	}
 
 	// Read metadata
 	data, err := ioutil.ReadFile(metadataPath)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read metadata",
-		})
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to read metadata")
 	}
 
 	var metadata models.Metadata
 	if err := json.Unmarshal(data, &metadata); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to parse metadata",
-		})
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to parse metadata")
 	}
 
 	// Verify share ID matches
 	if metadata.ShareID != shareID {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "Access denied. Invalid share ID.",
-		})
+		return apiError(c, fiber.StatusForbidden, models.CodeAccessDenied, "Access denied. Invalid share ID.")
 	}
 
 	// Count received chunks from received.json
@@ -208,7 +187,7 @@ func FileInfoHandler(c *fiber.Ctx) error {
 	if receivedData, err := ioutil.ReadFile(receivedPath); err == nil {
 		var receivedIndices []int
 		if err := json.Unmarshal(receivedData, &receivedIndices); err == nil {
-			receivedChunks = len(receivedIndices)
+			receivedChunks = len(helpers.DataChunks(receivedIndices, metadata.TotalChunks))
 		}
 	}
 
@@ -227,10 +206,10 @@ func FileInfoHandler(c *fiber.Ctx) error {
 	}
 
 	// Determine status
-	status := "incomplete"
+	status := models.FileIncomplete
 	if receivedChunks >= metadata.TotalChunks {
 		if _, err := os.Stat(completedFilePath); err == nil {
-			status = "complete"
+			status = models.FileComplete
 		}
 	}
 
@@ -242,7 +221,7 @@ func FileInfoHandler(c *fiber.Ctx) error {
 
 	// Get upload time
 	if dirInfo, err := os.Stat(uploadDir); err == nil {
-		return c.JSON(FileMetadata{
+		return c.JSON(models.FileMetadata{
 			UploadID:             uploadID,
 			Filename:             metadata.Filename,
 			TotalChunks:          metadata.TotalChunks,
@@ -251,24 +230,22 @@ func FileInfoHandler(c *fiber.Ctx) error {
 			UploadTime:           dirInfo.ModTime(),
 			Status:               status,
 			CompletionPercentage: completionPercentage,
+			Sinks:                metadata.Sinks,
+			Forced:               metadata.Forced,
 		})
 	}
 
-	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-		"error": "Failed to get file info",
-	})
+	return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to get file info")
 }
 
 // SecureDownloadHandler allows downloading files only with valid share ID
 func SecureDownloadHandler(c *fiber.Ctx) error {
-	uploadID := c.Params("uploadID")
+	uploadID := middleware.Param(c, "uploadID")
 	filename := c.Params("filename")
 	shareID := c.Query("share_id")
 
 	if shareID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "share_id is required",
-		})
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "share_id is required")
 	}
 
 	storageRoot := config.StorageRoot
@@ -278,26 +255,34 @@ func SecureDownloadHandler(c *fiber.Ctx) error {
 	// Read and verify metadata
 	data, err := ioutil.ReadFile(metadataPath)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "File not found",
-		})
+		return apiError(c, fiber.StatusNotFound, models.CodeFileNotFound, "File not found")
 	}
 
 	var metadata models.Metadata
 	if err := json.Unmarshal(data, &metadata); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid metadata",
-		})
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Invalid metadata")
 	}
 
 	// Verify share ID
 	if metadata.ShareID != shareID {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "Access denied. Invalid share ID.",
-		})
+		return apiError(c, fiber.StatusForbidden, models.CodeAccessDenied, "Access denied. Invalid share ID.")
 	}
 
-	// Serve the file
+	// Serve the file, paced by the bandwidth limits when any apply
 	filePath := filepath.Join(uploadDir, filename)
-	return c.SendFile(filePath)
+	if !services.Throttle.Enabled() {
+		return c.SendFile(filePath)
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return apiError(c, fiber.StatusNotFound, models.CodeFileNotFound, "File not found")
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return apiError(c, fiber.StatusNotFound, models.CodeFileNotFound, "File not found")
+	}
+	c.Type(filepath.Ext(filename))
+	priority := services.ParsePriority(c.Get("X-Priority"))
+	return c.SendStream(services.Throttle.Reader(f, uploadID, metadata.ShareID, priority), int(info.Size()))
 }
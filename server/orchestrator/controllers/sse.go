@@ -2,10 +2,15 @@ package controllers
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"aetherlink/config"
+	"aetherlink/metrics"
+	"aetherlink/middleware"
+	"aetherlink/models"
 	"aetherlink/services"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,45 +18,88 @@ import (
 
 // SSEHandler handles Server-Sent Events for real-time progress updates
 func SSEHandler(c *fiber.Ctx) error {
-	uploadID := c.Params("uploadID")
+	uploadID := middleware.Param(c, "uploadID")
 
+	ch := services.SSE.AddClient(uploadID)
+	return streamEvents(c, services.UploadStream(uploadID), metrics.KindUpload, ch,
+		func() (string, bool) {
+			return services.SSE.ProgressMessage(uploadID, config.StorageRoot)
+		},
+		func() {
+			services.SSE.RemoveClient(uploadID, ch)
+		},
+	)
+}
+
+// lastEventID reads the replay position from the Last-Event-ID header sent by
+// reconnecting EventSources, or from ?last_event_id= for the first connect
+func lastEventID(c *fiber.Ctx) string {
+	value := c.Get("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	return strings.TrimSpace(value)
+}
+
+// streamEvents serves an event log stream over SSE. A client resuming from
+// Last-Event-ID first gets the events it missed; every client then gets a
+// snapshot of the current state (without an id, so it does not move the
+// replay position) followed by live events.
+func streamEvents(c *fiber.Ctx, stream, kind string, wake chan struct{}, snapshot func() (string, bool), unsubscribe func()) error {
 	c.Set("Content-Type", "text/event-stream")
 	c.Set("Cache-Control", "no-cache")
 	c.Set("Connection", "keep-alive")
 	c.Set("Transfer-Encoding", "chunked")
 
-	ch := make(chan string, 10)
-	done := make(chan struct{})
-
-	services.SSE.AddClient(uploadID, ch)
-
-	// send initial progress once
-	go func() {
-		time.Sleep(10 * time.Millisecond)
-		services.SSE.BroadcastProgress(uploadID, config.StorageRoot)
-	}()
+	// Positions are local log IDs; clients may send IDs issued by another replica
+	var lastID uint64
+	resumeFrom := lastEventID(c)
+	resume := resumeFrom != ""
+	if resume {
+		lastID = services.EventLog.Resolve(stream, resumeFrom)
+	} else {
+		lastID = services.EventLog.LastID(stream)
+	}
 
-	// keep connection open and send messages
 	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
 		ticker := time.NewTicker(15 * time.Second)
 		defer ticker.Stop()
+		defer unsubscribe()
 
-		// cleanup on disconnect
-		defer func() {
-			close(done)
-			services.SSE.RemoveClient(uploadID, ch)
-			close(ch)
-		}()
+		// writeSince sends logged events after lastID
+		writeSince := func() error {
+			events, gap := services.EventLog.Since(stream, lastID)
+			if gap && len(events) > 0 {
+				// Evicted before this client read them
+				metrics.SSEDropped.WithLabelValues(kind).Add(float64(events[0].ID - lastID - 1))
+			}
+			for _, ev := range events {
+				writeEvent(w, ev)
+				lastID = ev.ID
+			}
+			return w.Flush()
+		}
+
+		if resume {
+			if err := writeSince(); err != nil {
+				return
+			}
+		}
+		if msg, ok := snapshot(); ok {
+			fmt.Fprintf(w, "data: %s\n\n", msg)
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
 
 		for {
 			select {
-			case msg, ok := <-ch:
-				if !ok {
+			case <-wake:
+				if services.EventLog.Kicked(wake) {
+					// Disconnected by an operator
 					return
 				}
-				// write as SSE "data: <json>\n\n"
-				fmt.Fprintf(w, "data: %s\n\n", msg)
-				if err := w.Flush(); err != nil {
+				if err := writeSince(); err != nil {
 					return
 				}
 			case <-ticker.C:
@@ -60,8 +108,14 @@ func SSEHandler(c *fiber.Ctx) error {
 				if err := w.Flush(); err != nil {
 					return
 				}
-			case <-done:
-				// connection closed
+			case <-services.Lifecycle.ShuttingDown():
+				// Deliver what is already logged, then tell the client when to
+				// reconnect; it resumes from lastID on this or another node
+				if err := writeSince(); err != nil {
+					return
+				}
+				writeShutdown(w)
+				w.Flush()
 				return
 			}
 		}
@@ -69,3 +123,21 @@ func SSEHandler(c *fiber.Ctx) error {
 
 	return nil
 }
+
+// writeShutdown writes a server_shutdown event without an id, so the
+// client's Last-Event-ID still points at the last logged event. The retry
+// field sets the browser's EventSource reconnection delay.
+func writeShutdown(w *bufio.Writer) {
+	delay := config.ShutdownReconnectDelay.Milliseconds()
+	data, _ := json.Marshal(models.RoomEvent{
+		Type:      models.EventServerShutdown,
+		Data:      fiber.Map{"reconnect_after_ms": delay},
+		Timestamp: time.Now(),
+	})
+	fmt.Fprintf(w, "retry: %d\ndata: %s\n\n", delay, data)
+}
+
+// writeEvent writes a logged event as SSE "id: <id>\ndata: <json>\n\n"
+func writeEvent(w *bufio.Writer, ev models.LoggedEvent) {
+	fmt.Fprintf(w, "id: %s\ndata: %s\n\n", ev.EventID(), ev.Data)
+}
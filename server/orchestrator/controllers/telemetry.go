@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/logging"
+	"aetherlink/middleware"
+	"aetherlink/models"
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TelemetryHandler stores the telemetry a client reports for an upload,
+// together with the server's own measurements of it
+func TelemetryHandler(c *fiber.Ctx) error {
+	uploadID := middleware.Param(c, "uploadID")
+	dir := filepath.Join(config.StorageRoot, uploadID)
+	mdBytes, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return apiError(c, fiber.StatusNotFound, models.CodeUploadNotFound, "Upload session not found")
+	}
+	var md models.Metadata
+	if err := json.Unmarshal(mdBytes, &md); err != nil {
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Invalid metadata")
+	}
+	logger := logging.FromContext(c).With("upload_id", uploadID, "share_id", md.ShareID)
+	logging.WithContext(c, logger)
+
+	var client models.ClientTelemetry
+	if err := c.BodyParser(&client); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid telemetry: "+err.Error())
+	}
+	switch client.NetworkQuality {
+	case "", "excellent", "good", "fair", "poor":
+	default:
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "network_quality must be excellent, good, fair or poor")
+	}
+
+	_, statErr := os.Stat(filepath.Join(dir, md.Filename))
+	completed := statErr == nil
+	record, err := services.Telemetry.Report(md, client, completed)
+	if err != nil {
+		logger.Error("failed to store telemetry", "event", "telemetry", "error", err)
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to store telemetry")
+	}
+	logger.Info("telemetry reported", "event", "telemetry", "network_quality", client.NetworkQuality)
+	return c.Status(fiber.StatusCreated).JSON(record)
+}
+
+// TelemetryAggregateHandler returns stored telemetry aggregated by
+// ?group_by=network_quality|chunk_size|effective_type, optionally limited to
+// ?since=<RFC3339 time or duration such as 24h> and ?network_quality=
+func TelemetryAggregateHandler(c *fiber.Ctx) error {
+	q := services.TelemetryQuery{
+		GroupBy:        c.Query("group_by"),
+		NetworkQuality: c.Query("network_quality"),
+	}
+	if since := c.Query("since"); since != "" {
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			q.Since = t
+		} else if d, err := time.ParseDuration(since); err == nil {
+			q.Since = time.Now().Add(-d)
+		} else {
+			return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "since must be an RFC3339 time or a duration")
+		}
+	}
+
+	groups, err := services.Telemetry.Aggregate(q)
+	if err == services.ErrBadGroupBy {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, err.Error())
+	}
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to read telemetry")
+	}
+	groupBy := q.GroupBy
+	if groupBy == "" {
+		groupBy = services.GroupByNetworkQuality
+	}
+	return c.JSON(fiber.Map{
+		"group_by": groupBy,
+		"groups":   groups,
+	})
+}
@@ -3,51 +3,115 @@ package controllers
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
 
 	"aetherlink/config"
 	"aetherlink/helpers"
+	"aetherlink/logging"
+	"aetherlink/metrics"
+	"aetherlink/middleware"
 	"aetherlink/models"
 	"aetherlink/services"
+	"aetherlink/tracing"
 
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // InitHandler initializes a new upload session
 func InitHandler(c *fiber.Ctx) error {
 	var md models.Metadata
 	if err := c.BodyParser(&md); err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString("bad metadata: " + err.Error())
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid metadata: "+err.Error())
 	}
 	if md.UploadID == "" {
-		return c.Status(fiber.StatusBadRequest).SendString("upload_id required")
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "upload_id is required")
+	}
+	// Both IDs name directories under storage and the sinks
+	if !helpers.ValidID(md.UploadID) {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "upload_id may only contain letters, digits, '.', '-' and '_'")
+	}
+	if md.ShareID != "" && !helpers.ValidID(md.ShareID) {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "share_id may only contain letters, digits, '.', '-' and '_'")
+	}
+	// The file is assembled under this name in the upload's directory
+	if !helpers.ValidFilename(md.Filename) {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "filename must be a plain file name")
+	}
+
+	logger := logging.FromContext(c).With("upload_id", md.UploadID)
+	logging.WithContext(c, logger)
+
+	// Remember the priority the session was opened with
+	if md.Priority == "" {
+		md.Priority = services.ParsePriority(c.Get("X-Priority")).String()
+	} else {
+		md.Priority = services.ParsePriority(md.Priority).String()
+	}
+
+	if err := helpers.ValidateLayout(md); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid chunk layout: "+err.Error())
+	}
+	if err := helpers.ValidateFEC(md); err != nil {
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid FEC layout: "+err.Error())
 	}
 
 	// Generate unique share ID if not provided
 	if md.ShareID == "" {
 		md.ShareID = helpers.GenerateShareID()
 	}
+	logger = logger.With("share_id", md.ShareID)
+	logging.WithContext(c, logger)
+
+	// Reserve the declared size up front so the upload cannot run out of disk midway
+	if err := services.Capacity.ReserveSession(md); err != nil {
+		var qe *services.QuotaError
+		if errors.As(err, &qe) {
+			return quotaError(c, qe)
+		}
+		return storageError(c, err)
+	}
+
+	// Later requests without a traceparent header join this trace
+	md.TraceParent = tracing.TraceParent(c.UserContext())
 
 	dir := filepath.Join(config.StorageRoot, md.UploadID)
+	_, statErr := os.Stat(dir)
+	created := os.IsNotExist(statErr)
+	// abort gives the reservation back and removes a directory this request made
+	abort := func(message string) error {
+		services.Capacity.Release(md.UploadID)
+		if created {
+			os.RemoveAll(dir)
+		}
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, message)
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("mkdir error")
+		return abort("Failed to create upload directory")
 	}
 	metaPath := filepath.Join(dir, "metadata.json")
 	f, err := os.Create(metaPath)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("create meta failed")
+		return abort("Failed to create metadata")
 	}
-	defer f.Close()
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
-	if err := enc.Encode(md); err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("write meta failed")
+	err = enc.Encode(md)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return abort("Failed to write metadata")
 	}
 
 	// initialize received index tracking file (empty)
@@ -55,84 +119,93 @@ func InitHandler(c *fiber.Ctx) error {
 	_ = os.WriteFile(receivedPath, []byte("[]"), 0644)
 
 	// broadcast initial zero progress
+	_, span := tracing.Start(c.UserContext(), "sse.broadcast")
 	services.SSE.BroadcastProgress(md.UploadID, config.StorageRoot)
+	span.End()
 
 	// Notify room of upload start
 	services.Room.NotifyUploadStart(md.ShareID, md.UploadID, md.Filename)
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"upload_id": md.UploadID,
-		"share_id":  md.ShareID,
+	logger.Info("upload session started",
+		"event", "init",
+		"filename", md.Filename,
+		"total_chunks", md.TotalChunks,
+		"chunk_size", md.ChunkSize,
+		"priority", md.Priority,
+	)
+
+	return c.Status(fiber.StatusCreated).JSON(models.InitResponse{
+		UploadID: md.UploadID,
+		ShareID:  md.ShareID,
 	})
 }
 
 // UploadHandler handles chunk upload with hash validation and idempotency
 func UploadHandler(c *fiber.Ctx) error {
-	uploadID := c.Params("uploadID")
+	uploadID := middleware.Param(c, "uploadID")
 	idxStr := c.Params("idx")
 	idx, err := strconv.Atoi(idxStr)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString("bad idx")
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Chunk index must be an integer")
 	}
+	logger := logging.FromContext(c).With("upload_id", uploadID, "chunk", idx)
+	logging.WithContext(c, logger)
 
 	dir := filepath.Join(config.StorageRoot, uploadID)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Upload session not found",
-		})
+		return apiError(c, fiber.StatusNotFound, models.CodeUploadNotFound, "Upload session not found")
 	}
 
 	if c.Request().Header.ContentLength() > config.MaxUploadSize {
-		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
-			"error": "Request entity too large",
-		})
+		return apiError(c, fiber.StatusRequestEntityTooLarge, models.CodePayloadTooLarge, "Request entity too large")
 	}
 
 	// read metadata.json to get expected hash
 	metaPath := filepath.Join(dir, "metadata.json")
 	metaBytes, err := os.ReadFile(metaPath)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Metadata not found",
-		})
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Metadata not found")
 	}
 	var md models.Metadata
 	if err := json.Unmarshal(metaBytes, &md); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid metadata",
-		})
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Invalid metadata")
 	}
-	if idx < 0 || idx >= md.TotalChunks {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Chunk index out of range",
-		})
+	// Parity chunks follow the data chunks when FEC is enabled
+	logger = logger.With("share_id", md.ShareID)
+	logging.WithContext(c, logger)
+	ctx := c.UserContext()
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("share_id", md.ShareID),
+		attribute.Int("chunk", idx),
+	)
+
+	if idx < 0 || idx >= md.TotalUploadChunks() {
+		return apiError(c, fiber.StatusBadRequest, models.CodeChunkOutOfRange, "Chunk index out of range")
 	}
 
 	// Check if merged file already exists
 	mergedPath := filepath.Join(dir, md.Filename)
 	if _, err := os.Stat(mergedPath); err == nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error": "Upload already completed",
-		})
+		return apiError(c, fiber.StatusConflict, models.CodeUploadCompleted, "Upload already completed")
 	}
 
-	expectedHash := ""
-	if len(md.ChunkHashes) == md.TotalChunks {
-		expectedHash = md.ChunkHashes[idx]
-	}
+	expectedHash := helpers.ExpectedChunkHash(md, idx)
 
 	// read body bytes
 	body := c.Body()
 	if len(body) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Empty body",
-		})
+		return apiError(c, fiber.StatusBadRequest, models.CodeEmptyChunk, "Empty body")
 	}
 
 	// compute hash
+	_, hashSpan := tracing.Start(ctx, "hash.verify", trace.WithAttributes(
+		attribute.Int("chunk", idx),
+		attribute.Int("bytes", len(body)),
+	))
 	h := xxhash.New()
 	h.Write(body)
 	actualHash := hex.EncodeToString(h.Sum(nil))
+	hashSpan.SetAttributes(attribute.String("chunk_hash", actualHash))
 
 	// Check for idempotency - if chunk already exists
 	chunkPath := filepath.Join(dir, fmt.Sprintf("chunk_%06d", idx))
@@ -143,112 +216,166 @@ func UploadHandler(c *fiber.Ctx) error {
 
 		if existingHash == actualHash {
 			// Same chunk, return success without rewriting
-			log.Printf("[IDEMPOTENT] Chunk %d for upload %s already received (hash match)", idx, uploadID)
-			return c.JSON(fiber.Map{
-				"status":         "already_received",
-				"message":        fmt.Sprintf("Chunk %d already uploaded", idx),
-				"received_bytes": len(existingData),
-				"chunk_hash":     actualHash,
+			hashSpan.SetAttributes(attribute.Bool("idempotent", true))
+			hashSpan.End()
+			logger.Info("chunk already received", "event", "idempotent", "chunk_hash", actualHash)
+			metrics.IdempotentReplays.Inc()
+			services.Telemetry.RecordReplay(uploadID)
+			return c.JSON(models.ChunkReceipt{
+				Status:        models.ChunkAlreadyReceived,
+				Message:       fmt.Sprintf("Chunk %d already uploaded", idx),
+				ReceivedBytes: len(existingData),
+				ChunkHash:     actualHash,
+				Advice:        services.Advisor.Advise(uploadID, md.ChunkSize),
 			})
 		} else {
 			// Different chunk, will overwrite
-			log.Printf("[REPLACE] Chunk %d for upload %s has different hash, replacing", idx, uploadID)
+			logger.Warn("chunk has different hash, replacing", "event", "replace",
+				"existing_hash", existingHash, "chunk_hash", actualHash)
 		}
 	}
 
 	// If expectedHash exists, verify
 	if expectedHash != "" && expectedHash != actualHash {
 		// mismatch — reject so client retries
-		log.Printf("[HASH_MISMATCH] uploadID=%s idx=%d expected=%s actual=%s", uploadID, idx, expectedHash, actualHash)
-		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
-			"error":    "Chunk hash mismatch",
-			"expected": expectedHash,
-			"actual":   actualHash,
-		})
+		hashSpan.SetStatus(codes.Error, "chunk hash mismatch")
+		hashSpan.End()
+		logger.Warn("chunk hash mismatch", "event", "hash_mismatch", "expected", expectedHash, "actual", actualHash)
+		metrics.HashMismatches.Inc()
+		services.Telemetry.RecordMismatch(uploadID)
+		return middleware.SendError(c, models.NewAPIError(fiber.StatusUnprocessableEntity, models.CodeChunkHashMismatch, "Chunk hash mismatch").
+			With("expected", expectedHash).
+			With("actual", actualHash))
 	}
 
+	hashSpan.End()
+
 	// Check disk space
-	stat, err := os.Stat(config.StorageRoot)
-	if err == nil {
-		// This is a basic check - in production use syscall for actual disk space
-		if stat.Size() < 0 {
-			return c.Status(507).JSON(fiber.Map{
-				"error": "Insufficient storage",
-			})
-		}
+	if err := services.Capacity.Admit(uploadID, int64(len(body))); err != nil {
+		return storageError(c, err)
 	}
 
 	// write temp then move
+	_, writeSpan := tracing.Start(ctx, "chunk.write", trace.WithAttributes(attribute.Int("chunk", idx)))
+	writeStart := time.Now()
 	tmpPath := chunkPath + ".part"
 	if err := os.WriteFile(tmpPath, body, 0644); err != nil {
-		log.Printf("[WRITE_ERROR] Failed to write chunk %d for upload %s: %v", idx, uploadID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to write chunk",
-		})
+		tracing.RecordError(writeSpan, err)
+		writeSpan.End()
+		logger.Error("failed to write chunk", "event", "write_error", "error", err)
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to write chunk")
 	}
 	// move to final chunk file (atomic if possible)
 	if err := helpers.MoveFile(tmpPath, chunkPath); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to finalize chunk: " + err.Error(),
-		})
+		tracing.RecordError(writeSpan, err)
+		writeSpan.End()
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to finalize chunk: "+err.Error())
 	}
 	// write .xxhash for convenience
 	_ = os.WriteFile(chunkPath+".xxhash", []byte(actualHash), 0644)
+	writeSpan.End()
+	services.Capacity.Consume(uploadID, int64(len(body)))
+	writeDur := time.Since(writeStart)
+	services.Advisor.RecordChunk(uploadID, int64(len(body)), writeDur)
+	metrics.ChunksReceived.Inc()
+	metrics.ChunkBytes.Observe(float64(len(body)))
+	metrics.ChunkWriteDuration.Observe(writeDur.Seconds())
+	services.Telemetry.RecordChunk(uploadID, int64(len(body)), writeDur)
+
+	// picked up by the ingest throttle once the response is built
+	c.Locals("share_id", md.ShareID)
+	c.Locals("ingested_bytes", len(body))
 
 	// update received list
+	_, appendSpan := tracing.Start(ctx, "received.append")
 	if err := helpers.AppendReceivedChunk(dir, idx); err != nil {
-		log.Println("warning appendReceivedChunk:", err)
+		tracing.RecordError(appendSpan, err)
+		logger.Warn("failed to record received chunk", "error", err)
 	}
+	appendSpan.End()
 
 	// broadcast progress
+	_, sseSpan := tracing.Start(ctx, "sse.broadcast")
 	services.SSE.BroadcastProgress(uploadID, config.StorageRoot)
+	sseSpan.End()
 
 	// Notify room of chunk received
 	received, _ := helpers.ReadReceivedChunks(dir)
+	received = helpers.DataChunks(received, md.TotalChunks)
 	services.Room.NotifyChunkReceived(md.ShareID, uploadID, len(received), md.TotalChunks)
 
-	return c.JSON(fiber.Map{
-		"status":         "received",
-		"received_bytes": len(body),
-		"chunk_hash":     actualHash,
+	return c.JSON(models.ChunkReceipt{
+		Status:        models.ChunkReceived,
+		ReceivedBytes: len(body),
+		ChunkHash:     actualHash,
+		Advice:        services.Advisor.Advise(uploadID, md.ChunkSize),
 	})
 }
 
-// StatusHandler returns the list of received chunks
+// StatusHandler returns the list of received chunks and whether the upload
+// can already be completed (directly or through FEC reconstruction)
 func StatusHandler(c *fiber.Ctx) error {
-	uploadID := c.Params("uploadID")
+	uploadID := middleware.Param(c, "uploadID")
 	dir := filepath.Join(config.StorageRoot, uploadID)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return c.Status(fiber.StatusNotFound).SendString("upload not found")
+		return apiError(c, fiber.StatusNotFound, models.CodeUploadNotFound, "Upload not found")
 	}
 	received, _ := helpers.ReadReceivedChunks(dir)
-	return c.JSON(fiber.Map{"received_chunks": received})
+	resp := models.UploadStatus{ReceivedChunks: received}
+
+	var md models.Metadata
+	if mdBytes, err := os.ReadFile(filepath.Join(dir, "metadata.json")); err == nil && json.Unmarshal(mdBytes, &md) == nil {
+		present := make(map[int]bool, len(received))
+		for _, idx := range received {
+			present[idx] = true
+		}
+		missing := 0
+		for i := 0; i < md.TotalChunks; i++ {
+			if !present[i] {
+				missing++
+			}
+		}
+		recoverable := missing == 0
+		if md.FECEnabled() {
+			unrecoverable := helpers.UnrecoverableStripes(md, present)
+			recoverable = len(unrecoverable) == 0
+			resp.UnrecoverableStripes = unrecoverable
+		}
+		resp.Recoverable = recoverable
+	}
+	return c.JSON(resp)
 }
 
 // CompleteHandler assembles chunks into final file and verifies hash
 func CompleteHandler(c *fiber.Ctx) error {
-	uploadID := c.Params("uploadID")
+	return completeUpload(c, false)
+}
+
+// completeUpload assembles an upload. Forced by an operator, chunks that are
+// missing and cannot be rebuilt are written as zeros and a file hash
+// mismatch is reported instead of refused.
+func completeUpload(c *fiber.Ctx, force bool) error {
+	uploadID := middleware.Param(c, "uploadID")
 	dir := filepath.Join(config.StorageRoot, uploadID)
 	metaPath := filepath.Join(dir, "metadata.json")
 	mdBytes, err := os.ReadFile(metaPath)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Metadata not found",
-		})
+		return apiError(c, fiber.StatusNotFound, models.CodeUploadNotFound, "Metadata not found")
 	}
 	var md models.Metadata
 	if err := json.Unmarshal(mdBytes, &md); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid metadata",
-		})
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Invalid metadata")
 	}
 
+	logger := logging.FromContext(c).With("upload_id", uploadID, "share_id", md.ShareID)
+	logging.WithContext(c, logger)
+	ctx := c.UserContext()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("share_id", md.ShareID))
+
 	// Check if already completed
 	outPath := filepath.Join(config.StorageRoot, uploadID, md.Filename)
 	if _, err := os.Stat(outPath); err == nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error": "Upload already completed",
-		})
+		return apiError(c, fiber.StatusConflict, models.CodeUploadCompleted, "Upload already completed")
 	}
 
 	// Verify all chunks are present before merging
@@ -258,6 +385,26 @@ func CompleteHandler(c *fiber.Ctx) error {
 		receivedSet[idx] = true
 	}
 
+	// With FEC, chunks that fail re-verification count as erasures and
+	// missing data chunks are rebuilt from the stripe parity
+	if md.FECEnabled() {
+		_, fecSpan := tracing.Start(ctx, "fec.reconstruct")
+		receivedSet = helpers.VerifiedChunks(dir, md, received)
+		rebuilt, err := helpers.ReconstructChunks(dir, md, receivedSet)
+		fecSpan.SetAttributes(attribute.IntSlice("rebuilt_chunks", rebuilt))
+		if err != nil {
+			tracing.RecordError(fecSpan, err)
+			logger.Warn("fec reconstruction error", "event", "fec", "error", err)
+		}
+		fecSpan.End()
+		if len(rebuilt) > 0 {
+			logger.Info("fec reconstructed chunks", "event", "fec", "chunks", rebuilt)
+		}
+		for _, idx := range rebuilt {
+			receivedSet[idx] = true
+		}
+	}
+
 	missingChunks := []int{}
 	for i := 0; i < md.TotalChunks; i++ {
 		if !receivedSet[i] {
@@ -265,128 +412,230 @@ func CompleteHandler(c *fiber.Ctx) error {
 		}
 	}
 
+	var filledChunks []int
+	if force && len(missingChunks) > 0 {
+		logger.Warn("forced completion fills missing chunks with zeros", "event", "incomplete", "missing_chunks", missingChunks)
+		filledChunks, missingChunks = missingChunks, nil
+	}
 	if len(missingChunks) > 0 {
-		log.Printf("[INCOMPLETE] Upload %s cannot be completed, missing chunks: %v", uploadID, missingChunks)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":         fmt.Sprintf("Missing chunks: %v", missingChunks),
-			"missingChunks": missingChunks,
-			"receivedCount": len(received),
-			"totalChunks":   md.TotalChunks,
-		})
+		logger.Warn("upload cannot be completed", "event", "incomplete", "missing_chunks", missingChunks)
+		apiErr := models.NewAPIError(fiber.StatusBadRequest, models.CodeChunksMissing, fmt.Sprintf("Missing chunks: %v", missingChunks)).
+			With("missing_chunks", missingChunks).
+			With("received_count", len(helpers.DataChunks(received, md.TotalChunks))).
+			With("total_chunks", md.TotalChunks)
+		if md.FECEnabled() {
+			apiErr.With("unrecoverable_stripes", helpers.UnrecoverableStripes(md, receivedSet))
+		}
+		return middleware.SendError(c, apiErr)
+	}
+
+	// Assembly needs room for a full copy of the file next to the chunks
+	if err := services.Capacity.Admit(uploadID, md.ExpectedBytes()); err != nil {
+		return storageError(c, err)
 	}
 
+	_, asmSpan := tracing.Start(ctx, "assembly", trace.WithAttributes(
+		attribute.Int("total_chunks", md.TotalChunks),
+		attribute.Int64("bytes", md.ExpectedBytes()),
+	))
+	assemblyStart := time.Now()
 	outTemp := outPath + ".part"
 	out, err := os.Create(outTemp)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create output file",
-		})
+		tracing.RecordError(asmSpan, err)
+		asmSpan.End()
+		services.Room.NotifyUploadFailed(md.ShareID, uploadID, md.Filename, "assembly_error")
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to create output file")
 	}
 
+	filled := make(map[int]bool, len(filledChunks))
+	for _, idx := range filledChunks {
+		filled[idx] = true
+	}
 	hTotals := xxhash.New()
 	for i := 0; i < md.TotalChunks; i++ {
-		chunkPath := filepath.Join(dir, fmt.Sprintf("chunk_%06d", i))
-		data, err := os.ReadFile(chunkPath)
-		if err != nil {
+		var data []byte
+		if filled[i] {
+			data = make([]byte, helpers.ChunkLength(md, i))
+		} else if data, err = os.ReadFile(filepath.Join(dir, fmt.Sprintf("chunk_%06d", i))); err != nil && force {
+			// Listed in received.json but gone from disk
+			data = make([]byte, helpers.ChunkLength(md, i))
+			filledChunks = append(filledChunks, i)
+		} else if err != nil {
 			out.Close()
 			os.Remove(outTemp)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Missing chunk %d", i),
-			})
+			tracing.RecordError(asmSpan, err)
+			asmSpan.End()
+			return apiError(c, fiber.StatusBadRequest, models.CodeChunksMissing, fmt.Sprintf("Missing chunk %d", i))
 		}
 		_, _ = out.Write(data)
 		_, _ = hTotals.Write(data)
 	}
 	finalHash := hex.EncodeToString(hTotals.Sum(nil))
-	if md.FileHash != "" && md.FileHash != finalHash {
+	hashMismatch := md.FileHash != "" && md.FileHash != finalHash
+	if hashMismatch && force {
+		logger.Warn("forced completion keeps a file that fails its hash", "event", "complete",
+			"expected", md.FileHash, "actual", finalHash)
+	} else if hashMismatch {
 		out.Close()
 		os.Remove(outTemp)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":    "Overall hash mismatch",
-			"expected": md.FileHash,
-			"actual":   finalHash,
-		})
+		asmSpan.SetStatus(codes.Error, "overall hash mismatch")
+		asmSpan.End()
+		services.Room.NotifyUploadFailed(md.ShareID, uploadID, md.Filename, "hash_mismatch")
+		return middleware.SendError(c, models.NewAPIError(fiber.StatusBadRequest, models.CodeFileHashMismatch, "Overall hash mismatch").
+			With("expected", md.FileHash).
+			With("actual", finalHash))
 	}
 	if err := out.Close(); err != nil {
-		log.Println("[WARNING] closing assembled file failed:", err)
+		logger.Warn("closing assembled file failed", "error", err)
+	}
+
+	// A forced file is flagged before it is published, so no listing,
+	// download or room event presents it as what the client sent
+	var forced *models.ForcedCompletion
+	if len(filledChunks) > 0 || hashMismatch {
+		sort.Ints(filledChunks)
+		forced = &models.ForcedCompletion{FilledChunks: filledChunks, ForcedAt: time.Now().UTC()}
+		if hashMismatch {
+			forced.ExpectedHash = md.FileHash
+		}
+		if _, err := services.Sinks.UpdateMetadata(uploadID, func(md *models.Metadata) { md.Forced = forced }); err != nil {
+			os.Remove(outTemp)
+			tracing.RecordError(asmSpan, err)
+			asmSpan.End()
+			return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to record forced completion")
+		}
 	}
 	if err := os.Rename(outTemp, outPath); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Final rename failed: " + err.Error(),
-		})
+		tracing.RecordError(asmSpan, err)
+		asmSpan.End()
+		services.Room.NotifyUploadFailed(md.ShareID, uploadID, md.Filename, "assembly_error")
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Final rename failed: "+err.Error())
 	}
+	asmSpan.SetAttributes(attribute.String("file_hash", finalHash))
+	asmSpan.End()
+	metrics.AssemblyDuration.Observe(time.Since(assemblyStart).Seconds())
+	services.Telemetry.RecordAssembly(uploadID, time.Since(assemblyStart))
 
 	// Cleanup: delete individual chunks and metadata files
-	log.Printf("[CLEANUP] Cleaning up chunks for completed upload %s", uploadID)
-	for i := 0; i < md.TotalChunks; i++ {
+	logger.Debug("cleaning up chunks for completed upload", "event", "cleanup")
+	for i := 0; i < md.TotalUploadChunks(); i++ {
 		chunkPath := filepath.Join(dir, fmt.Sprintf("chunk_%06d", i))
 		os.Remove(chunkPath)
 		os.Remove(chunkPath + ".xxhash")
 	}
 	os.Remove(filepath.Join(dir, "received.json"))
+	services.Capacity.Release(uploadID)
+	services.Advisor.Forget(uploadID)
+	services.Throttle.Forget(uploadID)
 
 	// broadcast completion
+	_, sseSpan := tracing.Start(ctx, "sse.broadcast")
 	services.SSE.BroadcastProgress(uploadID, config.StorageRoot)
+	sseSpan.End()
 
 	// Notify room of upload complete
 	if fileInfo, err := os.Stat(outPath); err == nil {
-		services.Room.NotifyUploadComplete(md.ShareID, uploadID, md.Filename, fileInfo.Size())
+		services.Room.NotifyUploadComplete(md.ShareID, uploadID, md.Filename, fileInfo.Size(), forced)
+	}
+
+	if err := services.Sinks.Enqueue(uploadID); err != nil {
+		logger.Error("failed to queue sink pushes", "event", "sink", "error", err)
 	}
 
-	log.Printf("[COMPLETE] Upload %s assembled successfully: %s", uploadID, md.Filename)
+	logger.Info("upload assembled", "event", "complete", "filename", md.Filename, "file_hash", finalHash)
 
 	downloadURL := fmt.Sprintf("/static/%s/%s", uploadID, md.Filename)
-	return c.JSON(fiber.Map{
-		"status":       "assembled",
-		"file_path":    outPath,
-		"file_hash":    finalHash,
-		"download_url": downloadURL,
-	})
+	result := models.CompleteResult{
+		Status:      "assembled",
+		FilePath:    outPath,
+		FileHash:    finalHash,
+		DownloadURL: downloadURL,
+	}
+	if forced != nil {
+		result.Status = "forced"
+		result.FilledChunks = forced.FilledChunks
+		result.ExpectedHash = forced.ExpectedHash
+	}
+	return c.JSON(result)
 }
 
-// HealthHandler returns health status
+// HealthHandler returns health status and storage capacity
 func HealthHandler(c *fiber.Ctx) error {
-	return c.SendString("OK")
+	usage, err := services.Capacity.Usage()
+	if err != nil {
+		return middleware.SendError(c, models.NewAPIError(fiber.StatusServiceUnavailable, models.CodeInternal, "Failed to read storage usage").
+			With("status", "error"))
+	}
+	status := "ok"
+	if usage.Paused {
+		status = "degraded"
+	}
+	if services.Lifecycle.Draining() {
+		// Load balancers stop routing new sessions here
+		c.Status(fiber.StatusServiceUnavailable)
+		status = "draining"
+	}
+	return c.JSON(fiber.Map{
+		"status":    status,
+		"storage":   usage,
+		"scheduler": services.Scheduler.Stats(),
+	})
 }
 
-// CleanupHandler deletes an incomplete upload session
-func CleanupHandler(c *fiber.Ctx) error {
-	uploadID := c.Params("uploadID")
-	dir := filepath.Join(config.StorageRoot, uploadID)
+// quotaError maps quota violations to 429 (retry later) or 507 (quota full)
+func quotaError(c *fiber.Ctx, qe *services.QuotaError) error {
+	apiErr := models.NewAPIError(fiber.StatusInsufficientStorage, models.CodeQuotaExceeded, "Quota exceeded").
+		With("quota", qe.Quota).
+		With("limit", qe.Limit)
+	if qe.RetryAfter > 0 {
+		middleware.SetRetryAfter(c, qe.RetryAfter)
+		apiErr.Status = fiber.StatusTooManyRequests
+		apiErr.With("retry_after_ms", qe.RetryAfter.Milliseconds())
+	}
+	return middleware.SendError(c, apiErr)
+}
 
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Upload session not found",
-		})
+// storageError maps capacity errors to a 507 response
+func storageError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrStoragePaused):
+		return middleware.SendError(c, models.NewAPIError(fiber.StatusInsufficientStorage, models.CodeStoragePaused, "Storage low, new uploads are paused").
+			With("paused", true))
+	case errors.Is(err, services.ErrInsufficientStorage):
+		return apiError(c, fiber.StatusInsufficientStorage, models.CodeInsufficientStorage, "Insufficient storage")
+	case errors.Is(err, services.ErrInvalidSize):
+		return apiError(c, fiber.StatusBadRequest, models.CodeInvalidRequest, "Invalid upload size")
+	default:
+		logging.FromContext(c).Error("storage reservation failed", "error", err)
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to reserve storage")
 	}
+}
 
-	// Read metadata to check if merged file exists
-	metaPath := filepath.Join(dir, "metadata.json")
-	mdBytes, err := os.ReadFile(metaPath)
-	if err == nil {
-		var md models.Metadata
-		if json.Unmarshal(mdBytes, &md) == nil {
-			mergedPath := filepath.Join(dir, md.Filename)
-			if _, err := os.Stat(mergedPath); err == nil {
-				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-					"error": "Cannot delete completed upload",
-				})
-			}
+// CleanupHandler deletes an incomplete upload session
+func CleanupHandler(c *fiber.Ctx) error {
+	uploadID := middleware.Param(c, "uploadID")
+	logger := logging.FromContext(c).With("upload_id", uploadID)
+	logging.WithContext(c, logger)
+
+	if _, err := services.AbortUpload(uploadID, "cancelled"); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUploadNotFound):
+			return apiError(c, fiber.StatusNotFound, models.CodeUploadNotFound, "Upload session not found")
+		case errors.Is(err, services.ErrUploadCompleted):
+			return apiError(c, fiber.StatusForbidden, models.CodeUploadCompleted, "Cannot delete completed upload")
 		}
+		logger.Error("failed to delete upload session", "event", "cleanup", "error", err)
+		return apiError(c, fiber.StatusInternalServerError, models.CodeInternal, "Failed to delete upload session")
 	}
+	logger.Info("upload session deleted", "event", "cleanup")
 
-	// Delete the entire directory
-	if err := os.RemoveAll(dir); err != nil {
-		log.Printf("[CLEANUP] Failed to delete upload %s: %v", uploadID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete upload session",
-		})
+	resp := fiber.Map{
+		"message":   "Upload session deleted",
+		"upload_id": uploadID,
 	}
-
-	log.Printf("[CLEANUP] Deleted upload session %s", uploadID)
-
-	return c.JSON(fiber.Map{
-		"message":  "Upload session deleted",
-		"uploadID": uploadID,
-	})
+	if !middleware.Versioned(c) {
+		resp["uploadID"] = uploadID // as older clients read it
+	}
+	return c.JSON(resp)
 }
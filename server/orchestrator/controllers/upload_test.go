@@ -0,0 +1,72 @@
+package controllers_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"aetherlink/config"
+	"aetherlink/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUnversionedRoutesKeepLegacyKeys(t *testing.T) {
+	app := newApp()
+	startUpload(t, app, "legacy-keys", "legacy-share", testChunks, 0)
+
+	var incomplete map[string]interface{}
+	if code := call(t, app, fiber.MethodPost, "/complete/legacy-keys", nil, &incomplete); code != fiber.StatusBadRequest {
+		t.Fatalf("complete: status %d", code)
+	}
+	for _, key := range []string{"missing_chunks", "missingChunks"} {
+		if got := fmt.Sprint(incomplete[key]); got != "[1 2]" {
+			t.Errorf("%s = %s, want [1 2]", key, got)
+		}
+	}
+	for key, want := range map[string]float64{"received_count": 1, "receivedCount": 1, "total_chunks": 3, "totalChunks": 3} {
+		if incomplete[key] != want {
+			t.Errorf("%s = %v, want %v", key, incomplete[key], want)
+		}
+	}
+	if _, ok := incomplete["error"].(string); !ok {
+		t.Errorf("error = %v, want the message", incomplete["error"])
+	}
+
+	var envelope struct {
+		Error struct{ Details map[string]interface{} }
+	}
+	call(t, app, fiber.MethodPost, "/v1/complete/legacy-keys", nil, &envelope)
+	if _, ok := envelope.Error.Details["missingChunks"]; ok {
+		t.Error("/v1 error details carry the legacy key")
+	}
+
+	var deleted map[string]interface{}
+	if code := call(t, app, fiber.MethodDelete, "/cleanup/legacy-keys", nil, &deleted); code != fiber.StatusOK {
+		t.Fatalf("cleanup: status %d", code)
+	}
+	if deleted["uploadID"] != "legacy-keys" || deleted["upload_id"] != "legacy-keys" {
+		t.Errorf("cleanup answer = %v", deleted)
+	}
+}
+
+func TestInitRejectsUnsafeFilenames(t *testing.T) {
+	app := newApp()
+	for i, name := range []string{"", ".", "..", "../../escape.txt", "sub/dir.txt", `..\escape.txt`, "/etc/passwd", "metadata.json", "received.json", "chunk_000000"} {
+		uploadID := fmt.Sprintf("unsafe-name-%d", i)
+		md := models.Metadata{UploadID: uploadID, Filename: name, TotalChunks: 1, ChunkSize: 6, FileSize: 6}
+		var apiErr models.ErrorEnvelope
+		if code := call(t, app, fiber.MethodPost, "/v1/init", md, &apiErr); code != fiber.StatusBadRequest || apiErr.Error.Code != models.CodeInvalidRequest {
+			t.Errorf("filename %q: status %d %+v, want 400 invalid_request", name, code, apiErr)
+		}
+		if _, err := os.Stat(filepath.Join(config.StorageRoot, uploadID)); !os.IsNotExist(err) {
+			t.Errorf("filename %q: session directory created: %v", name, err)
+		}
+	}
+
+	md := models.Metadata{UploadID: "safe-name", Filename: "report (final) v2.tar.gz", TotalChunks: 1, ChunkSize: 6, FileSize: 6}
+	if code := call(t, app, fiber.MethodPost, "/v1/init", md, nil); code != fiber.StatusCreated {
+		t.Errorf("plain filename: status %d", code)
+	}
+}
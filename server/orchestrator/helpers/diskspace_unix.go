@@ -0,0 +1,16 @@
+//go:build !windows
+
+package helpers
+
+import "syscall"
+
+// DiskSpace returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path
+func DiskSpace(path string) (free uint64, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(stat.Bsize)
+	return uint64(stat.Bavail) * bsize, uint64(stat.Blocks) * bsize, nil
+}
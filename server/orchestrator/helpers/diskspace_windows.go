@@ -0,0 +1,19 @@
+//go:build windows
+
+package helpers
+
+import "golang.org/x/sys/windows"
+
+// DiskSpace returns the bytes available to the caller and the total size of
+// the volume holding path
+func DiskSpace(path string) (free uint64, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree); err != nil {
+		return 0, 0, err
+	}
+	return free, total, nil
+}
@@ -0,0 +1,306 @@
+package helpers
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+
+	"aetherlink/models"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/klauspost/reedsolomon"
+)
+
+// MaxFECShards is the largest data+parity stripe width supported by the codec
+const MaxFECShards = 256
+
+// ChunkPath returns the on-disk path of a data or parity chunk
+func ChunkPath(dir string, idx int) string {
+	return filepath.Join(dir, fmt.Sprintf("chunk_%06d", idx))
+}
+
+// HashChunk returns the hex xxhash of a chunk body
+func HashChunk(data []byte) string {
+	h := xxhash.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashChunkFile returns the hex xxhash of a chunk file's content
+func HashChunkFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := xxhash.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// MaxTotalChunks bounds the chunk count of an upload
+const MaxTotalChunks = 1 << 31
+
+// ValidateLayout checks the declared chunk layout of an upload. The sizes
+// come from the client and decide how much disk /init reserves, so they
+// must be positive and their product must not overflow.
+func ValidateLayout(md models.Metadata) error {
+	if md.TotalChunks <= 0 || md.TotalChunks > MaxTotalChunks {
+		return fmt.Errorf("total_chunks must be between 1 and %d", MaxTotalChunks)
+	}
+	if md.ChunkSize <= 0 {
+		return errors.New("chunk_size must be positive")
+	}
+	if md.FileSize < 0 {
+		return errors.New("file_size must not be negative")
+	}
+	if chunks := int64(md.TotalUploadChunks()); chunks > math.MaxInt64/md.ChunkSize {
+		return errors.New("total_chunks * chunk_size is too large")
+	}
+	if md.FileSize > int64(md.TotalChunks)*md.ChunkSize {
+		return errors.New("file_size exceeds total_chunks * chunk_size")
+	}
+	return nil
+}
+
+// ValidateFEC checks that the FEC layout in the metadata is usable
+func ValidateFEC(md models.Metadata) error {
+	if md.DataShards == 0 && md.ParityShards == 0 {
+		return nil
+	}
+	if md.DataShards <= 0 || md.ParityShards <= 0 {
+		return errors.New("data_shards and parity_shards must both be positive")
+	}
+	if md.DataShards+md.ParityShards > MaxFECShards {
+		return fmt.Errorf("data_shards + parity_shards must not exceed %d", MaxFECShards)
+	}
+	if md.TotalChunks <= 0 || md.ChunkSize <= 0 {
+		return errors.New("total_chunks and chunk_size are required for FEC")
+	}
+	// file_size is needed to trim a reconstructed final chunk
+	minSize := int64(md.TotalChunks-1)*md.ChunkSize + 1
+	maxSize := int64(md.TotalChunks) * md.ChunkSize
+	if md.FileSize < minSize || md.FileSize > maxSize {
+		return fmt.Errorf("file_size must be between %d and %d for FEC", minSize, maxSize)
+	}
+	if len(md.ParityHashes) != 0 && len(md.ParityHashes) != md.ParityChunks() {
+		return fmt.Errorf("parity_hashes must have %d entries", md.ParityChunks())
+	}
+	return nil
+}
+
+// ExpectedChunkHash returns the client-declared hash for a data or parity chunk,
+// or "" if none was provided
+func ExpectedChunkHash(md models.Metadata, idx int) string {
+	if idx < md.TotalChunks {
+		if len(md.ChunkHashes) == md.TotalChunks {
+			return md.ChunkHashes[idx]
+		}
+		return ""
+	}
+	p := idx - md.TotalChunks
+	if len(md.ParityHashes) == md.ParityChunks() && p < len(md.ParityHashes) {
+		return md.ParityHashes[p]
+	}
+	return ""
+}
+
+// DataChunks filters a received list down to data chunk indices
+func DataChunks(received []int, totalChunks int) []int {
+	data := make([]int, 0, len(received))
+	for _, idx := range received {
+		if idx < totalChunks {
+			data = append(data, idx)
+		}
+	}
+	return data
+}
+
+// StripeChunks returns the data and parity chunk indices of a stripe.
+// Padding chunks past the end of the file are not included.
+func StripeChunks(md models.Metadata, stripe int) (data []int, parity []int) {
+	for i := 0; i < md.DataShards; i++ {
+		idx := stripe*md.DataShards + i
+		if idx < md.TotalChunks {
+			data = append(data, idx)
+		}
+	}
+	for j := 0; j < md.ParityShards; j++ {
+		parity = append(parity, md.TotalChunks+stripe*md.ParityShards+j)
+	}
+	return data, parity
+}
+
+// UnrecoverableStripes returns the stripes that are missing more chunks than
+// their parity can rebuild
+func UnrecoverableStripes(md models.Metadata, present map[int]bool) []int {
+	stripes := []int{}
+	for s := 0; s < md.Stripes(); s++ {
+		data, parity := StripeChunks(md, s)
+		missingData, missing := 0, 0
+		for _, idx := range data {
+			if !present[idx] {
+				missingData++
+				missing++
+			}
+		}
+		for _, idx := range parity {
+			if !present[idx] {
+				missing++
+			}
+		}
+		if missingData > 0 && missing > md.ParityShards {
+			stripes = append(stripes, s)
+		}
+	}
+	return stripes
+}
+
+// VerifiedChunks re-hashes received chunks and returns those whose content
+// still matches the expected hash (client-declared, or the .xxhash sidecar)
+func VerifiedChunks(dir string, md models.Metadata, received []int) map[int]bool {
+	present := make(map[int]bool, len(received))
+	for _, idx := range received {
+		chunkPath := ChunkPath(dir, idx)
+		data, err := os.ReadFile(chunkPath)
+		if err != nil {
+			continue
+		}
+		expected := ExpectedChunkHash(md, idx)
+		if expected == "" {
+			if b, err := os.ReadFile(chunkPath + ".xxhash"); err == nil {
+				expected = string(b)
+			}
+		}
+		if actual := HashChunk(data); expected != "" && expected != actual {
+			slog.Warn("stored chunk failed hash verification", "event", "hash_failed",
+				"upload_id", md.UploadID, "share_id", md.ShareID, "chunk", idx,
+				"expected", expected, "actual", actual)
+			continue
+		}
+		present[idx] = true
+	}
+	return present
+}
+
+// ReconstructChunks rebuilds missing data chunks from parity, writes them to
+// dir and records them as received. It returns the rebuilt indices; stripes
+// that cannot be repaired are skipped.
+func ReconstructChunks(dir string, md models.Metadata, present map[int]bool) ([]int, error) {
+	if !md.FECEnabled() {
+		return nil, nil
+	}
+	enc, err := reedsolomon.New(md.DataShards, md.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	unrecoverable := make(map[int]bool)
+	for _, s := range UnrecoverableStripes(md, present) {
+		unrecoverable[s] = true
+	}
+
+	rebuilt := []int{}
+	var firstErr error
+	for s := 0; s < md.Stripes(); s++ {
+		if unrecoverable[s] {
+			continue
+		}
+		idxs, err := reconstructStripe(enc, dir, md, s, present)
+		rebuilt = append(rebuilt, idxs...)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("stripe %d: %w", s, err)
+		}
+	}
+	return rebuilt, firstErr
+}
+
+func reconstructStripe(enc reedsolomon.Encoder, dir string, md models.Metadata, stripe int, present map[int]bool) ([]int, error) {
+	shardSize := int(md.ChunkSize)
+	shards := make([][]byte, md.DataShards+md.ParityShards)
+	missing := []int{}
+
+	for i := 0; i < md.DataShards; i++ {
+		idx := stripe*md.DataShards + i
+		if idx >= md.TotalChunks {
+			// Padding shard of a short final stripe
+			shards[i] = make([]byte, shardSize)
+			continue
+		}
+		if !present[idx] {
+			missing = append(missing, i)
+			continue
+		}
+		data, err := os.ReadFile(ChunkPath(dir, idx))
+		if err != nil || len(data) > shardSize {
+			missing = append(missing, i)
+			continue
+		}
+		shards[i] = padShard(data, shardSize)
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	for j := 0; j < md.ParityShards; j++ {
+		idx := md.TotalChunks + stripe*md.ParityShards + j
+		if !present[idx] {
+			continue
+		}
+		data, err := os.ReadFile(ChunkPath(dir, idx))
+		if err != nil || len(data) != shardSize {
+			continue
+		}
+		shards[md.DataShards+j] = data
+	}
+
+	if err := enc.ReconstructData(shards); err != nil {
+		return nil, err
+	}
+
+	rebuilt := []int{}
+	for _, i := range missing {
+		idx := stripe*md.DataShards + i
+		data := shards[i][:ChunkLength(md, idx)]
+		actual := HashChunk(data)
+		if expected := ExpectedChunkHash(md, idx); expected != "" && expected != actual {
+			return rebuilt, fmt.Errorf("rebuilt chunk %d hash mismatch", idx)
+		}
+		chunkPath := ChunkPath(dir, idx)
+		if err := os.WriteFile(chunkPath+".part", data, 0644); err != nil {
+			return rebuilt, err
+		}
+		if err := MoveFile(chunkPath+".part", chunkPath); err != nil {
+			return rebuilt, err
+		}
+		_ = os.WriteFile(chunkPath+".xxhash", []byte(actual), 0644)
+		if err := AppendReceivedChunk(dir, idx); err != nil {
+			return rebuilt, err
+		}
+		rebuilt = append(rebuilt, idx)
+	}
+	return rebuilt, nil
+}
+
+// ChunkLength returns the unpadded size of a data chunk; the last one is
+// assumed full when the file size is unknown
+func ChunkLength(md models.Metadata, idx int) int {
+	if idx == md.TotalChunks-1 && md.FileSize > 0 {
+		return int(md.FileSize - int64(md.TotalChunks-1)*md.ChunkSize)
+	}
+	return int(md.ChunkSize)
+}
+
+func padShard(data []byte, size int) []byte {
+	if len(data) == size {
+		return data
+	}
+	padded := make([]byte, size)
+	copy(padded, data)
+	return padded
+}
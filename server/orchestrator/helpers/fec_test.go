@@ -0,0 +1,183 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"aetherlink/models"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// fecUpload splits content into chunks and computes the parity of each
+// stripe the way clients do, zero-padding the last chunk and a short final
+// stripe
+func fecUpload(t *testing.T, content string, chunkSize, dataShards, parityShards int) (models.Metadata, [][]byte) {
+	t.Helper()
+	md := models.Metadata{
+		UploadID:     "fec",
+		TotalChunks:  (len(content) + chunkSize - 1) / chunkSize,
+		ChunkSize:    int64(chunkSize),
+		FileSize:     int64(len(content)),
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+	}
+	var chunks [][]byte
+	for off := 0; off < len(content); off += chunkSize {
+		chunks = append(chunks, []byte(content[off:min(off+chunkSize, len(content))]))
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parity [][]byte
+	for s := 0; s < md.Stripes(); s++ {
+		shards := make([][]byte, dataShards+parityShards)
+		for i := range shards {
+			shards[i] = make([]byte, chunkSize)
+			if idx := s*dataShards + i; i < dataShards && idx < len(chunks) {
+				copy(shards[i], chunks[idx])
+			}
+		}
+		if err := enc.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+		parity = append(parity, shards[dataShards:]...)
+	}
+	for _, c := range append(chunks, parity...) {
+		if len(md.ChunkHashes) < md.TotalChunks {
+			md.ChunkHashes = append(md.ChunkHashes, HashChunk(c))
+		} else {
+			md.ParityHashes = append(md.ParityHashes, HashChunk(c))
+		}
+	}
+	return md, append(chunks, parity...)
+}
+
+// 22 bytes in chunks of 4: six data chunks, the last one 2 bytes long, in
+// a full stripe 0 (chunks 0-3, parity 6-7) and a short stripe 1 (chunks
+// 4-5, parity 8-9)
+const fecContent = "reed-solomon repairs!!"
+
+func TestStripeLayout(t *testing.T) {
+	md, _ := fecUpload(t, fecContent, 4, 4, 2)
+	if md.TotalChunks != 6 || md.Stripes() != 2 {
+		t.Fatalf("%d chunks in %d stripes, want 6 in 2", md.TotalChunks, md.Stripes())
+	}
+	for stripe, want := range []string{"[0 1 2 3] [6 7]", "[4 5] [8 9]"} {
+		data, parity := StripeChunks(md, stripe)
+		if got := fmt.Sprint(data, " ", parity); got != want {
+			t.Errorf("stripe %d = %s, want %s", stripe, got, want)
+		}
+	}
+	for idx, want := range map[int]int{0: 4, 4: 4, 5: 2} {
+		if got := ChunkLength(md, idx); got != want {
+			t.Errorf("chunk %d length %d, want %d", idx, got, want)
+		}
+	}
+	md.FileSize = 0
+	if got := ChunkLength(md, 5); got != 4 {
+		t.Errorf("last chunk of unknown file size is %d long, want 4", got)
+	}
+}
+
+func TestUnrecoverableStripes(t *testing.T) {
+	md, _ := fecUpload(t, fecContent, 4, 4, 2)
+	present := func(missing ...int) map[int]bool {
+		p := make(map[int]bool)
+		for i := 0; i < md.TotalUploadChunks(); i++ {
+			p[i] = true
+		}
+		for _, idx := range missing {
+			delete(p, idx)
+		}
+		return p
+	}
+	for _, tc := range []struct {
+		missing []int
+		want    string
+	}{
+		{nil, "[]"},
+		{[]int{1, 2}, "[]"},        // as many erasures as parity
+		{[]int{6, 7, 8}, "[]"},     // only parity lost
+		{[]int{0, 1, 7}, "[0]"},    // one erasure too many
+		{[]int{4, 8, 9}, "[1]"},    // short stripe, parity gone
+		{[]int{0, 1, 2, 5}, "[0]"}, // stripe 1 can still be rebuilt
+		{[]int{3, 6, 7, 4, 5, 8}, "[0 1]"},
+	} {
+		if got := fmt.Sprint(UnrecoverableStripes(md, present(tc.missing...))); got != tc.want {
+			t.Errorf("missing %v: unrecoverable %s, want %s", tc.missing, got, tc.want)
+		}
+	}
+}
+
+func TestReconstructChunks(t *testing.T) {
+	md, chunks := fecUpload(t, fecContent, 4, 4, 2)
+	dir := t.TempDir()
+	present := make(map[int]bool)
+	for idx, c := range chunks {
+		// Chunk 1 is lost, chunk 2 was corrupted and failed verification,
+		// and the short last chunk of the final stripe is lost
+		if idx == 1 || idx == 2 || idx == 5 {
+			continue
+		}
+		if err := os.WriteFile(ChunkPath(dir, idx), c, 0644); err != nil {
+			t.Fatal(err)
+		}
+		present[idx] = true
+	}
+	if err := os.WriteFile(ChunkPath(dir, 2), []byte("xxxx"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt, err := ReconstructChunks(dir, md, present)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(rebuilt) != "[1 2 5]" {
+		t.Fatalf("rebuilt %v, want [1 2 5]", rebuilt)
+	}
+	for _, idx := range rebuilt {
+		data, err := os.ReadFile(ChunkPath(dir, idx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The last chunk comes back without the stripe padding
+		if string(data) != string(chunks[idx]) {
+			t.Errorf("chunk %d rebuilt as %q, want %q", idx, data, chunks[idx])
+		}
+		if sidecar, _ := os.ReadFile(ChunkPath(dir, idx) + ".xxhash"); string(sidecar) != md.ChunkHashes[idx] {
+			t.Errorf("chunk %d sidecar %q, want %q", idx, sidecar, md.ChunkHashes[idx])
+		}
+	}
+	if received, _ := ReadReceivedChunks(dir); fmt.Sprint(received) != "[1 2 5]" {
+		t.Errorf("received.json lists %v, want the rebuilt chunks", received)
+	}
+}
+
+func TestReconstructSkipsUnrecoverableStripes(t *testing.T) {
+	md, chunks := fecUpload(t, fecContent, 4, 4, 2)
+	dir := t.TempDir()
+	present := make(map[int]bool)
+	for idx, c := range chunks {
+		// Stripe 0 lost three of its chunks, stripe 1 only its last one
+		if idx == 0 || idx == 1 || idx == 6 || idx == 5 {
+			continue
+		}
+		if err := os.WriteFile(ChunkPath(dir, idx), c, 0644); err != nil {
+			t.Fatal(err)
+		}
+		present[idx] = true
+	}
+	rebuilt, err := ReconstructChunks(dir, md, present)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(rebuilt) != "[5]" {
+		t.Errorf("rebuilt %v, want [5]", rebuilt)
+	}
+	if _, err := os.Stat(ChunkPath(dir, 0)); !os.IsNotExist(err) {
+		t.Errorf("chunk of an unrecoverable stripe was written: %v", err)
+	}
+}
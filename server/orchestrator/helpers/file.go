@@ -6,8 +6,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// MaxIDLength bounds client-chosen upload and share IDs
+const MaxIDLength = 128
+
 // GenerateShareID generates a unique share ID for file access control
 func GenerateShareID() string {
 	b := make([]byte, 16) // 16 bytes = 32 hex characters
@@ -15,6 +19,38 @@ func GenerateShareID() string {
 	return hex.EncodeToString(b)
 }
 
+// ValidID reports whether a client-chosen upload or share ID is safe to use
+// as one path component: letters, digits, '.', '-' and '_' only, at most
+// MaxIDLength of them, and no ".."
+func ValidID(id string) bool {
+	if id == "" || len(id) > MaxIDLength || strings.Contains(id, "..") {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidFilename reports whether a client-declared filename can be stored
+// in its upload's directory: a single path component that is not one of the
+// files the server keeps there
+func ValidFilename(name string) bool {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name ||
+		strings.ContainsAny(name, "/\\\x00") {
+		return false
+	}
+	switch {
+	case name == "metadata.json", name == "received.json", strings.HasPrefix(name, "chunk_"):
+		return false
+	}
+	return true
+}
+
 // MoveFile moves a file from src to dst, with fallback to copy+delete
 func MoveFile(src, dst string) error {
 	if err := os.Rename(src, dst); err == nil {
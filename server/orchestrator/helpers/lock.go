@@ -0,0 +1,10 @@
+package helpers
+
+import "errors"
+
+// LockFileName is the file under a storage root that the server holding the
+// root keeps locked
+const LockFileName = ".aetherlink.lock"
+
+// ErrLocked reports that another process holds the lock
+var ErrLocked = errors.New("locked by another process")
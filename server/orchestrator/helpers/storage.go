@@ -77,3 +77,31 @@ func ReadReceivedChunks(dir string) ([]int, error) {
 	}
 	return arr, nil
 }
+
+// WriteReceivedChunks replaces the received list (thread-safe)
+func WriteReceivedChunks(dir string, received []int) error {
+	lock := getReceivedLock(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	sorted := append([]int{}, received...)
+	sort.Ints(sorted)
+	return WriteJSONAtomic(filepath.Join(dir, "received.json"), sorted)
+}
+
+// WriteJSONAtomic writes v as JSON to path via a temp file and rename, so
+// readers never see a partially written file
+func WriteJSONAtomic(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
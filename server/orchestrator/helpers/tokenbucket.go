@@ -0,0 +1,74 @@
+package helpers
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a thread-safe token bucket refilled at a fixed rate
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket
+func NewTokenBucket(rate float64, burst float64) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Take removes n tokens if they are available. Otherwise it takes nothing and
+// returns how long the caller should wait before trying again.
+func (b *TokenBucket) Take(n float64) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(time.Now())
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := (n - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// Reserve takes n tokens unconditionally, letting the balance go negative,
+// and returns how long the caller must wait before using them. It suits
+// byte budgets where a single chunk may exceed the burst.
+func (b *TokenBucket) Reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 || b.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// SetRate changes the refill rate and burst, keeping the current balance
+func (b *TokenBucket) SetRate(rate float64, burst float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(time.Now())
+	b.rate, b.burst = rate, burst
+	b.tokens = math.Min(b.tokens, burst)
+}
+
+// LastUsed returns when the bucket was last refilled
+func (b *TokenBucket) LastUsed() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
+
+func (b *TokenBucket) refillLocked(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Logging is configured from LOG_LEVEL (debug, info, warn, error) and
+// LOG_FORMAT (json or text)
+const (
+	EnvLogLevel  = "LOG_LEVEL"
+	EnvLogFormat = "LOG_FORMAT"
+
+	// localsKey holds the per-request logger in fiber.Ctx locals
+	localsKey = "logger"
+)
+
+// Setup installs the process-wide slog logger from the environment and
+// routes the standard log package through it
+func Setup() error {
+	return SetupWith(os.Stdout, os.Getenv(EnvLogLevel), os.Getenv(EnvLogFormat))
+}
+
+// SetupWith installs a slog logger writing to w with the given level and format
+func SetupWith(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid %s %q", EnvLogLevel, level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid %s %q (want json or text)", EnvLogFormat, format)
+	}
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	return nil
+}
+
+// WithContext stores a request-scoped logger in the fiber context
+func WithContext(c *fiber.Ctx, logger *slog.Logger) {
+	c.Locals(localsKey, logger)
+}
+
+// FromContext returns the request-scoped logger, carrying the request ID
+// and client address, or the default logger outside a request
+func FromContext(c *fiber.Ctx) *slog.Logger {
+	if logger, ok := c.Locals(localsKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -1,41 +1,212 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"aetherlink/bus"
 	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/logging"
+	"aetherlink/metrics"
 	"aetherlink/middleware"
 	"aetherlink/routes"
+	"aetherlink/services"
+	"aetherlink/sinks"
+	"aetherlink/tracing"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Load .env first so its LOG_LEVEL and LOG_FORMAT configure the logger
+	var envErr error
 	if os.Getenv("CLOUDINARY_CLOUD_NAME") == "" {
-		err := godotenv.Load()
-		if err != nil {
-			log.Println("Error loading .env file")
+		envErr = godotenv.Load()
+	}
+	if err := logging.Setup(); err != nil {
+		log.Fatal(err)
+	}
+	if envErr != nil {
+		slog.Warn("error loading .env file", "error", envErr)
+	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		case "fsck":
+			os.Exit(fsckCommand(os.Args[2:]))
 		}
 	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Apply()
 	if err := os.MkdirAll(config.StorageRoot, 0755); err != nil {
 		log.Fatal(err)
 	}
+	// Clear up after an unclean stop before anything reads the uploads, unless
+	// another server is writing to the same storage root: its .part and .tmp
+	// files are not orphans
+	releaseStorage, err := helpers.LockDir(config.StorageRoot)
+	switch {
+	case err == nil:
+		defer releaseStorage()
+		recovery, err := services.ScanStorage(config.StorageRoot, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logRecovery(recovery)
+	case errors.Is(err, helpers.ErrLocked):
+		slog.Warn("storage root is in use by another process, skipping startup repair",
+			"event", "recovery", "root", config.StorageRoot)
+	default:
+		log.Fatal(err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	services.Capacity.SetRoot(cfg.Storage.Root)
+	services.Capacity.SetLowWatermark(cfg.Storage.LowWatermarkBytes)
+	if err := services.Capacity.Restore(); err != nil {
+		slog.Error("failed to restore storage reservations", "error", err)
+	}
+	services.Capacity.StartReaper(config.CapacityReapInterval)
+	metrics.RegisterStorage(services.Capacity.Usage)
+	services.Room.SetExpiry(time.Duration(cfg.Rooms.Expiry))
+	services.Room.StartExpiryWatcher(config.RoomExpiryInterval)
+	services.EventLog.StartPruner(config.EventPruneInterval, services.UploadFinished)
+
+	if err := services.Webhooks.Load(); err != nil {
+		slog.Error("failed to load webhooks", "error", err)
+	}
+	services.Webhooks.Start()
+
+	cloudinaryOptions := sinks.CloudinaryOptions{
+		Folder:       cfg.Sinks.Cloudinary.Folder,
+		ChunkSize:    cfg.Sinks.Cloudinary.ChunkSize,
+		UploadPrefix: cfg.Sinks.Cloudinary.UploadPrefix,
+	}
+	if cfg.Sinks.Cloudinary.Enabled() {
+		if err := config.InitCloudinary(cfg.Sinks.Cloudinary); err != nil {
+			log.Fatal(err)
+		}
+		services.Sinks.Register(sinks.NewCloudinary(config.Cloudinary, cloudinaryOptions))
+	}
+	services.Sinks.RegisterFactory("cloudinary", sinks.CloudinaryFactory(cloudinaryOptions))
+	if cfg.Sinks.Dir != "" {
+		services.Sinks.Register(sinks.NewDir(cfg.Sinks.Dir))
+	}
+	vaultKey, err := cfg.Vault.DecodeKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if vaultKey != nil {
+		if err := services.Vault.Open(vaultKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := services.Sinks.SetDefault(cfg.Sinks.Default); err != nil {
+		log.Fatal(err)
+	}
+	if err := services.Sinks.Load(); err != nil {
+		slog.Error("failed to load sink state", "error", err)
+	}
+	services.Sinks.Start()
+
+	clusterSettings, err := cfg.Cluster.Settings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if clusterSettings != nil {
+		services.InitCluster(*clusterSettings)
+		slog.Info("cluster mode enabled", "node", clusterSettings.NodeID, "nodes", len(clusterSettings.Nodes))
+	}
+
+	if busSettings := cfg.Bus.Settings(cfg.Cluster.NodeID); busSettings != nil {
+		eventBus, err := bus.NewNATS(busSettings.URL, busSettings.Subject, "aetherlink-"+busSettings.NodeID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := services.EventLog.Attach(eventBus, busSettings.NodeID); err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("event bus enabled", "node", busSettings.NodeID, "url", busSettings.URL)
+	}
 
 	app := fiber.New(fiber.Config{
-		BodyLimit: config.MaxUploadSize,
+		BodyLimit:    cfg.Limits.MaxUploadSize,
+		ErrorHandler: middleware.ErrorHandler,
 	})
 
-	app.Use(middleware.SetupCORS())
+	quotaSettings := cfg.Limits.QuotaSettings()
+	services.Quota.Configure(quotaSettings)
+
+	services.Scheduler.Configure(cfg.Scheduler.Settings())
+	if err := services.Throttle.SetLimits(cfg.Throttle.Limits()); err != nil {
+		log.Fatal(err)
+	}
+
+	app.Use(middleware.ClusterPeer())
+	app.Use(middleware.RequestLogger())
+	app.Use(middleware.SetupCORS(cfg.CORS))
+	app.Use(middleware.RateLimit(quotaSettings.RateLimitRPS, quotaSettings.RateLimitBurst))
 
 	routes.SetupRoutes(app)
 
-	log.Printf("Server listening on %s\n", config.ServerPort)
-	log.Fatal(app.Listen(config.ServerPort))
-}
+	addr := cfg.Server.Addr
+	tlsSettings, err := cfg.TLS.Settings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Serve until the listener fails or SIGTERM/SIGINT asks for a drain
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+	served := make(chan error, 1)
+	if tlsSettings == nil {
+		slog.Info("server listening", "addr", addr)
+		go func() { served <- app.Listen(addr) }()
+	} else {
+		reloader, err := config.NewCertReloader(*tlsSettings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reloader.Watch(config.TLSReloadInterval)
+		defer reloader.Stop()
 
-func init() {
-	fmt.Println("In")
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("server listening", "addr", addr, "tls", "1.3", "client_auth", tlsSettings.ClientAuth.String())
+		go func() { served <- app.Listener(tls.NewListener(ln, reloader.TLSConfig())) }()
+	}
+
+	select {
+	case err := <-served:
+		log.Fatal(err)
+	case <-signals.Done():
+		stopSignals() // a second signal kills the process
+	}
+	shutdown(app, time.Duration(cfg.Server.ShutdownTimeout))
 }
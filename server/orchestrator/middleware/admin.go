@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"aetherlink/config"
+	"aetherlink/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminAuth protects admin routes with the bearer token in ADMIN_TOKEN.
+// Without a configured token the admin API is disabled.
+func AdminAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := config.AdminToken()
+		if token == "" {
+			return SendError(c, models.NewAPIError(fiber.StatusForbidden, models.CodeAdminDisabled, "Admin API disabled"))
+		}
+		given, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			return SendError(c, models.NewAPIError(fiber.StatusUnauthorized, models.CodeUnauthorized, "Invalid admin token"))
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"time"
+
+	"aetherlink/logging"
+	"aetherlink/models"
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	// HeaderNode reports which node handled an upload request
+	HeaderNode = "X-Aetherlink-Node"
+
+	forwardTimeout = 2 * time.Minute
+
+	localForwardedBy  = "forwarded_by"
+	localForwardedFor = "forwarded_for"
+)
+
+// ClusterPeer accepts the forwarding headers only from cluster peers; on
+// any other request they are removed, so a client cannot pose as a peer to
+// skip forwarding or to borrow another client's address. It runs before
+// every other middleware.
+func ClusterPeer() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		node := c.Get(services.HeaderForwardedBy)
+		client := c.Get(services.HeaderForwardedFor)
+		if node == "" && client == "" {
+			return c.Next()
+		}
+		cluster := services.Cluster
+		if node != "" && cluster != nil && cluster.VerifyForward(node, c.Method(), c.OriginalURL(), client, c.Get(services.HeaderForwardSignature), c.IP()) {
+			// Header values alias the request buffer, which is reused once
+			// the request is done
+			c.Locals(localForwardedBy, utils.CopyString(node))
+			if client != "" {
+				c.Locals(localForwardedFor, utils.CopyString(client))
+			}
+		} else {
+			c.Request().Header.Del(services.HeaderForwardedBy)
+			c.Request().Header.Del(services.HeaderForwardedFor)
+			c.Request().Header.Del(services.HeaderForwardSignature)
+		}
+		return c.Next()
+	}
+}
+
+// ForwardedBy returns the peer that forwarded the request, or "" when it
+// came from a client
+func ForwardedBy(c *fiber.Ctx) string {
+	node, _ := c.Locals(localForwardedBy).(string)
+	return node
+}
+
+// ClientIP returns the address of the client behind the request. For a
+// request forwarded by a peer that is the address the peer received it from,
+// so per-client limits do not lump every forwarded client together.
+func ClientIP(c *fiber.Ctx) string {
+	if client, ok := c.Locals(localForwardedFor).(string); ok {
+		return client
+	}
+	return c.IP()
+}
+
+// ClusterForward routes upload session requests to the node that owns the
+// session, so chunks arriving through any node end up in one received set.
+// It is a no-op outside cluster mode.
+func ClusterForward() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cluster := services.Cluster
+		if cluster == nil {
+			return c.Next()
+		}
+
+		uploadID := c.Params("uploadID")
+		if uploadID == "" {
+			// /init carries the upload ID in the body
+			var md models.Metadata
+			if err := c.BodyParser(&md); err != nil || md.UploadID == "" {
+				return c.Next()
+			}
+			uploadID = md.UploadID
+		}
+
+		owner := cluster.Owner(uploadID)
+		// Never forward twice, even if peers disagree on membership
+		if owner.ID == cluster.Self().ID || ForwardedBy(c) != "" {
+			c.Set(HeaderNode, cluster.Self().ID)
+			return c.Next()
+		}
+
+		client := ClientIP(c)
+		c.Request().Header.Set(services.HeaderForwardedBy, cluster.Self().ID)
+		c.Request().Header.Set(services.HeaderForwardedFor, client)
+		c.Request().Header.Set(services.HeaderForwardSignature, cluster.SignForward(c.Method(), c.OriginalURL(), client))
+		if err := proxy.DoTimeout(c, owner.URL+c.OriginalURL(), forwardTimeout); err != nil {
+			logging.FromContext(c).Error("cluster forward failed", "event", "cluster_forward",
+				"upload_id", uploadID, "node", owner.ID, "method", c.Method(), "path", c.Path(), "error", err)
+			return SendError(c, models.NewAPIError(fiber.StatusBadGateway, models.CodeNodeUnavailable, "Owning node unavailable").
+				With("node", owner.ID))
+		}
+		return nil
+	}
+}
+
+// ClusterRedirect sends clients of an upload's event stream to the node
+// that owns the upload. Streams cannot be proxied like ClusterForward's
+// requests, and only the owner sees every chunk arrive.
+func ClusterRedirect() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cluster := services.Cluster
+		if cluster == nil || ForwardedBy(c) != "" {
+			return c.Next()
+		}
+		owner := cluster.Owner(c.Params("uploadID"))
+		if owner.ID == cluster.Self().ID {
+			return c.Next()
+		}
+		return c.Redirect(owner.URL+c.OriginalURL(), fiber.StatusTemporaryRedirect)
+	}
+}
@@ -1,15 +1,22 @@
 package middleware
 
 import (
+	"strings"
+
+	"aetherlink/config"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
-func SetupCORS() fiber.Handler {
+// SetupCORS allows the configured origins. Credentials can only be allowed
+// for explicit origins, which config validation enforces.
+func SetupCORS(c config.CORSConfig) fiber.Handler {
 	return cors.New(cors.Config{
-		AllowOrigins:     "*",
+		AllowOrigins:     strings.Join(c.AllowOrigins, ","),
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Priority",
-		AllowCredentials: true,
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Priority, X-Request-ID, traceparent, tracestate",
+		AllowCredentials: c.AllowCredentials,
+		ExposeHeaders:    "Retry-After, X-Queue-Delay-Ms, X-Throttle-Delay-Ms, X-Request-ID",
 	})
 }
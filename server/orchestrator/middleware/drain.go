@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"aetherlink/config"
+	"aetherlink/models"
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Drain tracks requests that write to storage so a shutdown waits for them,
+// and turns new ones away with 503 once the server is draining. Clients
+// retry after Retry-After, against a replica or the restarted server.
+func Drain() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		done, ok := services.Lifecycle.Begin()
+		if !ok {
+			retryAfter := config.ShutdownReconnectDelay
+			SetRetryAfter(c, retryAfter)
+			c.Set(fiber.HeaderConnection, "close")
+			return SendError(c, models.NewAPIError(fiber.StatusServiceUnavailable, models.CodeShuttingDown, "Server is shutting down").
+				With("retry_after_ms", retryAfter.Milliseconds()))
+		}
+		defer done()
+		return c.Next()
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"aetherlink/logging"
+	"aetherlink/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIPrefix is where the versioned API is mounted
+const APIPrefix = "/v1"
+
+// Versioned reports whether the request was made to the /v1 API
+func Versioned(c *fiber.Ctx) bool {
+	path := c.Path()
+	return path == APIPrefix || strings.HasPrefix(path, APIPrefix+"/")
+}
+
+// legacyDetails are the names the unversioned routes gave details before
+// /v1; those routes send both names
+var legacyDetails = map[string]string{
+	"missing_chunks":        "missingChunks",
+	"received_count":        "receivedCount",
+	"total_chunks":          "totalChunks",
+	"unrecoverable_stripes": "unrecoverableStripes",
+}
+
+// SendError writes e as the response: wrapped in the error envelope for
+// /v1 requests, as {"error": message} plus its details for the unversioned
+// routes, which older clients read as a string
+func SendError(c *fiber.Ctx, e *models.APIError) error {
+	c.Status(e.Status)
+	if Versioned(c) {
+		return c.JSON(models.ErrorEnvelope{Error: *e})
+	}
+	body := fiber.Map{}
+	for k, v := range e.Details {
+		body[k] = v
+		if legacy, ok := legacyDetails[k]; ok {
+			body[legacy] = v
+		}
+	}
+	body["error"] = e.Message
+	return c.JSON(body)
+}
+
+// ErrorHandler is the app's fiber.Config.ErrorHandler. It renders errors no
+// handler answered itself, like unknown routes or oversized bodies, in the
+// format of SendError.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var apiErr *models.APIError
+	if errors.As(err, &apiErr) {
+		return SendError(c, apiErr)
+	}
+	var fe *fiber.Error
+	if !errors.As(err, &fe) {
+		logging.FromContext(c).Error("unhandled error", "error", err)
+		return SendError(c, models.NewAPIError(fiber.StatusInternalServerError, models.CodeInternal, "Internal server error"))
+	}
+	code := models.CodeInternal
+	switch {
+	case fe.Code == fiber.StatusNotFound:
+		code = models.CodeNotFound
+	case fe.Code == fiber.StatusMethodNotAllowed:
+		code = models.CodeMethodNotAllowed
+	case fe.Code == fiber.StatusRequestEntityTooLarge:
+		code = models.CodePayloadTooLarge
+	case fe.Code < 500:
+		code = models.CodeInvalidRequest
+	}
+	message := fe.Message
+	if message == "" {
+		message = http.StatusText(fe.Code)
+	}
+	return SendError(c, models.NewAPIError(fe.Code, code, message))
+}
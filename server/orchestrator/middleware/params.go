@@ -0,0 +1,13 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Param returns a copy of a route parameter. Params alias the request
+// buffer, which is reused once the handler returns, so IDs are copied here
+// before services keep them as map keys, in readers or in spans.
+func Param(c *fiber.Ctx, key string) string {
+	return utils.CopyString(c.Params(key))
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"strconv"
+
+	"aetherlink/models"
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// HeaderPriority classifies a request, e.g. "emergency" or "bulk"
+	HeaderPriority = "X-Priority"
+	// HeaderQueueDelay reports how long the request waited for a slot
+	HeaderQueueDelay = "X-Queue-Delay-Ms"
+)
+
+// PriorityScheduler admits requests through services.Scheduler according to
+// their X-Priority header, and sheds them with 503 when the server is
+// saturated. The queueing delay is returned in X-Queue-Delay-Ms.
+func PriorityScheduler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := requestPriority(c)
+		release, waited, err := services.Scheduler.Acquire(p)
+		c.Set(HeaderQueueDelay, strconv.FormatInt(waited.Milliseconds(), 10))
+		if err != nil {
+			retryAfter := services.Scheduler.RetryAfter(p)
+			SetRetryAfter(c, retryAfter)
+			message := "Server busy, request queue full"
+			if errors.Is(err, services.ErrQueueTimeout) {
+				message = "Server busy, timed out waiting for a slot"
+			}
+			return SendError(c, models.NewAPIError(fiber.StatusServiceUnavailable, models.CodeServerBusy, message).
+				With("priority", p.String()).
+				With("queue_delay_ms", waited.Milliseconds()).
+				With("retry_after_ms", retryAfter.Milliseconds()))
+		}
+		defer release()
+
+		c.Locals("priority", p)
+		return c.Next()
+	}
+}
+
+// requestPriority returns the X-Priority of a request. Chunk requests without
+// the header keep the priority their session was started with.
+func requestPriority(c *fiber.Ctx) services.Priority {
+	if value := c.Get(HeaderPriority); value != "" {
+		return services.ParsePriority(value)
+	}
+	if uploadID := c.Params("uploadID"); uploadID != "" {
+		if p, ok := services.StoredPriority(uploadID); ok {
+			return p
+		}
+	}
+	return services.PriorityNormal
+}
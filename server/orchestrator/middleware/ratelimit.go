@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"aetherlink/helpers"
+	"aetherlink/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// idleBucketTTL is how long an idle client's bucket is kept
+const idleBucketTTL = 10 * time.Minute
+
+// RateLimit limits each client address, as reported by ClientIP, to rps
+// requests per second with the given burst. Rejected requests get 429 with
+// Retry-After so adaptive clients can back off for the right amount of time.
+// rps <= 0 disables the limit.
+func RateLimit(rps float64, burst int) fiber.Handler {
+	if rps <= 0 {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	var mu sync.Mutex
+	buckets := make(map[string]*helpers.TokenBucket)
+
+	go func() {
+		ticker := time.NewTicker(idleBucketTTL)
+		defer ticker.Stop()
+		for range ticker.C {
+			mu.Lock()
+			for ip, b := range buckets {
+				if time.Since(b.LastUsed()) > idleBucketTTL {
+					delete(buckets, ip)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return func(c *fiber.Ctx) error {
+		ip := ClientIP(c)
+		mu.Lock()
+		b, ok := buckets[ip]
+		if !ok {
+			b = helpers.NewTokenBucket(rps, float64(burst))
+			// The bucket outlives the request, so its key must not alias
+			// any request buffer
+			buckets[utils.CopyString(ip)] = b
+		}
+		mu.Unlock()
+
+		if allowed, wait := b.Take(1); !allowed {
+			return TooManyRequests(c, "Rate limit exceeded", wait)
+		}
+		return c.Next()
+	}
+}
+
+// TooManyRequests sends a 429 carrying both a Retry-After header and a
+// millisecond retry hint in the body
+func TooManyRequests(c *fiber.Ctx, message string, retryAfter time.Duration) error {
+	SetRetryAfter(c, retryAfter)
+	return SendError(c, models.NewAPIError(fiber.StatusTooManyRequests, models.CodeRateLimited, message).
+		With("retry_after_ms", retryAfter.Milliseconds()))
+}
+
+// SetRetryAfter sets the Retry-After header, rounding up to whole seconds so
+// a client never retries before the wait is over
+func SetRetryAfter(c *fiber.Ctx, retryAfter time.Duration) {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
@@ -0,0 +1,64 @@
+package middleware_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"aetherlink/config"
+	"aetherlink/middleware"
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRateLimitPerClientThroughPeer(t *testing.T) {
+	nodes := []config.ClusterNode{{ID: "a", URL: "http://127.0.0.1:1"}, {ID: "b", URL: "http://127.0.0.1:2"}}
+	services.InitCluster(config.ClusterSettings{NodeID: "b", Nodes: nodes, Secret: "test-secret"})
+	peer := services.Cluster
+	services.InitCluster(config.ClusterSettings{NodeID: "a", Nodes: nodes, Secret: "test-secret"})
+	defer func() { services.Cluster = nil }()
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	app.Use(middleware.ClusterPeer())
+	app.Use(middleware.RateLimit(0.001, 2))
+	app.Get("/ping", func(c *fiber.Ctx) error { return c.SendString("pong") })
+
+	// send forwards a request from client through peer b. With trace set an
+	// extra header moves the forwarding headers to other slots of the
+	// reused header buffers.
+	send := func(client string, trace bool) int {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodGet, "/ping", nil)
+		if trace {
+			req.Header.Set("X-Aa-Trace", "1")
+		}
+		req.Header.Set(services.HeaderForwardedBy, "b")
+		req.Header.Set(services.HeaderForwardedFor, client)
+		req.Header.Set(services.HeaderForwardSignature, peer.SignForward(fiber.MethodGet, "/ping", client))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	clients := []string{"198.51.100.1", "203.0.113.20"}
+	for round := 1; round <= 3; round++ {
+		for _, client := range clients {
+			want := fiber.StatusOK
+			if round > 2 {
+				want = fiber.StatusTooManyRequests
+			}
+			if code := send(client, round == 2); code != want {
+				t.Errorf("request %d of %s: status %d, want %d", round, client, code, want)
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if code := send(fmt.Sprintf("192.0.2.%d", i), i == 1); code != fiber.StatusOK {
+			t.Errorf("first request of another client: status %d", code)
+		}
+	}
+}
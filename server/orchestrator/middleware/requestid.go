@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"aetherlink/helpers"
+	"aetherlink/logging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderRequestID carries the request correlation ID
+const HeaderRequestID = "X-Request-ID"
+
+// RequestLogger assigns every request an ID (reusing a well-formed incoming
+// X-Request-ID), echoes it in the response, attaches a request-scoped logger
+// and writes one access log line per request
+func RequestLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(HeaderRequestID)
+		if id == "" || len(id) > 128 {
+			id = helpers.GenerateShareID()
+		}
+		c.Set(HeaderRequestID, id)
+		// Keep the ID on the request so cluster forwards carry it along
+		c.Request().Header.Set(HeaderRequestID, id)
+
+		logger := slog.Default().With(
+			"request_id", id,
+			"client", ClientIP(c),
+		)
+		logging.WithContext(c, logger)
+
+		start := time.Now()
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if err != nil {
+			if fe, ok := err.(*fiber.Error); ok {
+				status = fe.Code
+			} else {
+				status = fiber.StatusInternalServerError
+			}
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		// Handlers may have enriched the logger with upload attributes
+		logging.FromContext(c).Log(c.UserContext(), level, "request",
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+		return err
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderThrottleDelay reports how long a chunk response was held back
+const HeaderThrottleDelay = "X-Throttle-Delay-Ms"
+
+// IngestThrottle paces chunk uploads to the configured bandwidth limits.
+// The chunk is written first and the response is held back afterwards, so a
+// throttled upload does not keep an admission slot busy while it waits and
+// each client worker naturally slows to the allowed rate.
+func IngestThrottle() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		n, _ := c.Locals("ingested_bytes").(int)
+		if n <= 0 {
+			return nil
+		}
+		shareID, _ := c.Locals("share_id").(string)
+		priority, ok := c.Locals("priority").(services.Priority)
+		if !ok {
+			priority = requestPriority(c)
+		}
+		if d := services.Throttle.Delay(Param(c, "uploadID"), shareID, priority, int64(n)); d > 0 {
+			c.Set(HeaderThrottleDelay, strconv.FormatInt(d.Milliseconds(), 10))
+			time.Sleep(d)
+		}
+		return nil
+	}
+}
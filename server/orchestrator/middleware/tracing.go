@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"aetherlink/config"
+	"aetherlink/logging"
+	"aetherlink/models"
+	"aetherlink/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for an upload lifecycle request. The parent
+// is taken from the W3C traceparent header; requests without one are joined
+// to the trace recorded for the upload at /init, so every request of an
+// upload lands in a single trace.
+func Tracing() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := tracing.Extract(c.UserContext(), &c.Request().Header)
+		uploadID := Param(c, "uploadID")
+		if !trace.SpanContextFromContext(ctx).IsValid() && uploadID != "" {
+			ctx = tracing.WithTraceParent(ctx, storedTraceParent(uploadID))
+		}
+
+		ctx, span := tracing.Start(ctx, c.Method()+" "+c.Route().Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Method()),
+				attribute.String("http.route", c.Route().Path),
+				attribute.String("url.path", c.Path()),
+			),
+		)
+		defer span.End()
+		if uploadID != "" {
+			span.SetAttributes(attribute.String("upload_id", uploadID))
+		}
+		c.SetUserContext(ctx)
+		// Cluster forwards continue the trace on the owning node
+		tracing.Inject(ctx, &c.Request().Header)
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			logging.WithContext(c, logging.FromContext(c).With("trace_id", sc.TraceID().String()))
+		}
+
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, fiber.ErrInternalServerError.Message)
+		}
+		return err
+	}
+}
+
+// storedTraceParent returns the traceparent saved in an upload's metadata
+func storedTraceParent(uploadID string) string {
+	data, err := os.ReadFile(filepath.Join(config.StorageRoot, uploadID, "metadata.json"))
+	if err != nil {
+		return ""
+	}
+	var md models.Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return ""
+	}
+	return md.TraceParent
+}
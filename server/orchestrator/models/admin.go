@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Upload states reported to operators
+const (
+	UploadActive    = "active"    // chunks are still being received
+	UploadCompleted = "completed" // the file was assembled
+)
+
+// UploadSummary describes a stored upload for the admin API
+type UploadSummary struct {
+	UploadID       string       `json:"upload_id"`
+	ShareID        string       `json:"share_id"`
+	Filename       string       `json:"filename"`
+	State          string       `json:"state"`
+	Priority       string       `json:"priority,omitempty"`
+	TotalChunks    int          `json:"total_chunks"`
+	ReceivedChunks int          `json:"received_chunks"`
+	DeclaredBytes  int64        `json:"declared_bytes"` // size announced at /init, parity included
+	StoredBytes    int64        `json:"stored_bytes"`   // bytes on disk in the upload directory
+	UpdatedAt      time.Time    `json:"updated_at"`
+	SSEClients     int          `json:"sse_clients"`
+	Sinks          []SinkStatus `json:"sinks,omitempty"`
+}
+
+// ShareSummary aggregates the uploads of a share and the state of its room
+type ShareSummary struct {
+	ShareID     string    `json:"share_id"`
+	Uploads     int       `json:"uploads"`
+	Active      int       `json:"active"`
+	Completed   int       `json:"completed"`
+	QuotaBytes  int64     `json:"quota_bytes"` // bytes counted against share_max_bytes
+	StoredBytes int64     `json:"stored_bytes"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Expired     bool      `json:"expired"`
+	SSEClients  int       `json:"sse_clients"` // room subscribers
+}
+
+// JanitorReport lists what a maintenance pass cleaned up
+type JanitorReport struct {
+	ExpiredRooms         []string `json:"expired_rooms"`
+	ReleasedReservations int      `json:"released_reservations"`
+	EvictedStreams       int      `json:"evicted_streams"`
+	PrunedEventLogs      int      `json:"pruned_event_logs"`
+}
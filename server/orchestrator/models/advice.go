@@ -0,0 +1,11 @@
+package models
+
+// ChunkAdvice is the server's recommendation to an uploading client, derived
+// from the measured ingest rate and write latency of its upload
+type ChunkAdvice struct {
+	ChunkSize         int64   `json:"chunk_size"`  // suggested chunk size for new uploads
+	MaxWorkers        int     `json:"max_workers"` // parallel chunk PUTs the server can absorb
+	BackoffMs         int64   `json:"backoff_ms"`  // pause before the next chunk, 0 if none
+	IngestBytesPerSec float64 `json:"ingest_bytes_per_sec"`
+	WriteLatencyMs    float64 `json:"write_latency_ms"`
+}
@@ -0,0 +1,62 @@
+package models
+
+// Machine-readable error codes. Clients branch on these, never on messages.
+const (
+	CodeInvalidRequest      = "invalid_request"      // malformed body, parameter or query
+	CodeUnauthorized        = "unauthorized"         // missing or wrong admin token
+	CodeAdminDisabled       = "admin_disabled"       // no admin token configured
+	CodeAccessDenied        = "access_denied"        // share ID does not match
+	CodeNotFound            = "not_found"            // no such route or resource
+	CodeUploadNotFound      = "upload_not_found"     // no session with this upload ID
+	CodeFileNotFound        = "file_not_found"       // no such file in the upload
+	CodeMethodNotAllowed    = "method_not_allowed"   // route exists for other methods
+	CodeUploadCompleted     = "upload_completed"     // the upload was already assembled
+	CodeChunkOutOfRange     = "chunk_out_of_range"   // index beyond data and parity chunks
+	CodeEmptyChunk          = "empty_chunk"          // chunk PUT without a body
+	CodeChunkHashMismatch   = "chunk_hash_mismatch"  // chunk differs from its declared hash; resend
+	CodeFileHashMismatch    = "file_hash_mismatch"   // assembled file differs from file_hash
+	CodeChunksMissing       = "chunks_missing"       // /complete before every chunk arrived
+	CodePayloadTooLarge     = "payload_too_large"    // body above limits.max_upload_size
+	CodeQuotaExceeded       = "quota_exceeded"       // share or room quota used up
+	CodeRateLimited         = "rate_limited"         // retry after Retry-After
+	CodeInsufficientStorage = "insufficient_storage" // not enough disk for the upload
+	CodeStoragePaused       = "storage_paused"       // disk below the low watermark
+	CodeServerBusy          = "server_busy"          // shed by the priority scheduler
+	CodeShuttingDown        = "shutting_down"        // draining; retry after Retry-After
+	CodeNodeUnavailable     = "node_unavailable"     // the owning cluster node did not answer
+	CodeNoFailedSinks       = "no_failed_sinks"      // sink retry without a failed push
+	CodeVaultDisabled       = "vault_disabled"       // credentials need VAULT_KEY
+	CodeInternal            = "internal_error"
+)
+
+// APIError is an error response. The /v1 API sends it in an ErrorEnvelope;
+// the unversioned routes send {"error": message} with the details beside it.
+type APIError struct {
+	Status  int                    `json:"-"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// ErrorEnvelope is the body of every /v1 error response
+type ErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// NewAPIError returns an error response with the given HTTP status
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// With adds a detail to the error and returns it
+func (e *APIError) With(key string, value interface{}) *APIError {
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
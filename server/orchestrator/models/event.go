@@ -0,0 +1,24 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// LoggedEvent is an SSE message retained in an event log. IDs increase
+// monotonically within a stream on each node. When events are shared
+// between replicas, Key identifies the event on every node.
+type LoggedEvent struct {
+	ID   uint64    `json:"id"`
+	Key  string    `json:"key,omitempty"` // "<origin node>:<origin id>"
+	Data string    `json:"data"`
+	Time time.Time `json:"time"`
+}
+
+// EventID returns the value sent as the SSE "id:" field
+func (ev LoggedEvent) EventID() string {
+	if ev.Key != "" {
+		return ev.Key
+	}
+	return strconv.FormatUint(ev.ID, 10)
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// File statuses reported by the file listing
+const (
+	FileComplete   = "complete"
+	FileIncomplete = "incomplete"
+)
+
+// FileMetadata describes one upload of a share for listing and download
+type FileMetadata struct {
+	UploadID             string    `json:"upload_id"`
+	Filename             string    `json:"filename"`
+	TotalChunks          int       `json:"total_chunks"`
+	ReceivedChunks       int       `json:"received_chunks"`
+	FileSize             int64     `json:"file_size"`
+	UploadTime           time.Time `json:"upload_time"`
+	Status               string    `json:"status"`
+	CompletionPercentage float64   `json:"completion_percentage"`
+
+	Sinks  []SinkStatus      `json:"sinks,omitempty"`  // pushes to external sinks
+	Forced *ForcedCompletion `json:"forced,omitempty"` // the file may differ from what the client sent
+}
+
+// FilesResponse is the file listing of a share
+type FilesResponse struct {
+	Files []FileMetadata `json:"files"`
+	Count int            `json:"count"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Metadata struct {
 	UploadID    string   `json:"upload_id"`
 	Filename    string   `json:"filename"`
@@ -8,4 +10,63 @@ type Metadata struct {
 	ChunkHashes []string `json:"chunk_hashes"` // client-provided expected hashes
 	FileHash    string   `json:"file_hash"`    // overall file hash
 	ShareID     string   `json:"share_id"`     // unique share ID for access control
+	FileSize    int64    `json:"file_size,omitempty"`
+	Priority    string   `json:"priority,omitempty"`     // "high", "normal" or "bulk"
+	TraceParent string   `json:"trace_parent,omitempty"` // W3C traceparent of the /init span
+
+	// Optional Reed-Solomon forward error correction. Data chunks are grouped
+	// into stripes of DataShards chunks; each stripe carries ParityShards
+	// parity chunks computed over chunks zero-padded to ChunkSize (a short
+	// final stripe is padded with all-zero chunks). Parity chunks are uploaded
+	// after the data chunks, at index TotalChunks + stripe*ParityShards + j.
+	DataShards   int      `json:"data_shards,omitempty"`
+	ParityShards int      `json:"parity_shards,omitempty"`
+	ParityHashes []string `json:"parity_hashes,omitempty"` // expected hashes of parity chunks
+
+	// Pushes of the assembled file to external sinks, set on completion
+	Sinks []SinkStatus `json:"sinks,omitempty"`
+
+	// Set when an operator forced an assembly that changed the outcome
+	Forced *ForcedCompletion `json:"forced,omitempty"`
+}
+
+// ForcedCompletion records how a forced assembly departs from what the
+// client sent. Such a file is flagged wherever it is listed and is never
+// pushed to sinks.
+type ForcedCompletion struct {
+	FilledChunks []int     `json:"filled_chunks,omitempty"` // written as zeros
+	ExpectedHash string    `json:"expected_hash,omitempty"` // declared by the client, when the file does not match it
+	ForcedAt     time.Time `json:"forced_at"`
+}
+
+// FECEnabled reports whether the upload carries parity chunks
+func (md Metadata) FECEnabled() bool {
+	return md.DataShards > 0 && md.ParityShards > 0
+}
+
+// Stripes returns the number of FEC stripes covering the data chunks
+func (md Metadata) Stripes() int {
+	if !md.FECEnabled() {
+		return 0
+	}
+	return (md.TotalChunks + md.DataShards - 1) / md.DataShards
+}
+
+// ParityChunks returns the number of parity chunks expected after the data chunks
+func (md Metadata) ParityChunks() int {
+	return md.Stripes() * md.ParityShards
+}
+
+// TotalUploadChunks returns the number of data plus parity chunks
+func (md Metadata) TotalUploadChunks() int {
+	return md.TotalChunks + md.ParityChunks()
+}
+
+// ExpectedBytes returns the disk space the upload's chunks will occupy
+func (md Metadata) ExpectedBytes() int64 {
+	data := md.FileSize
+	if data <= 0 {
+		data = int64(md.TotalChunks) * md.ChunkSize
+	}
+	return data + int64(md.ParityChunks())*md.ChunkSize
 }
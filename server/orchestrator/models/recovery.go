@@ -0,0 +1,44 @@
+package models
+
+// Storage problems found by the consistency scan
+const (
+	IssueOrphanPart      = "orphan_part"      // chunk_*.part, <filename>.part or *.tmp left by an interrupted write
+	IssueCorruptChunk    = "corrupt_chunk"    // chunk whose content does not match its expected hash
+	IssueMissingHash     = "missing_hash"     // chunk without a .xxhash sidecar
+	IssueStaleHash       = "stale_hash"       // sidecar that disagrees with the chunk's content
+	IssueOrphanHash      = "orphan_hash"      // sidecar without a chunk
+	IssueStrayChunk      = "stray_chunk"      // chunk index beyond the upload's chunk count
+	IssueUnrecorded      = "unrecorded_chunk" // valid chunk missing from received.json
+	IssueMissingChunk    = "missing_chunk"    // received.json entry without a valid chunk
+	IssueInvalidReceived = "invalid_received" // received.json missing or unreadable
+	IssueLeftoverChunks  = "leftover_chunks"  // assembled upload whose chunk cleanup did not finish
+	IssueInvalidMetadata = "invalid_metadata" // upload directory without readable metadata.json
+)
+
+// StorageIssue is one inconsistency in an upload directory
+type StorageIssue struct {
+	UploadID string `json:"upload_id"`
+	Kind     string `json:"kind"`
+	Path     string `json:"path,omitempty"`
+	Chunk    *int   `json:"chunk,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Fixed    bool   `json:"fixed"`
+}
+
+// RecoveryReport summarises a consistency scan of the storage root
+type RecoveryReport struct {
+	Uploads  int            `json:"uploads"`  // upload directories scanned
+	Repaired bool           `json:"repaired"` // whether fixes were applied
+	Issues   []StorageIssue `json:"issues"`
+}
+
+// Fixed returns the number of issues that were repaired
+func (r RecoveryReport) Fixed() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Fixed {
+			n++
+		}
+	}
+	return n
+}
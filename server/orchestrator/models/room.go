@@ -24,15 +24,21 @@ type UploadInfo struct {
 
 // CompletedFile represents a completed upload in a room
 type CompletedFile struct {
-	UploadID    string    `json:"upload_id"`
-	Filename    string    `json:"filename"`
-	FileSize    int64     `json:"file_size"`
-	CompletedAt time.Time `json:"completed_at"`
+	UploadID    string            `json:"upload_id"`
+	Filename    string            `json:"filename"`
+	FileSize    int64             `json:"file_size"`
+	CompletedAt time.Time         `json:"completed_at"`
+	Forced      *ForcedCompletion `json:"forced,omitempty"`
 }
 
+// EventServerShutdown is sent to every SSE client, room or upload, when the
+// server drains for shutdown. It is not logged, so it does not move the
+// client's replay position.
+const EventServerShutdown = "server_shutdown"
+
 // RoomEvent represents a broadcast event for room updates
 type RoomEvent struct {
-	Type      string      `json:"type"` // "upload_start", "chunk_received", "upload_complete", "room_state"
+	Type      string      `json:"type"` // "upload_start", "chunk_received", "upload_complete", "upload_failed", "room_expired", "room_state", "sink_progress", "sink_complete", "sink_failed", "server_shutdown"
 	ShareID   string      `json:"share_id"`
 	UploadID  string      `json:"upload_id,omitempty"`
 	Filename  string      `json:"filename,omitempty"`
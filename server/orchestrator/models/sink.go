@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Sink delivery states
+const (
+	SinkPending  = "pending"
+	SinkUploaded = "uploaded"
+	SinkFailed   = "failed"
+)
+
+// Room events reporting pushes to sinks
+const (
+	EventSinkProgress = "sink_progress"
+	EventSinkComplete = "sink_complete"
+	EventSinkFailed   = "sink_failed"
+)
+
+// SinkStatus tracks the push of an assembled file to one sink. It is kept
+// in the upload's metadata.json, so pending pushes survive restarts.
+type SinkStatus struct {
+	Sink        string     `json:"sink"`
+	Status      string     `json:"status"`
+	URL         string     `json:"url,omitempty"`       // where the sink stored the file
+	RemoteID    string     `json:"remote_id,omitempty"` // the sink's own ID, e.g. a Cloudinary public ID
+	Account     string     `json:"account,omitempty"`   // vault credential used, empty for the process-wide account
+	Attempts    int        `json:"attempts"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// SinkPolicy names the sinks a share's completed files are pushed to
+type SinkPolicy struct {
+	ShareID string   `json:"share_id"`
+	Sinks   []string `json:"sinks"`
+}
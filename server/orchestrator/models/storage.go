@@ -0,0 +1,12 @@
+package models
+
+// StorageUsage reports free space and outstanding upload reservations
+type StorageUsage struct {
+	FreeBytes      uint64 `json:"free_bytes"`
+	TotalBytes     uint64 `json:"total_bytes"`
+	ReservedBytes  uint64 `json:"reserved_bytes"`  // declared bytes not yet written
+	AvailableBytes uint64 `json:"available_bytes"` // free minus reservations
+	LowWatermark   uint64 `json:"low_watermark"`
+	Reservations   int    `json:"reservations"`
+	Paused         bool   `json:"paused"` // new sessions are refused below the watermark
+}
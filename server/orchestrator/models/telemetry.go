@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// ClientTelemetry is the summary an uploading browser reports once an upload
+// finishes, mirroring the frontend TelemetryMetrics
+type ClientTelemetry struct {
+	NetworkQuality    string  `json:"network_quality"` // "excellent", "good", "fair" or "poor"
+	EffectiveType     string  `json:"effective_type,omitempty"`
+	DownlinkMbps      float64 `json:"downlink_mbps,omitempty"`
+	RTTMs             float64 `json:"rtt_ms,omitempty"`
+	ChunkSize         int64   `json:"chunk_size"`
+	TotalBytes        int64   `json:"total_bytes"`
+	DurationMs        int64   `json:"duration_ms"`
+	SuccessfulChunks  int     `json:"successful_chunks"`
+	TotalRetries      int     `json:"total_retries"`
+	WastedBytes       int64   `json:"wasted_bytes"`
+	AverageLatencyMs  float64 `json:"average_latency_ms"`
+	PeakLatencyMs     float64 `json:"peak_latency_ms"`
+	JitterMs          float64 `json:"jitter_ms"`
+	PeakConcurrency   int     `json:"peak_concurrency"`
+	ConcurrencyDrops  int     `json:"concurrency_drops"`
+	AverageEfficiency float64 `json:"average_efficiency"` // 0-100%
+	PerformanceScore  float64 `json:"performance_score"`
+	Adaptive          bool    `json:"adaptive"`
+}
+
+// ServerTelemetry is what the orchestrator measured for the same upload
+type ServerTelemetry struct {
+	TotalChunks       int     `json:"total_chunks"`
+	ChunkSize         int64   `json:"chunk_size"`
+	FileSize          int64   `json:"file_size"`
+	ChunksWritten     int     `json:"chunks_written"`
+	BytesWritten      int64   `json:"bytes_written"`
+	IdempotentReplays int     `json:"idempotent_replays"`
+	HashMismatches    int     `json:"hash_mismatches"`
+	AvgWriteMs        float64 `json:"avg_write_ms"`
+	IngestMs          int64   `json:"ingest_ms"` // first to last chunk
+	AssemblyMs        int64   `json:"assembly_ms"`
+	Completed         bool    `json:"completed"`
+}
+
+// TelemetryRecord is one stored telemetry report
+type TelemetryRecord struct {
+	UploadID   string          `json:"upload_id"`
+	ShareID    string          `json:"share_id"`
+	ReportedAt time.Time       `json:"reported_at"`
+	Client     ClientTelemetry `json:"client"`
+	Server     ServerTelemetry `json:"server"`
+}
+
+// TelemetryAggregate summarises the reports falling into one group
+type TelemetryAggregate struct {
+	Group               string  `json:"group"`
+	Uploads             int     `json:"uploads"`
+	TotalBytes          int64   `json:"total_bytes"`
+	AvgThroughputBps    float64 `json:"avg_throughput_bps"`
+	AvgLatencyMs        float64 `json:"avg_latency_ms"`
+	P95LatencyMs        float64 `json:"p95_latency_ms"`
+	AvgRetriesPerChunk  float64 `json:"avg_retries_per_chunk"`
+	AvgEfficiency       float64 `json:"avg_efficiency"`
+	AvgPerformanceScore float64 `json:"avg_performance_score"`
+	AvgServerWriteMs    float64 `json:"avg_server_write_ms"`
+	HashMismatches      int     `json:"hash_mismatches"`
+}
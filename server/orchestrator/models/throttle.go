@@ -0,0 +1,11 @@
+package models
+
+// ThrottleLimits caps upload ingest and download bandwidth in bytes per
+// second. Zero means unlimited. Limits apply to both directions combined.
+type ThrottleLimits struct {
+	GlobalBytesPerSec       int64            `json:"global_bytes_per_sec"`
+	UploadBytesPerSec       int64            `json:"upload_bytes_per_sec"`        // per upload session
+	DefaultShareBytesPerSec int64            `json:"default_share_bytes_per_sec"` // per share without an override
+	ShareBytesPerSec        map[string]int64 `json:"share_bytes_per_sec,omitempty"`
+	PriorityBytesPerSec     map[string]int64 `json:"priority_bytes_per_sec,omitempty"` // keyed by "high", "normal", "bulk"
+}
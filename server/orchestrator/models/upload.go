@@ -0,0 +1,53 @@
+package models
+
+// InitResponse answers /init with the IDs of the new session
+type InitResponse struct {
+	UploadID string `json:"upload_id"`
+	ShareID  string `json:"share_id"`
+}
+
+// Chunk receipt statuses
+const (
+	ChunkReceived        = "received"
+	ChunkAlreadyReceived = "already_received"
+)
+
+// ChunkReceipt answers a chunk PUT
+type ChunkReceipt struct {
+	Status        string      `json:"status"`
+	Message       string      `json:"message,omitempty"`
+	ReceivedBytes int         `json:"received_bytes"`
+	ChunkHash     string      `json:"chunk_hash"`
+	Advice        ChunkAdvice `json:"advice"`
+}
+
+// UploadStatus lists the chunks an upload received and whether it can
+// already be completed, directly or through FEC reconstruction
+type UploadStatus struct {
+	ReceivedChunks       []int `json:"received_chunks"`
+	Recoverable          bool  `json:"recoverable"`
+	UnrecoverableStripes []int `json:"unrecoverable_stripes,omitempty"`
+}
+
+// CompleteResult answers a successful /complete
+type CompleteResult struct {
+	Status      string `json:"status"` // "assembled", or "forced" when an operator override changed the outcome
+	FilePath    string `json:"file_path"`
+	FileHash    string `json:"file_hash"`
+	DownloadURL string `json:"download_url"`
+	// Set by a forced completion: the chunks written as zeros and the file
+	// hash the client declared when the assembled file does not match it
+	FilledChunks []int  `json:"filled_chunks,omitempty"`
+	ExpectedHash string `json:"expected_hash,omitempty"`
+}
+
+// UploadProgress is the message of an upload's SSE stream
+type UploadProgress struct {
+	UploadID         string      `json:"upload_id"`
+	Filename         string      `json:"filename"`
+	TotalChunks      int         `json:"total_chunks"`
+	ReceivedChunks   []int       `json:"received_chunks"`
+	ReceivedCount    int         `json:"received_count"`
+	CompletedPercent int         `json:"completed_percent"`
+	Advice           ChunkAdvice `json:"advice"`
+}
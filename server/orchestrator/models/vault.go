@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// SinkCredential is an account of a sink, e.g. a customer's own Cloudinary
+// cloud, used for the files of one share or of every share of a tenant.
+// Config holds the account's settings and secrets; it is only accepted on
+// registration and never returned, Fields lists its keys instead.
+type SinkCredential struct {
+	ID        string            `json:"id"`
+	Sink      string            `json:"sink"`
+	ShareID   string            `json:"share_id,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Config    map[string]string `json:"config,omitempty"`
+	Fields    []string          `json:"fields,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Tenant groups the shares that use a tenant's sink credentials
+type Tenant struct {
+	ID       string   `json:"id"`
+	ShareIDs []string `json:"share_ids"`
+}
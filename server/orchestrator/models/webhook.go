@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook lifecycle events
+const (
+	EventUploadStart    = "upload_start"
+	EventUploadComplete = "upload_complete"
+	EventUploadFailed   = "upload_failed"
+	EventRoomExpired    = "room_expired"
+)
+
+// WebhookEvents lists the events a webhook can subscribe to
+var WebhookEvents = []string{EventUploadStart, EventUploadComplete, EventUploadFailed, EventRoomExpired}
+
+// Webhook is a registered endpoint notified of lifecycle events. An empty
+// ShareID subscribes to every share; empty Events subscribes to all events.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ShareID   string    `json:"share_id,omitempty"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret,omitempty"` // HMAC-SHA256 signing key
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent (or still to be sent) to one webhook
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	WebhookID   string          `json:"webhook_id"`
+	Event       string          `json:"event"`
+	ShareID     string          `json:"share_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt,omitempty"`
+	LastStatus  int             `json:"last_status,omitempty"` // HTTP status of the last attempt
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+}
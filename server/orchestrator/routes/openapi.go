@@ -0,0 +1,34 @@
+package routes
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpec describes the /v1 API. It is kept as YAML for readability
+// and served as JSON.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+var openAPIJSON = sync.OnceValues(func() ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(openAPISpec, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+})
+
+// OpenAPIHandler serves the OpenAPI 3 document of the /v1 API
+func OpenAPIHandler(c *fiber.Ctx) error {
+	body, err := openAPIJSON()
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
@@ -0,0 +1,395 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/middleware"
+	"aetherlink/models"
+	"aetherlink/services"
+	"aetherlink/sinks"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gofiber/fiber/v2"
+)
+
+const adminToken = "admin-secret"
+
+// TestMain runs the tests in a scratch directory, since storage and event
+// logs live under the working directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aetherlink-routes")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	os.Setenv(config.EnvAdminToken, adminToken)
+	cfg, err := config.Load(nil)
+	if err != nil {
+		panic(err)
+	}
+	cfg.Apply()
+	if err := os.MkdirAll(config.StorageRoot, 0755); err != nil {
+		panic(err)
+	}
+	// Event streams are documented as text
+	openapi3filter.RegisterBodyDecoder("text/event-stream", openapi3filter.FileBodyDecoder)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// contract sends requests to the API and checks every answer against the
+// OpenAPI document, noting the operations it saw
+type contract struct {
+	t       *testing.T
+	app     *fiber.App
+	doc     *openapi3.T
+	router  routers.Router
+	covered map[string]bool
+}
+
+func newContract(t *testing.T) *contract {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	SetupRoutes(app)
+	return &contract{t: t, app: app, doc: doc, router: router, covered: make(map[string]bool)}
+}
+
+// request builds an admin request to the /v1 API: []byte bodies are sent
+// as they are, anything else as JSON
+func request(method, path string, body interface{}) *http.Request {
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	case string:
+		r = strings.NewReader(b)
+	default:
+		data, _ := json.Marshal(b)
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, middleware.APIPrefix+path, r)
+	switch body.(type) {
+	case nil:
+	case []byte:
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	default:
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+adminToken)
+	return req
+}
+
+// expect sends req, checks its answer has status want and matches the
+// document, and returns the body
+func (ct *contract) expect(req *http.Request, want int) []byte {
+	ct.t.Helper()
+	route, params, err := ct.router.FindRoute(req)
+	if err != nil {
+		ct.t.Fatalf("%s %s is not documented: %v", req.Method, req.URL, err)
+	}
+	resp, err := ct.app.Test(req, -1)
+	if err != nil {
+		ct.t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ct.t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	if resp.StatusCode != want {
+		ct.t.Errorf("%s %s: status %d, want %d: %s", req.Method, req.URL, resp.StatusCode, want, body)
+	}
+
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: params,
+			Route:      route,
+		},
+		Status:  resp.StatusCode,
+		Header:  resp.Header,
+		Options: &openapi3filter.Options{IncludeResponseStatus: true},
+	}
+	input.SetBodyBytes(body)
+	if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+		ct.t.Errorf("%s %s: answer %d does not match the document: %v", req.Method, req.URL, resp.StatusCode, err)
+	}
+	ct.covered[route.Operation.OperationID] = true
+	return body
+}
+
+// call is expect for an admin request
+func (ct *contract) call(method, path string, body interface{}, want int) []byte {
+	ct.t.Helper()
+	return ct.expect(request(method, path, body), want)
+}
+
+// stream opens an event stream and checks its answer once stream is kicked
+func (ct *contract) stream(path, stream string) []byte {
+	ct.t.Helper()
+	done := make(chan []byte, 1)
+	go func() {
+		done <- ct.call(fiber.MethodGet, path, nil, fiber.StatusOK)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for services.EventLog.Subscribers(stream) == 0 {
+		if time.Now().After(deadline) {
+			ct.t.Fatalf("GET %s never subscribed to %s", path, stream)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	services.EventLog.Kick(stream)
+	return <-done
+}
+
+// upload opens an upload of chunks and sends those listed in send
+func (ct *contract) upload(uploadID, shareID string, chunks [][]byte, send ...int) {
+	ct.t.Helper()
+	md := models.Metadata{
+		UploadID:    uploadID,
+		ShareID:     shareID,
+		Filename:    uploadID + ".txt",
+		TotalChunks: len(chunks),
+		ChunkSize:   int64(len(chunks[0])),
+		FileHash:    helpers.HashChunk(bytes.Join(chunks, nil)),
+		FileSize:    int64(len(bytes.Join(chunks, nil))),
+	}
+	for _, c := range chunks {
+		md.ChunkHashes = append(md.ChunkHashes, helpers.HashChunk(c))
+	}
+	ct.call(fiber.MethodPost, "/init", md, fiber.StatusCreated)
+	for _, idx := range send {
+		ct.call(fiber.MethodPut, fmt.Sprintf("/upload/%s/%d", uploadID, idx), chunks[idx], fiber.StatusOK)
+	}
+}
+
+var testChunks = [][]byte{[]byte("first-"), []byte("second"), []byte("third")}
+
+func TestResponsesMatchOpenAPI(t *testing.T) {
+	ct := newContract(t)
+
+	t.Run("uploads", func(t *testing.T) {
+		ct.t = t
+		ct.call(fiber.MethodGet, "/health", nil, fiber.StatusOK)
+
+		ct.upload("contract-up", "contract-share", testChunks, 0)
+		ct.call(fiber.MethodPost, "/init", "{", fiber.StatusBadRequest)
+		ct.call(fiber.MethodPost, "/init", models.Metadata{UploadID: "../escape", Filename: "x", TotalChunks: 1, ChunkSize: 1}, fiber.StatusBadRequest)
+
+		ct.call(fiber.MethodPut, "/upload/contract-up/1", []byte("garbage"), fiber.StatusUnprocessableEntity)
+		ct.call(fiber.MethodPut, "/upload/contract-up/9", testChunks[1], fiber.StatusBadRequest)
+		ct.call(fiber.MethodPut, "/upload/contract-missing/0", testChunks[0], fiber.StatusNotFound)
+		ct.call(fiber.MethodPut, "/upload/contract-up/0", testChunks[0], fiber.StatusOK)
+
+		ct.call(fiber.MethodGet, "/status/contract-up", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/status/contract-missing", nil, fiber.StatusNotFound)
+
+		ct.stream("/events/contract-up", services.UploadStream("contract-up"))
+		ct.stream("/events/contract-up?last_event_id=0", services.UploadStream("contract-up"))
+
+		ct.call(fiber.MethodPost, "/complete/contract-up", nil, fiber.StatusBadRequest)
+		ct.call(fiber.MethodPost, "/complete/contract-missing", nil, fiber.StatusNotFound)
+		for _, idx := range []int{1, 2} {
+			ct.call(fiber.MethodPut, fmt.Sprintf("/upload/contract-up/%d", idx), testChunks[idx], fiber.StatusOK)
+		}
+		ct.call(fiber.MethodPost, "/complete/contract-up", nil, fiber.StatusOK)
+		ct.call(fiber.MethodPost, "/complete/contract-up", nil, fiber.StatusConflict)
+
+		ct.call(fiber.MethodDelete, "/cleanup/contract-up", nil, fiber.StatusForbidden)
+		ct.call(fiber.MethodDelete, "/cleanup/contract-missing", nil, fiber.StatusNotFound)
+		ct.upload("contract-cancel", "contract-share", testChunks)
+		ct.call(fiber.MethodDelete, "/cleanup/contract-cancel", nil, fiber.StatusOK)
+	})
+
+	t.Run("telemetry", func(t *testing.T) {
+		ct.t = t
+		report := models.ClientTelemetry{NetworkQuality: "good", ChunkSize: 6, TotalBytes: 17, SuccessfulChunks: 3}
+		ct.call(fiber.MethodPost, "/telemetry/contract-up", report, fiber.StatusCreated)
+		ct.call(fiber.MethodPost, "/telemetry/contract-up", models.ClientTelemetry{NetworkQuality: "dreadful"}, fiber.StatusBadRequest)
+		ct.call(fiber.MethodPost, "/telemetry/contract-missing", report, fiber.StatusNotFound)
+
+		ct.call(fiber.MethodGet, "/telemetry", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/telemetry?group_by=chunk_size&since=24h", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/telemetry?group_by=colour", nil, fiber.StatusBadRequest)
+		anonymous := request(fiber.MethodGet, "/telemetry", nil)
+		anonymous.Header.Del(fiber.HeaderAuthorization)
+		ct.expect(anonymous, fiber.StatusUnauthorized)
+		wrong := request(fiber.MethodGet, "/telemetry", nil)
+		wrong.Header.Set(fiber.HeaderAuthorization, "Bearer wrong")
+		ct.expect(wrong, fiber.StatusUnauthorized)
+	})
+
+	t.Run("files", func(t *testing.T) {
+		ct.t = t
+		ct.call(fiber.MethodGet, "/files?share_id=contract-share", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/files", nil, fiber.StatusBadRequest)
+
+		ct.call(fiber.MethodGet, "/file/contract-up?share_id=contract-share", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/file/contract-up", nil, fiber.StatusBadRequest)
+		ct.call(fiber.MethodGet, "/file/contract-up?share_id=other-share", nil, fiber.StatusForbidden)
+		ct.call(fiber.MethodGet, "/file/contract-missing?share_id=contract-share", nil, fiber.StatusNotFound)
+
+		ct.call(fiber.MethodGet, "/download/contract-up/contract-up.txt?share_id=contract-share", nil, fiber.StatusOK)
+		ranged := request(fiber.MethodGet, "/download/contract-up/contract-up.txt?share_id=contract-share", nil)
+		ranged.Header.Set(fiber.HeaderRange, "bytes=6-")
+		ct.expect(ranged, fiber.StatusPartialContent)
+		ct.call(fiber.MethodGet, "/download/contract-up/contract-up.txt", nil, fiber.StatusBadRequest)
+		ct.call(fiber.MethodGet, "/download/contract-up/contract-up.txt?share_id=other-share", nil, fiber.StatusForbidden)
+		ct.call(fiber.MethodGet, "/download/contract-up/other.txt?share_id=contract-share", nil, fiber.StatusNotFound)
+	})
+
+	t.Run("rooms", func(t *testing.T) {
+		ct.t = t
+		ct.call(fiber.MethodGet, "/room/contract-share", nil, fiber.StatusOK)
+		ct.stream("/room/contract-share/events", services.RoomStream("contract-share"))
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		ct.t = t
+		ct.call(fiber.MethodGet, "/admin/throttle", nil, fiber.StatusOK)
+		ct.call(fiber.MethodPut, "/admin/throttle", models.ThrottleLimits{GlobalBytesPerSec: 1 << 30}, fiber.StatusOK)
+		ct.call(fiber.MethodPut, "/admin/throttle", models.ThrottleLimits{GlobalBytesPerSec: -1}, fiber.StatusBadRequest)
+		ct.call(fiber.MethodPut, "/admin/throttle", models.ThrottleLimits{}, fiber.StatusOK)
+
+		var hook models.Webhook
+		json.Unmarshal(ct.call(fiber.MethodPost, "/admin/webhooks", models.Webhook{URL: "https://hooks.example.com/in"}, fiber.StatusCreated), &hook)
+		ct.call(fiber.MethodPost, "/admin/webhooks", models.Webhook{URL: "not a url"}, fiber.StatusBadRequest)
+		ct.call(fiber.MethodGet, "/admin/webhooks", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/admin/webhooks/deliveries?status=failed", nil, fiber.StatusOK)
+		ct.call(fiber.MethodDelete, "/admin/webhooks/"+hook.ID, nil, fiber.StatusNoContent)
+		ct.call(fiber.MethodDelete, "/admin/webhooks/"+hook.ID, nil, fiber.StatusNotFound)
+
+		services.Sinks.Register(sinks.NewDir(t.TempDir()))
+		services.Sinks.RegisterFactory("cloudinary", sinks.CloudinaryFactory(sinks.CloudinaryOptions{}))
+		ct.call(fiber.MethodPut, "/admin/sinks/policies/contract-share", models.SinkPolicy{Sinks: []string{"dir"}}, fiber.StatusOK)
+		ct.call(fiber.MethodPut, "/admin/sinks/policies/contract-share", models.SinkPolicy{Sinks: []string{"nowhere"}}, fiber.StatusBadRequest)
+		ct.call(fiber.MethodGet, "/admin/sinks", nil, fiber.StatusOK)
+		ct.call(fiber.MethodDelete, "/admin/sinks/policies/contract-share", nil, fiber.StatusNoContent)
+		ct.call(fiber.MethodDelete, "/admin/sinks/policies/contract-share", nil, fiber.StatusNotFound)
+
+		ct.call(fiber.MethodPost, "/admin/sinks/retry/contract-missing", nil, fiber.StatusNotFound)
+		ct.call(fiber.MethodPost, "/admin/sinks/retry/contract-up", nil, fiber.StatusConflict)
+		failSinkPush(t, "contract-up")
+		ct.call(fiber.MethodPost, "/admin/sinks/retry/contract-up", nil, fiber.StatusOK)
+
+		account := models.SinkCredential{Sink: "cloudinary", ShareID: "contract-share", Config: map[string]string{"cloud_name": "demo", "api_key": "key", "api_secret": "secret"}}
+		ct.call(fiber.MethodPost, "/admin/credentials", account, fiber.StatusServiceUnavailable)
+		ct.call(fiber.MethodPut, "/admin/tenants/acme", models.Tenant{ShareIDs: []string{"contract-share"}}, fiber.StatusServiceUnavailable)
+		ct.call(fiber.MethodDelete, "/admin/credentials/nothing", nil, fiber.StatusServiceUnavailable)
+		if err := services.Vault.Open(bytes.Repeat([]byte{7}, 32)); err != nil {
+			t.Fatal(err)
+		}
+		var cred models.SinkCredential
+		json.Unmarshal(ct.call(fiber.MethodPost, "/admin/credentials", account, fiber.StatusCreated), &cred)
+		ct.call(fiber.MethodPost, "/admin/credentials", models.SinkCredential{Sink: "cloudinary"}, fiber.StatusBadRequest)
+		ct.call(fiber.MethodPut, "/admin/tenants/acme", models.Tenant{ShareIDs: []string{"contract-share"}}, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/admin/credentials", nil, fiber.StatusOK)
+		ct.call(fiber.MethodDelete, "/admin/credentials/"+cred.ID, nil, fiber.StatusNoContent)
+		ct.call(fiber.MethodDelete, "/admin/credentials/"+cred.ID, nil, fiber.StatusNotFound)
+
+		ct.upload("contract-abort", "contract-share", testChunks, 0)
+		ct.upload("contract-force", "contract-share", testChunks, 0, 2)
+		ct.call(fiber.MethodGet, "/admin/shares", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/admin/uploads?share_id=contract-share&state=active", nil, fiber.StatusOK)
+		ct.call(fiber.MethodGet, "/admin/uploads?state=sideways", nil, fiber.StatusBadRequest)
+
+		ct.call(fiber.MethodPost, "/admin/uploads/contract-force/complete", nil, fiber.StatusOK)
+		ct.call(fiber.MethodPost, "/admin/uploads/contract-missing/complete", nil, fiber.StatusNotFound)
+		ct.call(fiber.MethodDelete, "/admin/uploads/contract-abort", nil, fiber.StatusNoContent)
+		ct.call(fiber.MethodDelete, "/admin/uploads/contract-abort", nil, fiber.StatusNotFound)
+		ct.call(fiber.MethodDelete, "/admin/uploads/contract-force", nil, fiber.StatusConflict)
+
+		ct.call(fiber.MethodDelete, "/admin/uploads/contract-up/clients", nil, fiber.StatusOK)
+		ct.call(fiber.MethodDelete, "/admin/rooms/contract-share/clients", nil, fiber.StatusOK)
+		ct.call(fiber.MethodPut, "/admin/rooms/contract-share/expiry", map[string]string{"extend_by": "1h"}, fiber.StatusOK)
+		ct.call(fiber.MethodPut, "/admin/rooms/contract-share/expiry", map[string]string{"expires_in": "1h", "extend_by": "1h"}, fiber.StatusBadRequest)
+		ct.call(fiber.MethodPut, "/admin/rooms/contract-nowhere/expiry", map[string]string{"expires_in": "1h"}, fiber.StatusNotFound)
+
+		ct.call(fiber.MethodGet, "/admin/storage", nil, fiber.StatusOK)
+		ct.call(fiber.MethodPost, "/admin/janitor", nil, fiber.StatusOK)
+	})
+
+	var missing []string
+	for path, item := range ct.doc.Paths.Map() {
+		for method, op := range item.Operations() {
+			if !ct.covered[op.OperationID] {
+				missing = append(missing, method+" "+path)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("operations never exercised: %v", missing)
+	}
+}
+
+// TestEveryRouteIsDocumented checks each /v1 route has an operation in the
+// document
+func TestEveryRouteIsDocumented(t *testing.T) {
+	ct := newContract(t)
+	param := regexp.MustCompile(`:(\w+)`)
+	for _, r := range ct.app.GetRoutes(true) {
+		if r.Method == fiber.MethodHead || !strings.HasPrefix(r.Path, middleware.APIPrefix+"/") {
+			continue
+		}
+		path := param.ReplaceAllString(strings.TrimPrefix(r.Path, middleware.APIPrefix), "{$1}")
+		item := ct.doc.Paths.Find(path)
+		if item == nil || item.GetOperation(r.Method) == nil {
+			t.Errorf("%s %s is not documented", r.Method, r.Path)
+		}
+	}
+}
+
+// failSinkPush records a failed push of uploadID to the dir sink, as the
+// sink worker would after its last attempt
+func failSinkPush(t *testing.T, uploadID string) {
+	t.Helper()
+	path := filepath.Join(config.StorageRoot, uploadID, "metadata.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md models.Metadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		t.Fatal(err)
+	}
+	md.Sinks = []models.SinkStatus{{Sink: "dir", Status: models.SinkFailed, Attempts: services.SinkMaxAttempts, LastError: "disk full", UpdatedAt: time.Now().UTC()}}
+	if b, err = json.Marshal(md); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,33 +3,92 @@ package routes
 import (
 	"aetherlink/config"
 	"aetherlink/controllers"
+	"aetherlink/middleware"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// SetupRoutes mounts the API twice: under /v1, where errors use the
+// {"error": {"code", "message", "details"}} envelope described by
+// /openapi.json, and unversioned for existing clients
 func SetupRoutes(app *fiber.App) {
-	app.Get("/health", controllers.HealthHandler)
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+	app.Get("/openapi.json", OpenAPIHandler)
 
-	app.Post("/init", controllers.InitHandler)
-	app.Put("/upload/:uploadID/:idx", controllers.UploadHandler)
-	app.Get("/status/:uploadID", controllers.StatusHandler)
-	app.Post("/complete/:uploadID", controllers.CompleteHandler)
+	apiRoutes(app.Group(middleware.APIPrefix))
+	apiRoutes(app)
 
-	app.Delete("/cleanup/:uploadID", controllers.CleanupHandler)
+	// Public static files (legacy - consider deprecating for security)
+	app.Static("/static", config.StorageRoot)
+}
+
+// apiRoutes registers the endpoints of the API on r
+func apiRoutes(r fiber.Router) {
+	r.Get("/health", controllers.HealthHandler)
+
+	// Upload lifecycle requests share one trace per upload
+	traced := middleware.Tracing()
+	// Upload session routes are served by the owning node in cluster mode
+	owner := middleware.ClusterForward()
+	// Init and chunk writes are admitted by X-Priority
+	sched := middleware.PriorityScheduler()
+	// Chunk responses are held back to stay within bandwidth limits
+	throttle := middleware.IngestThrottle()
+	// Storage writes finish before shutdown; new ones are refused while draining
+	drain := middleware.Drain()
+
+	r.Post("/init", traced, owner, drain, sched, controllers.InitHandler)
+	r.Put("/upload/:uploadID/:idx", traced, owner, drain, throttle, sched, controllers.UploadHandler)
+	r.Get("/status/:uploadID", traced, owner, controllers.StatusHandler)
+	r.Post("/complete/:uploadID", traced, owner, drain, controllers.CompleteHandler)
+
+	r.Delete("/cleanup/:uploadID", owner, controllers.CleanupHandler)
+
+	// Client telemetry is stored next to the owning node's measurements;
+	// aggregates are for operators tuning adaptive defaults
+	r.Post("/telemetry/:uploadID", owner, controllers.TelemetryHandler)
+	r.Get("/telemetry", middleware.AdminAuth(), controllers.TelemetryAggregateHandler)
 
 	// File listing and info endpoints (require share_id)
-	app.Get("/files", controllers.FilesHandler)
-	app.Get("/file/:uploadID", controllers.FileInfoHandler)
+	r.Get("/files", controllers.FilesHandler)
+	r.Get("/file/:uploadID", owner, controllers.FileInfoHandler)
 
 	// Secure download endpoint (requires share_id)
-	app.Get("/download/:uploadID/:filename", controllers.SecureDownloadHandler)
+	r.Get("/download/:uploadID/:filename", owner, controllers.SecureDownloadHandler)
 
 	// Room endpoints for multi-user support
-	app.Get("/room/:shareId", controllers.RoomHandler)
-	app.Get("/room/:shareId/events", controllers.RoomSSEHandler)
+	r.Get("/room/:shareId", controllers.RoomHandler)
+	r.Get("/room/:shareId/events", controllers.RoomSSEHandler)
 
-	app.Get("/events/:uploadID", controllers.SSEHandler)
+	// Only the owning node sees an upload's chunks arrive
+	r.Get("/events/:uploadID", middleware.ClusterRedirect(), controllers.SSEHandler)
+
+	// Operator endpoints (require ADMIN_TOKEN bearer auth)
+	admin := r.Group("/admin", middleware.AdminAuth())
+	admin.Get("/throttle", controllers.GetThrottleHandler)
+	admin.Put("/throttle", controllers.UpdateThrottleHandler)
+	admin.Get("/webhooks", controllers.ListWebhooksHandler)
+	admin.Post("/webhooks", controllers.CreateWebhookHandler)
+	admin.Get("/webhooks/deliveries", controllers.WebhookDeliveriesHandler)
+	admin.Delete("/webhooks/:id", controllers.DeleteWebhookHandler)
+	admin.Get("/sinks", controllers.ListSinksHandler)
+	admin.Put("/sinks/policies/:shareID", controllers.SetSinkPolicyHandler)
+	admin.Delete("/sinks/policies/:shareID", controllers.DeleteSinkPolicyHandler)
+	admin.Post("/sinks/retry/:uploadID", controllers.RetrySinksHandler)
+	admin.Get("/credentials", controllers.ListCredentialsHandler)
+	admin.Post("/credentials", controllers.CreateCredentialHandler)
+	admin.Delete("/credentials/:id", controllers.DeleteCredentialHandler)
+	admin.Put("/tenants/:tenant", controllers.SetTenantHandler)
+	admin.Get("/shares", controllers.ListSharesHandler)
+	admin.Get("/uploads", controllers.ListUploadsHandler)
+	admin.Post("/uploads/:uploadID/complete", owner, drain, controllers.ForceCompleteHandler)
+	admin.Delete("/uploads/:uploadID", owner, controllers.AbortUploadHandler)
+	admin.Delete("/uploads/:uploadID/clients", controllers.KickUploadClientsHandler)
+	admin.Put("/rooms/:shareID/expiry", controllers.SetRoomExpiryHandler)
+	admin.Delete("/rooms/:shareID/clients", controllers.KickRoomClientsHandler)
+	admin.Get("/storage", controllers.StorageHandler)
+	admin.Post("/janitor", controllers.RunJanitorHandler)
 
-	// Public static files (legacy - consider deprecating for security)
-	app.Static("/static", config.StorageRoot)
 }
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+)
+
+var (
+	// ErrUploadNotFound means no upload directory has the given ID
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadCompleted means the upload was already assembled
+	ErrUploadCompleted = errors.New("upload already completed")
+)
+
+// ListUploads describes every stored upload, or those of one share, newest
+// first. Directories without readable metadata are skipped; fsck reports them.
+func ListUploads(shareID string) ([]models.UploadSummary, error) {
+	entries, err := os.ReadDir(config.StorageRoot)
+	if err != nil {
+		return nil, err
+	}
+	uploads := []models.UploadSummary{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		summary, ok := summarizeUpload(entry.Name())
+		if !ok || (shareID != "" && summary.ShareID != shareID) {
+			continue
+		}
+		uploads = append(uploads, summary)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].UpdatedAt.After(uploads[j].UpdatedAt) })
+	return uploads, nil
+}
+
+// ShareExists reports whether a room is tracked for shareID or a stored
+// upload belongs to it
+func ShareExists(shareID string) (bool, error) {
+	if Room.HasRoom(shareID) {
+		return true, nil
+	}
+	uploads, err := ListUploads(shareID)
+	return len(uploads) > 0, err
+}
+
+// ListShares aggregates stored uploads by share, with each room's expiry
+func ListShares() ([]models.ShareSummary, error) {
+	uploads, err := ListUploads("")
+	if err != nil {
+		return nil, err
+	}
+	byShare := map[string]*models.ShareSummary{}
+	for _, u := range uploads {
+		s, ok := byShare[u.ShareID]
+		if !ok {
+			s = &models.ShareSummary{ShareID: u.ShareID}
+			byShare[u.ShareID] = s
+		}
+		s.Uploads++
+		s.StoredBytes += u.StoredBytes
+		if u.State == models.UploadCompleted {
+			s.Completed++
+			s.QuotaBytes += u.StoredBytes
+		} else {
+			s.Active++
+			s.QuotaBytes += u.DeclaredBytes
+		}
+	}
+
+	now := time.Now()
+	shares := make([]models.ShareSummary, 0, len(byShare))
+	for _, s := range byShare {
+		s.ExpiresAt = Room.GetRoomExpiry(s.ShareID)
+		s.Expired = s.ExpiresAt.Before(now)
+		s.SSEClients = EventLog.Subscribers(RoomStream(s.ShareID))
+		shares = append(shares, *s)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].ShareID < shares[j].ShareID })
+	return shares, nil
+}
+
+// MergeShares adds the shares another node listed. A share with uploads on
+// several nodes is counted across all of them, and its room expires with
+// the last one.
+func MergeShares(shares, more []models.ShareSummary) []models.ShareSummary {
+	index := make(map[string]int, len(shares))
+	for i, s := range shares {
+		index[s.ShareID] = i
+	}
+	for _, m := range more {
+		i, ok := index[m.ShareID]
+		if !ok {
+			index[m.ShareID] = len(shares)
+			shares = append(shares, m)
+			continue
+		}
+		s := &shares[i]
+		s.Uploads += m.Uploads
+		s.Active += m.Active
+		s.Completed += m.Completed
+		s.QuotaBytes += m.QuotaBytes
+		s.StoredBytes += m.StoredBytes
+		s.SSEClients += m.SSEClients
+		if m.ExpiresAt.After(s.ExpiresAt) {
+			s.ExpiresAt = m.ExpiresAt
+		}
+		s.Expired = s.Expired && m.Expired
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].ShareID < shares[j].ShareID })
+	return shares
+}
+
+func summarizeUpload(uploadID string) (models.UploadSummary, bool) {
+	dir := filepath.Join(config.StorageRoot, uploadID)
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return models.UploadSummary{}, false
+	}
+	var md models.Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return models.UploadSummary{}, false
+	}
+
+	summary := models.UploadSummary{
+		UploadID:      uploadID,
+		ShareID:       md.ShareID,
+		Filename:      md.Filename,
+		State:         models.UploadActive,
+		Priority:      md.Priority,
+		TotalChunks:   md.TotalChunks,
+		DeclaredBytes: md.ExpectedBytes(),
+		SSEClients:    EventLog.Subscribers(UploadStream(uploadID)),
+		Sinks:         md.Sinks,
+	}
+	if info, err := os.Stat(dir); err == nil {
+		summary.UpdatedAt = info.ModTime()
+	}
+	if files, err := os.ReadDir(dir); err == nil {
+		for _, f := range files {
+			if info, err := f.Info(); err == nil && info.Mode().IsRegular() {
+				summary.StoredBytes += info.Size()
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, md.Filename)); err == nil {
+		summary.State = models.UploadCompleted
+		summary.ReceivedChunks = md.TotalChunks
+	} else {
+		received, _ := helpers.ReadReceivedChunks(dir)
+		summary.ReceivedChunks = len(helpers.DataChunks(received, md.TotalChunks))
+	}
+	return summary, true
+}
+
+// AbortUpload deletes an incomplete upload, releases what it held and tells
+// its room why it failed
+func AbortUpload(uploadID, reason string) (models.Metadata, error) {
+	var md models.Metadata
+	if uploadID == "" || uploadID == "." || uploadID == ".." || strings.ContainsAny(uploadID, `/\`) {
+		return md, ErrUploadNotFound
+	}
+	dir := filepath.Join(config.StorageRoot, uploadID)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return md, ErrUploadNotFound
+	}
+
+	if mdBytes, err := os.ReadFile(filepath.Join(dir, "metadata.json")); err == nil {
+		if json.Unmarshal(mdBytes, &md) == nil {
+			if _, err := os.Stat(filepath.Join(dir, md.Filename)); err == nil {
+				return md, ErrUploadCompleted
+			}
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return md, err
+	}
+
+	Capacity.Release(uploadID)
+	Advisor.Forget(uploadID)
+	Throttle.Forget(uploadID)
+	Telemetry.Forget(uploadID)
+	EventLog.Drop(UploadStream(uploadID))
+	if md.ShareID != "" {
+		Room.NotifyUploadFailed(md.ShareID, uploadID, md.Filename, reason)
+	}
+	return md, nil
+}
+
+// RunJanitor runs the periodic maintenance passes now: expired rooms are
+// announced, their storage reservations released, idle event streams
+// dropped from memory and the event logs of finished uploads deleted
+func RunJanitor() models.JanitorReport {
+	return models.JanitorReport{
+		ExpiredRooms:         Room.ExpireRooms(),
+		ReleasedReservations: Capacity.ReleaseExpired(),
+		EvictedStreams:       EventLog.EvictIdle(),
+		PrunedEventLogs:      EventLog.PruneUploads(UploadFinished),
+	}
+}
+
+// UploadFinished reports whether an upload's event log is no longer needed:
+// the upload was completed or its room expired, or it is gone from storage
+// and its log was last written over an hour ago
+func UploadFinished(uploadID string, logModified time.Time) bool {
+	dir := filepath.Join(config.StorageRoot, uploadID)
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return os.IsNotExist(err) && time.Since(logModified) > eventStreamIdle
+	}
+	var md models.Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return false
+	}
+	if md.Filename != "" {
+		if _, err := os.Stat(filepath.Join(dir, md.Filename)); err == nil {
+			return true
+		}
+	}
+	return Room.GetRoomExpiry(md.ShareID).Before(time.Now())
+}
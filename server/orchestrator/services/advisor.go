@@ -0,0 +1,123 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/models"
+)
+
+const (
+	// DefaultMaxWorkers is advised when the server is not under pressure
+	DefaultMaxWorkers = 8
+	// MinAdvisedChunkSize and MaxAdvisedChunkSize bound the suggested chunk size
+	MinAdvisedChunkSize = 256 << 10
+	MaxAdvisedChunkSize = 16 << 20
+
+	// targetChunkSeconds is how long one worker should spend on a chunk
+	targetChunkSeconds = 2.0
+	// slowWriteLatency marks disk writes that warrant throttling clients
+	slowWriteLatency = 250 * time.Millisecond
+	// ewmaAlpha weights new samples in the moving averages
+	ewmaAlpha = 0.2
+)
+
+// AdvisorService measures per-upload ingest and turns it into chunk size,
+// concurrency and backoff recommendations for the client
+type AdvisorService struct {
+	mu      sync.Mutex
+	uploads map[string]*ingestStats
+}
+
+type ingestStats struct {
+	lastArrival  time.Time
+	ingestRate   float64 // bytes/second across all workers
+	writeLatency float64 // seconds
+}
+
+var Advisor = &AdvisorService{
+	uploads: make(map[string]*ingestStats),
+}
+
+// RecordChunk records a chunk of n bytes that took writeDur to persist
+func (as *AdvisorService) RecordChunk(uploadID string, n int64, writeDur time.Duration) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	now := time.Now()
+	st, ok := as.uploads[uploadID]
+	if !ok {
+		as.uploads[uploadID] = &ingestStats{lastArrival: now, writeLatency: writeDur.Seconds()}
+		return
+	}
+	dt := math.Max(now.Sub(st.lastArrival).Seconds(), 0.001)
+	st.ingestRate = ewma(st.ingestRate, float64(n)/dt)
+	st.writeLatency = ewma(st.writeLatency, writeDur.Seconds())
+	st.lastArrival = now
+}
+
+// Forget drops the measurements of a finished upload
+func (as *AdvisorService) Forget(uploadID string) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	delete(as.uploads, uploadID)
+}
+
+// Advise returns the current recommendation for an upload. Until enough
+// chunks have arrived the current chunk size is echoed back.
+func (as *AdvisorService) Advise(uploadID string, currentChunkSize int64) models.ChunkAdvice {
+	as.mu.Lock()
+	var rate, latency float64
+	if st, ok := as.uploads[uploadID]; ok {
+		rate, latency = st.ingestRate, st.writeLatency
+	}
+	as.mu.Unlock()
+
+	advice := models.ChunkAdvice{
+		ChunkSize:         currentChunkSize,
+		MaxWorkers:        DefaultMaxWorkers,
+		IngestBytesPerSec: math.Round(rate),
+		WriteLatencyMs:    math.Round(latency*1000*10) / 10,
+	}
+
+	// Back off when requests are queueing for admission slots
+	sched := Scheduler.Stats()
+	queued := 0
+	for _, n := range sched.Queued {
+		queued += n
+	}
+	switch {
+	case queued > 0:
+		advice.MaxWorkers = 2
+		advice.BackoffMs = int64(math.Min(5000, float64(100*queued)))
+	case sched.Slots > 0 && sched.InUse*4 > sched.Slots*3:
+		advice.MaxWorkers = DefaultMaxWorkers / 2
+	}
+
+	// Slow disk writes mean more parallelism only adds contention
+	if latency > slowWriteLatency.Seconds() {
+		advice.MaxWorkers = max(1, advice.MaxWorkers/2)
+		advice.BackoffMs = max(advice.BackoffMs, int64(latency*1000))
+	}
+
+	if rate > 0 {
+		perWorker := rate / float64(advice.MaxWorkers)
+		advice.ChunkSize = roundChunkSize(perWorker * targetChunkSeconds)
+	}
+	return advice
+}
+
+// roundChunkSize rounds down to a power of two within the advised bounds
+func roundChunkSize(size float64) int64 {
+	limit := math.Min(MaxAdvisedChunkSize, float64(config.MaxUploadSize))
+	size = math.Max(MinAdvisedChunkSize, math.Min(size, limit))
+	return int64(1) << int(math.Floor(math.Log2(size)))
+}
+
+func ewma(prev, sample float64) float64 {
+	if prev == 0 {
+		return sample
+	}
+	return prev*(1-ewmaAlpha) + sample*ewmaAlpha
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+)
+
+var (
+	// ErrInsufficientStorage means the request does not fit in the free space
+	ErrInsufficientStorage = errors.New("insufficient storage")
+	// ErrStoragePaused means free space is below the low-watermark
+	ErrStoragePaused = errors.New("storage below low-watermark, new sessions paused")
+	// ErrInvalidSize means a reservation was asked for a negative size
+	ErrInvalidSize = errors.New("invalid reservation size")
+)
+
+// CapacityService tracks disk space promised to upload sessions. Each session
+// reserves its declared size at /init; the reservation shrinks as chunks are
+// written and is released on completion, cleanup or room expiry.
+type CapacityService struct {
+	mu           sync.Mutex
+	root         string
+	lowWatermark uint64
+	reservations map[string]*reservation // uploadID -> outstanding reservation
+}
+
+type reservation struct {
+	shareID   string
+	remaining int64 // declared bytes not yet written to disk
+}
+
+var Capacity = &CapacityService{
+	root:         config.StorageRoot,
+	lowWatermark: config.DefaultLowWatermark,
+	reservations: make(map[string]*reservation),
+}
+
+// SetRoot changes the storage directory whose free space is checked
+func (cs *CapacityService) SetRoot(root string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.root = root
+}
+
+// SetLowWatermark changes the free space below which new sessions are paused
+func (cs *CapacityService) SetLowWatermark(bytes uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.lowWatermark = bytes
+}
+
+// Reserve admits a new session and sets aside its declared size.
+// Re-initialising an upload replaces its previous reservation.
+func (cs *CapacityService) Reserve(uploadID, shareID string, size int64) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.reserveLocked(uploadID, shareID, size)
+}
+
+// ReserveSession admits a new upload session: it checks the share quotas and
+// reserves the declared size under one lock, so concurrent inits cannot all
+// pass a quota that only one of them fits in. Sessions that have reserved
+// space but not yet written metadata count against the quotas too.
+func (cs *CapacityService) ReserveSession(md models.Metadata) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	pending := make(map[string]int64)
+	for uploadID, r := range cs.reservations {
+		if r.shareID == md.ShareID && uploadID != md.UploadID {
+			pending[uploadID] = r.remaining
+		}
+	}
+	if err := Quota.CheckInit(md, pending); err != nil {
+		return err
+	}
+	return cs.reserveLocked(md.UploadID, md.ShareID, md.ExpectedBytes())
+}
+
+func (cs *CapacityService) reserveLocked(uploadID, shareID string, size int64) error {
+	if size < 0 {
+		return ErrInvalidSize
+	}
+	free, _, err := helpers.DiskSpace(cs.root)
+	if err != nil {
+		return err
+	}
+	available := cs.availableLocked(free, uploadID)
+	if available < cs.lowWatermark {
+		return ErrStoragePaused
+	}
+	if size > 0 && uint64(size) > available-cs.lowWatermark {
+		return ErrInsufficientStorage
+	}
+	cs.reservations[uploadID] = &reservation{shareID: shareID, remaining: size}
+	return nil
+}
+
+// Admit checks that n more bytes for an upload fit on disk. Bytes covered by
+// the upload's own reservation are always admitted.
+func (cs *CapacityService) Admit(uploadID string, n int64) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if r, ok := cs.reservations[uploadID]; ok && r.remaining >= n {
+		return nil
+	}
+	free, _, err := helpers.DiskSpace(cs.root)
+	if err != nil {
+		return err
+	}
+	if uint64(n) > cs.availableLocked(free, uploadID) {
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// Consume records n bytes written for an upload against its reservation
+func (cs *CapacityService) Consume(uploadID string, n int64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if r, ok := cs.reservations[uploadID]; ok {
+		r.remaining -= n
+		if r.remaining < 0 {
+			r.remaining = 0
+		}
+	}
+}
+
+// Release drops an upload's reservation
+func (cs *CapacityService) Release(uploadID string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.reservations, uploadID)
+}
+
+// ReleaseExpired drops reservations whose room has expired, along with the
+// ingest measurements of their uploads
+func (cs *CapacityService) ReleaseExpired() int {
+	cs.mu.Lock()
+	shares := make(map[string]string, len(cs.reservations))
+	for uploadID, r := range cs.reservations {
+		shares[uploadID] = r.shareID
+	}
+	cs.mu.Unlock()
+
+	released := 0
+	now := time.Now()
+	for uploadID, shareID := range shares {
+		if Room.GetRoomExpiry(shareID).Before(now) {
+			cs.Release(uploadID)
+			Advisor.Forget(uploadID)
+			Throttle.Forget(uploadID)
+			released++
+		}
+	}
+	return released
+}
+
+// StartReaper periodically releases reservations of expired rooms
+func (cs *CapacityService) StartReaper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n := cs.ReleaseExpired(); n > 0 {
+				slog.Info("released expired storage reservations", "event", "capacity_reap", "count", n)
+			}
+		}
+	}()
+}
+
+// Restore rebuilds reservations for incomplete uploads found on disk, so a
+// restart does not forget space promised to sessions in flight
+func (cs *CapacityService) Restore() error {
+	entries, err := os.ReadDir(cs.root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cs.root, entry.Name())
+		mdBytes, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+		if err != nil {
+			continue
+		}
+		var md models.Metadata
+		if err := json.Unmarshal(mdBytes, &md); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, md.Filename)); err == nil {
+			continue // already assembled
+		}
+		remaining := md.ExpectedBytes()
+		for i := 0; i < md.TotalUploadChunks(); i++ {
+			if info, err := os.Stat(helpers.ChunkPath(dir, i)); err == nil {
+				remaining -= info.Size()
+			}
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+		cs.mu.Lock()
+		cs.reservations[md.UploadID] = &reservation{shareID: md.ShareID, remaining: remaining}
+		cs.mu.Unlock()
+	}
+	return nil
+}
+
+// Usage reports free space, reservations and whether sessions are paused
+func (cs *CapacityService) Usage() (models.StorageUsage, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	free, total, err := helpers.DiskSpace(cs.root)
+	if err != nil {
+		return models.StorageUsage{}, err
+	}
+	available := cs.availableLocked(free, "")
+	return models.StorageUsage{
+		FreeBytes:      free,
+		TotalBytes:     total,
+		ReservedBytes:  cs.reservedLocked(""),
+		AvailableBytes: available,
+		LowWatermark:   cs.lowWatermark,
+		Reservations:   len(cs.reservations),
+		Paused:         available < cs.lowWatermark,
+	}, nil
+}
+
+// reservedLocked sums outstanding reservations, excluding one upload
+func (cs *CapacityService) reservedLocked(exclude string) uint64 {
+	var reserved uint64
+	for uploadID, r := range cs.reservations {
+		if uploadID != exclude {
+			reserved += uint64(r.remaining)
+		}
+	}
+	return reserved
+}
+
+// availableLocked returns free space not promised to other uploads
+func (cs *CapacityService) availableLocked(free uint64, exclude string) uint64 {
+	reserved := cs.reservedLocked(exclude)
+	if reserved >= free {
+		return 0
+	}
+	return free - reserved
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"aetherlink/config"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// HeaderForwardedBy marks a request already routed by a cluster peer
+	HeaderForwardedBy = "X-Aetherlink-Forwarded-By"
+	// HeaderForwardSignature proves HeaderForwardedBy: "<unix time>:<hex HMAC>"
+	HeaderForwardSignature = "X-Aetherlink-Forward-Signature"
+	// HeaderForwardedFor carries the address of the client a peer forwards for
+	HeaderForwardedFor = "X-Aetherlink-Forwarded-For"
+
+	// forwardMaxSkew bounds the age of a signed forward
+	forwardMaxSkew = 5 * time.Minute
+	peerTimeout    = 10 * time.Second
+)
+
+// ClusterService decides which orchestrator node owns an upload session.
+// Ownership uses rendezvous hashing over the static node list, so every node
+// agrees on the owner without coordination and only the sessions of a
+// removed node move when membership changes.
+type ClusterService struct {
+	self    config.ClusterNode
+	nodes   []config.ClusterNode
+	secret  []byte
+	peerIPs map[string]bool // addresses of the nodes, trusted without a secret
+	http    *http.Client
+}
+
+// Cluster is nil when the server runs as a single node
+var Cluster *ClusterService
+
+// InitCluster enables cluster mode with the given membership
+func InitCluster(settings config.ClusterSettings) {
+	cs := &ClusterService{
+		nodes:   settings.Nodes,
+		secret:  []byte(settings.Secret),
+		peerIPs: make(map[string]bool),
+		http:    &http.Client{Timeout: peerTimeout},
+	}
+	for _, n := range settings.Nodes {
+		if n.ID == settings.NodeID {
+			cs.self = n
+		}
+		u, err := url.Parse(n.URL)
+		if err != nil {
+			continue
+		}
+		ips, _ := net.LookupIP(u.Hostname())
+		for _, ip := range ips {
+			cs.peerIPs[ip.String()] = true
+		}
+	}
+	Cluster = cs
+}
+
+// Self returns this node
+func (cs *ClusterService) Self() config.ClusterNode {
+	return cs.self
+}
+
+// Owner returns the node that stores chunks and metadata for an upload
+func (cs *ClusterService) Owner(uploadID string) config.ClusterNode {
+	var owner config.ClusterNode
+	var best uint64
+	for i, n := range cs.nodes {
+		score := xxhash.Sum64String(n.ID + "/" + uploadID)
+		if i == 0 || score > best {
+			owner, best = n, score
+		}
+	}
+	return owner
+}
+
+// IsLocal reports whether this node owns the upload
+func (cs *ClusterService) IsLocal(uploadID string) bool {
+	return cs.Owner(uploadID).ID == cs.self.ID
+}
+
+// Peers returns the other nodes of the cluster
+func (cs *ClusterService) Peers() []config.ClusterNode {
+	peers := make([]config.ClusterNode, 0, len(cs.nodes)-1)
+	for _, n := range cs.nodes {
+		if n.ID != cs.self.ID {
+			peers = append(peers, n)
+		}
+	}
+	return peers
+}
+
+// SignForward returns the HeaderForwardSignature value for a request this
+// node sends to a peer on behalf of client ("" for the node's own requests)
+func (cs *ClusterService) SignForward(method, uri, client string) string {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	return ts + ":" + cs.forwardMAC(cs.self.ID, method, uri, client, ts)
+}
+
+// VerifyForward reports whether a request claiming to be forwarded by node
+// really comes from that peer. With a cluster secret the signature must
+// match and be recent; without one the request must come from the address
+// of a node.
+func (cs *ClusterService) VerifyForward(node, method, uri, client, signature, remoteIP string) bool {
+	if node == cs.self.ID || !cs.isMember(node) {
+		return false
+	}
+	if len(cs.secret) == 0 {
+		return cs.peerIPs[remoteIP]
+	}
+	ts, mac, ok := strings.Cut(signature, ":")
+	if !ok {
+		return false
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > forwardMaxSkew || age < -forwardMaxSkew {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(cs.forwardMAC(node, method, uri, client, ts)))
+}
+
+func (cs *ClusterService) forwardMAC(node, method, uri, client, ts string) string {
+	h := hmac.New(sha256.New, cs.secret)
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s", node, method, uri, client, ts)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (cs *ClusterService) isMember(id string) bool {
+	for _, n := range cs.nodes {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch GETs uri from a peer as a forwarded request, so the peer answers
+// from its own storage, and decodes the JSON response into out. A non-empty
+// authorization is passed on for the peer to check, e.g. on admin routes.
+func (cs *ClusterService) Fetch(ctx context.Context, node config.ClusterNode, uri, authorization string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.URL+uri, nil)
+	if err != nil {
+		return err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	req.Header.Set(HeaderForwardedBy, cs.self.ID)
+	req.Header.Set(HeaderForwardSignature, cs.SignForward(http.MethodGet, uri, ""))
+	resp, err := cs.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node %s answered %d", node.ID, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,438 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"aetherlink/bus"
+	"aetherlink/config"
+	"aetherlink/models"
+)
+
+// EventLogService keeps a bounded, persisted log of the SSE events of every
+// room and upload. Subscribers are only nudged when events are appended and
+// read what they missed from the log, so a slow or reconnecting client never
+// loses events that are still retained.
+type EventLogService struct {
+	mu      sync.Mutex
+	root    string
+	size    int
+	streams map[string]*eventStream // "room/<shareID>" or "upload/<uploadID>"
+	kicked  map[chan struct{}]bool  // subscribers told to disconnect
+
+	// Set when replicas share events over a bus
+	bus    bus.EventBus
+	node   string
+	detach func()
+}
+
+type eventStream struct {
+	events  []models.LoggedEvent // oldest first, at most size entries
+	unsaved []models.LoggedEvent // appended but not yet in the log file
+	lastID  uint64
+	subs    map[chan struct{}]struct{}
+	used    time.Time
+
+	fileMu sync.Mutex // serialises writes to the log file
+	onDisk int        // lines in the log file, trimmed when it doubles; guarded by fileMu
+}
+
+// eventStreamIdle is how long a stream without subscribers stays in memory;
+// evicted streams are reloaded from disk when used again
+const eventStreamIdle = time.Hour
+
+var EventLog = NewEventLog(config.EventRoot, config.EventLogSize)
+
+// NewEventLog creates an event log that keeps size events per stream and
+// persists them under root
+func NewEventLog(root string, size int) *EventLogService {
+	return &EventLogService{
+		root:    root,
+		size:    size,
+		streams: make(map[string]*eventStream),
+		kicked:  make(map[chan struct{}]bool),
+	}
+}
+
+// RoomStream names the event stream of a room
+func RoomStream(shareID string) string {
+	return "room/" + shareID
+}
+
+// UploadStream names the event stream of an upload
+func UploadStream(uploadID string) string {
+	return "upload/" + uploadID
+}
+
+// Attach shares this node's events with other replicas over b and appends
+// theirs to the local logs, so SSE clients see events from every node
+func (el *EventLogService) Attach(b bus.EventBus, node string) error {
+	detach, err := b.Subscribe(func(msg bus.Message) {
+		if msg.Node == node {
+			return // our own event, already logged
+		}
+		el.mu.Lock()
+		_, s := el.appendLocked(msg.Stream, msg.Key, msg.Data)
+		el.mu.Unlock()
+		el.persist(msg.Stream, s)
+	})
+	if err != nil {
+		return err
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.bus, el.node, el.detach = b, node, detach
+	return nil
+}
+
+// Detach stops sharing events with other replicas
+func (el *EventLogService) Detach() {
+	el.mu.Lock()
+	detach := el.detach
+	el.bus, el.detach = nil, nil
+	el.mu.Unlock()
+	if detach != nil {
+		detach()
+	}
+}
+
+// Append assigns the next ID of a stream to data, stores it, wakes the
+// stream's subscribers and publishes it to other replicas
+func (el *EventLogService) Append(stream, data string) models.LoggedEvent {
+	el.mu.Lock()
+	b := el.bus
+	key := ""
+	if b != nil {
+		key = el.node + ":" + strconv.FormatUint(el.streamLocked(stream).lastID+1, 10)
+	}
+	ev, s := el.appendLocked(stream, key, data)
+	el.mu.Unlock()
+	el.persist(stream, s)
+
+	// Published outside the lock, since in-process buses deliver synchronously
+	if b != nil {
+		if err := b.Publish(bus.Message{Node: el.node, Stream: stream, Key: key, Data: data}); err != nil {
+			slog.Warn("failed to publish event", "event", "event_bus", "stream", stream, "error", err)
+		}
+	}
+	return ev
+}
+
+// Resolve maps an SSE Last-Event-ID to a position in the local log. Keys of
+// events that are no longer retained resolve to 0, replaying everything.
+func (el *EventLogService) Resolve(stream, lastEventID string) uint64 {
+	if id, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		return id
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	for _, ev := range el.streamLocked(stream).events {
+		if ev.Key == lastEventID {
+			return ev.ID
+		}
+	}
+	return 0
+}
+
+// appendLocked adds an event to a stream in memory; the caller writes it to
+// disk with persist once el.mu is released
+func (el *EventLogService) appendLocked(stream, key, data string) (models.LoggedEvent, *eventStream) {
+	s := el.streamLocked(stream)
+	s.lastID++
+	ev := models.LoggedEvent{ID: s.lastID, Key: key, Data: data, Time: time.Now().UTC()}
+	s.events = append(s.events, ev)
+	if len(s.events) > el.size {
+		s.events = append(s.events[:0:0], s.events[len(s.events)-el.size:]...)
+	}
+	if _, ok := el.pathFor(stream); ok {
+		s.unsaved = append(s.unsaved, ev)
+	}
+
+	for ch := range s.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already has a wake-up pending
+		}
+	}
+	return ev, s
+}
+
+// Since returns the retained events after afterID. gap reports that events
+// following afterID were already evicted from the log.
+func (el *EventLogService) Since(stream string, afterID uint64) (events []models.LoggedEvent, gap bool) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	s := el.streamLocked(stream)
+	for _, ev := range s.events {
+		if ev.ID > afterID {
+			events = append(events, ev)
+		}
+	}
+	if len(s.events) > 0 && s.events[0].ID > afterID+1 {
+		gap = true
+	}
+	return events, gap
+}
+
+// LastID returns the ID of the newest event of a stream, 0 if none
+func (el *EventLogService) LastID(stream string) uint64 {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return el.streamLocked(stream).lastID
+}
+
+// Subscribe returns a channel that receives a wake-up after each append
+func (el *EventLogService) Subscribe(stream string) chan struct{} {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	el.streamLocked(stream).subs[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe removes a subscriber. The channel is never closed, so a
+// concurrent Append cannot panic.
+func (el *EventLogService) Unsubscribe(stream string, ch chan struct{}) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if s, ok := el.streams[stream]; ok {
+		delete(s.subs, ch)
+	}
+	delete(el.kicked, ch)
+}
+
+// Kick disconnects every subscriber of a stream: each gets a last wake-up
+// after which Kicked reports true. It returns the number of subscribers.
+func (el *EventLogService) Kick(stream string) int {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	s, ok := el.streams[stream]
+	if !ok {
+		return 0
+	}
+	n := len(s.subs)
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		el.kicked[ch] = true
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return n
+}
+
+// Kicked reports whether a subscriber was disconnected by Kick
+func (el *EventLogService) Kicked(ch chan struct{}) bool {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return el.kicked[ch]
+}
+
+// EvictIdle forgets streams without subscribers that were not used for an
+// hour; they are reloaded from disk when used again
+func (el *EventLogService) EvictIdle() int {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return el.evictIdleLocked(time.Now())
+}
+
+func (el *EventLogService) evictIdleLocked(now time.Time) int {
+	evicted := 0
+	for name, idle := range el.streams {
+		if len(idle.subs) == 0 && now.Sub(idle.used) > eventStreamIdle {
+			delete(el.streams, name)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Subscribers returns the number of subscribers of a stream
+func (el *EventLogService) Subscribers(stream string) int {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if s, ok := el.streams[stream]; ok {
+		return len(s.subs)
+	}
+	return 0
+}
+
+// Drop forgets a stream and deletes its log file
+func (el *EventLogService) Drop(stream string) {
+	el.mu.Lock()
+	s, ok := el.streams[stream]
+	if ok {
+		s.unsaved = nil
+		if len(s.subs) == 0 {
+			delete(el.streams, stream)
+		}
+	}
+	el.mu.Unlock()
+
+	path, hasPath := el.pathFor(stream)
+	if !hasPath {
+		return
+	}
+	if ok {
+		// Wait for a write in progress, which would recreate the file
+		s.fileMu.Lock()
+		defer s.fileMu.Unlock()
+		s.onDisk = 0
+	}
+	os.Remove(path)
+}
+
+// streamLocked returns a stream, loading its retained events from disk on
+// first use so IDs keep increasing across restarts
+func (el *EventLogService) streamLocked(stream string) *eventStream {
+	now := time.Now()
+	if s, ok := el.streams[stream]; ok {
+		s.used = now
+		return s
+	}
+	el.evictIdleLocked(now)
+	s := &eventStream{subs: make(map[chan struct{}]struct{}), used: now}
+	if path, ok := el.pathFor(stream); ok {
+		if f, err := os.Open(path); err == nil {
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(make([]byte, 64<<10), 4<<20)
+			for scanner.Scan() {
+				var ev models.LoggedEvent
+				if json.Unmarshal(scanner.Bytes(), &ev) != nil {
+					continue
+				}
+				s.onDisk++
+				s.events = append(s.events, ev)
+				if ev.ID > s.lastID {
+					s.lastID = ev.ID
+				}
+			}
+			f.Close()
+			if len(s.events) > el.size {
+				s.events = s.events[len(s.events)-el.size:]
+			}
+		}
+	}
+	el.streams[stream] = s
+	return s
+}
+
+// persist writes the unsaved events of a stream to its log file, rewriting
+// the file with only the retained events once it grows to twice the bound.
+// It runs outside el.mu so disk I/O never holds up other streams; fileMu
+// keeps the batches of one stream in order.
+func (el *EventLogService) persist(stream string, s *eventStream) {
+	path, ok := el.pathFor(stream)
+	if !ok {
+		return
+	}
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	el.mu.Lock()
+	batch := s.unsaved
+	s.unsaved = nil
+	var retained []models.LoggedEvent
+	if s.onDisk+len(batch) > 2*el.size {
+		retained = append(retained, s.events...)
+	}
+	el.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Error("failed to persist event", "event", "event_log", "stream", stream, "error", err)
+		return
+	}
+
+	if retained != nil {
+		var b strings.Builder
+		for _, e := range retained {
+			line, _ := json.Marshal(e)
+			b.Write(line)
+			b.WriteByte('\n')
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(b.String()), 0644); err == nil && os.Rename(tmp, path) == nil {
+			s.onDisk = len(retained)
+			return
+		}
+	}
+
+	var b []byte
+	for _, e := range batch {
+		line, _ := json.Marshal(e)
+		b = append(append(b, line...), '\n')
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		slog.Error("failed to persist event", "event", "event_log", "stream", stream, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err == nil {
+		s.onDisk += len(batch)
+	}
+}
+
+// PruneUploads deletes the logs of uploads for which finished reports true,
+// given the time each log was last written. Streams with subscribers are
+// kept. It returns the number of logs deleted.
+func (el *EventLogService) PruneUploads(finished func(uploadID string, modified time.Time) bool) int {
+	entries, err := os.ReadDir(filepath.Join(el.root, "upload"))
+	if err != nil {
+		return 0
+	}
+	pruned := 0
+	for _, entry := range entries {
+		uploadID, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		stream := UploadStream(uploadID)
+		if el.Subscribers(stream) > 0 || !finished(uploadID, info.ModTime()) {
+			continue
+		}
+		el.Drop(stream)
+		pruned++
+	}
+	return pruned
+}
+
+// StartPruner periodically deletes the logs of uploads for which finished
+// reports true
+func (el *EventLogService) StartPruner(interval time.Duration, finished func(uploadID string, modified time.Time) bool) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n := el.PruneUploads(finished); n > 0 {
+				slog.Info("pruned upload event logs", "event", "event_log_prune", "count", n)
+			}
+		}
+	}()
+}
+
+// pathFor maps a stream to its log file; IDs that could escape the event
+// root are kept in memory only
+func (el *EventLogService) pathFor(stream string) (string, bool) {
+	kind, id, ok := strings.Cut(stream, "/")
+	if !ok || id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", false
+	}
+	return filepath.Join(el.root, kind, id+".jsonl"), true
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"aetherlink/bus"
+	"aetherlink/models"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+)
+
+func TestEventLogReplaysAcrossReplicas(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		shared := bus.NewMemory()
+		testReplicaReplay(t, func(string) bus.EventBus { return shared })
+	})
+	t.Run("nats", func(t *testing.T) {
+		srv := natsserver.RunRandClientPortServer()
+		defer srv.Shutdown()
+		testReplicaReplay(t, func(node string) bus.EventBus {
+			b, err := bus.NewNATS(srv.ClientURL(), "", node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { b.Close() })
+			return b
+		})
+	})
+}
+
+// testReplicaReplay appends events on one replica and resumes a client on
+// the other from the Last-Event-ID the first one issued
+func testReplicaReplay(t *testing.T, connect func(node string) bus.EventBus) {
+	logs := make([]*EventLogService, 2)
+	for i, node := range []string{"a", "b"} {
+		logs[i] = NewEventLog(t.TempDir(), 16)
+		if err := logs[i].Attach(connect(node), node); err != nil {
+			t.Fatal(err)
+		}
+		defer logs[i].Detach()
+	}
+	first, second := logs[0], logs[1]
+
+	stream := UploadStream("replicated")
+	woken := second.Subscribe(stream)
+	defer second.Unsubscribe(stream, woken)
+
+	var sent []models.LoggedEvent
+	for i := 0; i < 3; i++ {
+		sent = append(sent, first.Append(stream, fmt.Sprintf(`{"n":%d}`, i)))
+	}
+
+	deadline := time.After(5 * time.Second)
+	for second.LastID(stream) < uint64(len(sent)) {
+		select {
+		case <-woken:
+		case <-deadline:
+			t.Fatalf("replica logged %d of %d events", second.LastID(stream), len(sent))
+		}
+	}
+
+	// The client saw the first event on one replica and reconnects to the other
+	after := second.Resolve(stream, sent[0].EventID())
+	if after == 0 {
+		t.Fatalf("replica does not know event %q", sent[0].EventID())
+	}
+	events, gap := second.Since(stream, after)
+	if gap {
+		t.Error("replay reports a gap")
+	}
+	if len(events) != len(sent)-1 {
+		t.Fatalf("replayed %d events, want %d", len(events), len(sent)-1)
+	}
+	for i, ev := range events {
+		want := sent[i+1]
+		if ev.EventID() != want.EventID() || ev.Data != want.Data {
+			t.Errorf("event %d = %s %s, want %s %s", i, ev.EventID(), ev.Data, want.EventID(), want.Data)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// LifecycleService tracks the requests that write to storage, so a shutdown
+// can stop admitting new ones and wait for chunk writes and assemblies that
+// already started instead of cutting them off mid-rename.
+type LifecycleService struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{} // closed when draining and nothing is in flight
+	shutdown chan struct{} // closed by Drain
+}
+
+var Lifecycle = &LifecycleService{
+	idle:     make(chan struct{}),
+	shutdown: make(chan struct{}),
+}
+
+// Begin admits a storage request; done must be called when it finishes.
+// ok is false once the server is draining.
+func (ls *LifecycleService) Begin() (done func(), ok bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.draining {
+		return nil, false
+	}
+	ls.inFlight++
+	var once sync.Once
+	return func() { once.Do(ls.end) }, true
+}
+
+func (ls *LifecycleService) end() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.inFlight--
+	if ls.draining && ls.inFlight == 0 {
+		close(ls.idle)
+	}
+}
+
+// Drain stops admitting storage requests and tells SSE streams to close
+func (ls *LifecycleService) Drain() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.draining {
+		return
+	}
+	ls.draining = true
+	close(ls.shutdown)
+	if ls.inFlight == 0 {
+		close(ls.idle)
+	}
+}
+
+// Draining reports whether Drain was called
+func (ls *LifecycleService) Draining() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.draining
+}
+
+// ShuttingDown is closed when the server starts draining
+func (ls *LifecycleService) ShuttingDown() <-chan struct{} {
+	return ls.shutdown
+}
+
+// InFlight returns the number of storage requests still running
+func (ls *LifecycleService) InFlight() int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.inFlight
+}
+
+// Wait blocks until every admitted request finished after Drain, or ctx ends
+func (ls *LifecycleService) Wait(ctx context.Context) error {
+	select {
+	case <-ls.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitGroup waits for wg, or until ctx ends
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"aetherlink/config"
+)
+
+// TestMain runs the tests in a scratch directory, since storage, event logs
+// and sink policies live under the working directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aetherlink-services")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(config.StorageRoot, 0755); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/models"
+)
+
+// SessionRetryAfter is the retry hint sent when a share has too many
+// concurrent sessions; the limit clears as soon as one of them completes
+const SessionRetryAfter = 30 * time.Second
+
+// QuotaError describes which per-share limit a new upload would exceed
+type QuotaError struct {
+	Quota      string // "share_bytes", "share_sessions" or "room_files"
+	Limit      int64
+	RetryAfter time.Duration // zero when waiting will not free the quota
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota %s exceeded (limit %d)", e.Quota, e.Limit)
+}
+
+// QuotaService enforces per-share limits on new upload sessions
+type QuotaService struct {
+	mu       sync.Mutex
+	settings config.QuotaSettings
+}
+
+var Quota = &QuotaService{}
+
+// Configure replaces the active quota settings
+func (qs *QuotaService) Configure(settings config.QuotaSettings) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.settings = settings
+}
+
+// Settings returns the active quota settings
+func (qs *QuotaService) Settings() config.QuotaSettings {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.settings
+}
+
+// ShareUsage summarises the uploads stored under a share
+type ShareUsage struct {
+	Bytes    int64 // declared size of incomplete uploads plus size of completed files
+	Sessions int   // incomplete uploads
+	Files    int   // all uploads
+
+	uploads map[string]bool // upload IDs found on disk
+}
+
+// CheckInit returns a *QuotaError if starting md would exceed a share quota.
+// pending holds the declared sizes of the share's sessions that may not have
+// written their metadata yet; those not found on disk are counted as well.
+// Re-initialising an existing upload does not count against the limits.
+func (qs *QuotaService) CheckInit(md models.Metadata, pending map[string]int64) error {
+	s := qs.Settings()
+	if s.ShareMaxBytes == 0 && s.ShareMaxSessions == 0 && s.RoomMaxFiles == 0 {
+		return nil
+	}
+
+	usage, err := GetShareUsage(md.ShareID, md.UploadID)
+	if err != nil {
+		return err
+	}
+	for uploadID, size := range pending {
+		if uploadID != md.UploadID && !usage.uploads[uploadID] {
+			usage.Files++
+			usage.Sessions++
+			usage.Bytes += size
+		}
+	}
+	if s.RoomMaxFiles > 0 && usage.Files+1 > s.RoomMaxFiles {
+		return &QuotaError{Quota: "room_files", Limit: int64(s.RoomMaxFiles)}
+	}
+	if s.ShareMaxSessions > 0 && usage.Sessions+1 > s.ShareMaxSessions {
+		return &QuotaError{Quota: "share_sessions", Limit: int64(s.ShareMaxSessions), RetryAfter: SessionRetryAfter}
+	}
+	if s.ShareMaxBytes > 0 && usage.Bytes+md.ExpectedBytes() > s.ShareMaxBytes {
+		return &QuotaError{Quota: "share_bytes", Limit: s.ShareMaxBytes}
+	}
+	return nil
+}
+
+// GetShareUsage scans storage for the uploads of a share, skipping one upload
+func GetShareUsage(shareID, excludeUploadID string) (ShareUsage, error) {
+	usage := ShareUsage{uploads: make(map[string]bool)}
+	entries, err := os.ReadDir(config.StorageRoot)
+	if err != nil {
+		return usage, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == excludeUploadID {
+			continue
+		}
+		dir := filepath.Join(config.StorageRoot, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+		if err != nil {
+			continue
+		}
+		var md models.Metadata
+		if err := json.Unmarshal(data, &md); err != nil || md.ShareID != shareID {
+			continue
+		}
+		usage.uploads[md.UploadID] = true
+		usage.Files++
+		if info, err := os.Stat(filepath.Join(dir, md.Filename)); err == nil {
+			usage.Bytes += info.Size()
+		} else {
+			usage.Sessions++
+			usage.Bytes += md.ExpectedBytes()
+		}
+	}
+	return usage, nil
+}
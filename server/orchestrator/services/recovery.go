@@ -0,0 +1,254 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"aetherlink/helpers"
+	"aetherlink/models"
+)
+
+// ScanStorage checks every upload directory below root for debris of
+// interrupted writes and reconciles chunk files against their .xxhash
+// sidecars, the expected hashes in metadata.json and received.json. With
+// repair set, debris and corrupt chunks are removed, sidecars rewritten and
+// received.json rebuilt; otherwise issues are only reported. It must not
+// run against a storage root that is being written to.
+func ScanStorage(root string, repair bool) (models.RecoveryReport, error) {
+	report := models.RecoveryReport{Repaired: repair, Issues: []models.StorageIssue{}}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return report, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		report.Uploads++
+		s := &uploadScan{
+			uploadID: entry.Name(),
+			dir:      filepath.Join(root, entry.Name()),
+			repair:   repair,
+		}
+		s.run()
+		report.Issues = append(report.Issues, s.issues...)
+	}
+	return report, nil
+}
+
+// uploadScan collects the issues of one upload directory
+type uploadScan struct {
+	uploadID string
+	dir      string
+	repair   bool
+	issues   []models.StorageIssue
+}
+
+// add records an issue, applying fix when repairing
+func (s *uploadScan) add(issue models.StorageIssue, fix func() error) {
+	issue.UploadID = s.uploadID
+	if s.repair && fix != nil {
+		if err := fix(); err != nil {
+			issue.Detail = strings.TrimSpace(issue.Detail + " (repair failed: " + err.Error() + ")")
+		} else {
+			issue.Fixed = true
+		}
+	}
+	s.issues = append(s.issues, issue)
+}
+
+func (s *uploadScan) remove(names ...string) func() error {
+	return func() error {
+		for _, name := range names {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (s *uploadScan) run() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		s.add(models.StorageIssue{Kind: models.IssueInvalidMetadata, Detail: err.Error()}, nil)
+		return
+	}
+	var md models.Metadata
+	mdBytes, err := os.ReadFile(filepath.Join(s.dir, "metadata.json"))
+	if err == nil {
+		err = json.Unmarshal(mdBytes, &md)
+	}
+	if err != nil {
+		// Without metadata nothing can be verified; an operator decides
+		s.add(models.StorageIssue{Kind: models.IssueInvalidMetadata, Path: "metadata.json", Detail: err.Error()}, nil)
+		return
+	}
+
+	chunks := map[int]bool{}
+	sidecars := map[int]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == md.Filename || name == "metadata.json" || name == "received.json" {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ".tmp"):
+			s.add(models.StorageIssue{Kind: models.IssueOrphanPart, Path: name}, s.remove(name))
+		case strings.HasPrefix(name, "chunk_"):
+			base, isSidecar := strings.CutSuffix(name, ".xxhash")
+			idx, err := strconv.Atoi(strings.TrimPrefix(base, "chunk_"))
+			if err != nil || idx < 0 {
+				continue
+			}
+			if isSidecar {
+				sidecars[idx] = true
+			} else {
+				chunks[idx] = true
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.dir, md.Filename)); err == nil {
+		s.assembled(chunks, sidecars)
+		return
+	}
+
+	valid := s.verifyChunks(md, chunks, sidecars)
+	s.reconcileReceived(valid)
+}
+
+// assembled finishes the chunk cleanup of an upload whose file was assembled
+func (s *uploadScan) assembled(chunks, sidecars map[int]bool) {
+	var leftovers []string
+	for idx := range chunks {
+		leftovers = append(leftovers, chunkName(idx))
+	}
+	for idx := range sidecars {
+		leftovers = append(leftovers, chunkName(idx)+".xxhash")
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "received.json")); err == nil {
+		leftovers = append(leftovers, "received.json")
+	}
+	if len(leftovers) == 0 {
+		return
+	}
+	sort.Strings(leftovers)
+	s.add(models.StorageIssue{
+		Kind:   models.IssueLeftoverChunks,
+		Detail: fmt.Sprintf("%d files left after assembly", len(leftovers)),
+	}, s.remove(leftovers...))
+}
+
+// verifyChunks re-hashes every chunk and returns the indices that hold
+// valid data. A chunk is checked against the hash the client declared at
+// /init, or its sidecar when none was declared.
+func (s *uploadScan) verifyChunks(md models.Metadata, chunks, sidecars map[int]bool) map[int]bool {
+	valid := map[int]bool{}
+	for _, idx := range sortedKeys(chunks) {
+		name := chunkName(idx)
+		chunk := idx
+		if idx >= md.TotalUploadChunks() {
+			s.add(models.StorageIssue{Kind: models.IssueStrayChunk, Path: name, Chunk: &chunk,
+				Detail: fmt.Sprintf("upload has %d chunks", md.TotalUploadChunks())}, s.remove(name, name+".xxhash"))
+			continue
+		}
+		actual, err := helpers.HashChunkFile(filepath.Join(s.dir, name))
+		if err != nil {
+			s.add(models.StorageIssue{Kind: models.IssueCorruptChunk, Path: name, Chunk: &chunk, Detail: err.Error()},
+				s.remove(name, name+".xxhash"))
+			continue
+		}
+		var sidecar string
+		if sidecars[idx] {
+			b, _ := os.ReadFile(filepath.Join(s.dir, name+".xxhash"))
+			sidecar = string(b)
+		}
+
+		expected := helpers.ExpectedChunkHash(md, idx)
+		if expected != "" && actual != expected {
+			s.add(models.StorageIssue{Kind: models.IssueCorruptChunk, Path: name, Chunk: &chunk,
+				Detail: fmt.Sprintf("hash %s, expected %s", actual, expected)}, s.remove(name, name+".xxhash"))
+			continue
+		}
+
+		// Chunks are renamed into place before their sidecar is written, so
+		// a missing or different sidecar is the one that is out of date
+		writeSidecar := func() error {
+			return os.WriteFile(filepath.Join(s.dir, name+".xxhash"), []byte(actual), 0644)
+		}
+		switch {
+		case !sidecars[idx]:
+			s.add(models.StorageIssue{Kind: models.IssueMissingHash, Path: name + ".xxhash", Chunk: &chunk}, writeSidecar)
+		case sidecar != actual:
+			s.add(models.StorageIssue{Kind: models.IssueStaleHash, Path: name + ".xxhash", Chunk: &chunk,
+				Detail: fmt.Sprintf("sidecar %q, content %q", sidecar, actual)}, writeSidecar)
+		}
+		valid[idx] = true
+	}
+
+	for _, idx := range sortedKeys(sidecars) {
+		if !chunks[idx] {
+			chunk := idx
+			name := chunkName(idx) + ".xxhash"
+			s.add(models.StorageIssue{Kind: models.IssueOrphanHash, Path: name, Chunk: &chunk}, s.remove(name))
+		}
+	}
+	return valid
+}
+
+// reconcileReceived rebuilds received.json from the valid chunks when the
+// two disagree
+func (s *uploadScan) reconcileReceived(valid map[int]bool) {
+	received, err := helpers.ReadReceivedChunks(s.dir)
+	recorded := map[int]bool{}
+	for _, idx := range received {
+		recorded[idx] = true
+	}
+
+	var issues []models.StorageIssue
+	for _, idx := range sortedKeys(valid) {
+		if !recorded[idx] {
+			chunk := idx
+			issues = append(issues, models.StorageIssue{Kind: models.IssueUnrecorded, Path: "received.json", Chunk: &chunk})
+		}
+	}
+	for _, idx := range sortedKeys(recorded) {
+		if !valid[idx] {
+			chunk := idx
+			issues = append(issues, models.StorageIssue{Kind: models.IssueMissingChunk, Path: "received.json", Chunk: &chunk})
+		}
+	}
+	if len(issues) == 0 && err == nil {
+		return
+	}
+	if err != nil {
+		issues = append(issues, models.StorageIssue{Kind: models.IssueInvalidReceived, Path: "received.json", Detail: err.Error()})
+	}
+
+	var fixErr error
+	if s.repair {
+		fixErr = helpers.WriteReceivedChunks(s.dir, sortedKeys(valid))
+	}
+	for _, issue := range issues {
+		s.add(issue, func() error { return fixErr })
+	}
+}
+
+func chunkName(idx int) string {
+	return filepath.Base(helpers.ChunkPath("", idx))
+}
+
+func sortedKeys(set map[int]bool) []int {
+	keys := make([]int, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -3,81 +3,53 @@ package services
 import (
 	"aetherlink/config"
 	"aetherlink/helpers"
+	"aetherlink/metrics"
 	"aetherlink/models"
 	"encoding/json"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 )
 
-const DefaultExpiryHours = 24
-
 type RoomService struct {
 	mu            sync.RWMutex
-	roomClients   map[string]map[chan string]struct{} // shareID -> set of channels
-	roomExpiryMap map[string]time.Time                // shareID -> expiresAt
+	expiry        time.Duration        // since the room's last upload started
+	roomExpiryMap map[string]time.Time // shareID -> expiresAt
+	expired       map[string]bool      // shareIDs already announced as expired
 }
 
 var Room = &RoomService{
-	roomClients:   make(map[string]map[chan string]struct{}),
+	expiry:        config.DefaultRoomExpiry,
 	roomExpiryMap: make(map[string]time.Time),
+	expired:       make(map[string]bool),
 }
 
-// AddRoomClient registers a new client for room-level broadcasts
-func (rs *RoomService) AddRoomClient(shareID string, ch chan string) {
-	rs.mu.Lock()
-	defer rs.mu.Unlock()
-	if _, ok := rs.roomClients[shareID]; !ok {
-		rs.roomClients[shareID] = make(map[chan string]struct{})
-	}
-	rs.roomClients[shareID][ch] = struct{}{}
+// AddRoomClient subscribes a client to room-level broadcasts
+func (rs *RoomService) AddRoomClient(shareID string) chan struct{} {
+	metrics.SSEClients.WithLabelValues(metrics.KindRoom).Inc()
+	return EventLog.Subscribe(RoomStream(shareID))
 }
 
-// RemoveRoomClient unregisters a room client
-func (rs *RoomService) RemoveRoomClient(shareID string, ch chan string) {
-	rs.mu.Lock()
-	defer rs.mu.Unlock()
-	delete(rs.roomClients[shareID], ch)
-	if len(rs.roomClients[shareID]) == 0 {
-		delete(rs.roomClients, shareID)
-	}
+// RemoveRoomClient unsubscribes a room client
+func (rs *RoomService) RemoveRoomClient(shareID string, ch chan struct{}) {
+	EventLog.Unsubscribe(RoomStream(shareID), ch)
+	metrics.SSEClients.WithLabelValues(metrics.KindRoom).Dec()
 }
 
-// BroadcastRoomEvent sends an event to all clients connected to a room
+// BroadcastRoomEvent appends an event to the room's event log, from which
+// every connected client is served
 func (rs *RoomService) BroadcastRoomEvent(event models.RoomEvent) {
 	event.Timestamp = time.Now()
 	data, err := json.Marshal(event)
 	if err != nil {
 		return
 	}
-
-	rs.mu.RLock()
-	clients := rs.roomClients[event.ShareID]
-	// Copy channels to avoid holding lock during iteration
-	channelsCopy := make([]chan string, 0, len(clients))
-	for ch := range clients {
-		channelsCopy = append(channelsCopy, ch)
-	}
-	rs.mu.RUnlock()
-
-	// Broadcast without holding the lock
-	for _, ch := range channelsCopy {
-		// Use recover to handle closed channels gracefully
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					// Channel was closed, ignore
-				}
-			}()
-			select {
-			case ch <- string(data):
-			default:
-				// avoid blocking
-			}
-		}()
-	}
+	// Lifecycle events also go to subscribed webhooks
+	Webhooks.Dispatch(event)
+	EventLog.Append(RoomStream(event.ShareID), string(data))
 }
 
 // GetRoomState builds the current state of a room
@@ -88,8 +60,9 @@ func (rs *RoomService) GetRoomState(shareID string) (*models.RoomState, error) {
 		return nil, err
 	}
 
-	var activeUploads []models.UploadInfo
-	var completedFiles []models.CompletedFile
+	// Empty lists, not null, for a share without uploads
+	activeUploads := []models.UploadInfo{}
+	completedFiles := []models.CompletedFile{}
 	var lastUpdated time.Time
 
 	for _, entry := range entries {
@@ -134,10 +107,12 @@ func (rs *RoomService) GetRoomState(shareID string) (*models.RoomState, error) {
 				Filename:    md.Filename,
 				FileSize:    fileInfo.Size(),
 				CompletedAt: modTime,
+				Forced:      md.Forced,
 			})
 		} else {
 			// Active upload
 			received, _ := helpers.ReadReceivedChunks(uploadDir)
+			received = helpers.DataChunks(received, md.TotalChunks)
 			completionPercent := 0
 			if md.TotalChunks > 0 {
 				completionPercent = (len(received) * 100) / md.TotalChunks
@@ -171,11 +146,39 @@ func (rs *RoomService) GetRoomState(shareID string) (*models.RoomState, error) {
 	}, nil
 }
 
+// SetExpiry changes the lifetime of rooms whose expiry is reset from now on
+func (rs *RoomService) SetExpiry(d time.Duration) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.expiry = d
+}
+
 // UpdateRoomExpiry resets the expiry timer for a room
 func (rs *RoomService) UpdateRoomExpiry(shareID string) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
-	rs.roomExpiryMap[shareID] = time.Now().Add(DefaultExpiryHours * time.Hour)
+	rs.roomExpiryMap[shareID] = time.Now().Add(rs.expiry)
+	delete(rs.expired, shareID)
+}
+
+// SetRoomExpiry moves a room's expiry, e.g. on an operator's request. A room
+// moved into the future can expire again; one moved into the past expires
+// on the next check.
+func (rs *RoomService) SetRoomExpiry(shareID string, expiresAt time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.roomExpiryMap[shareID] = expiresAt
+	if expiresAt.After(time.Now()) {
+		delete(rs.expired, shareID)
+	}
+}
+
+// HasRoom reports whether a room's expiry is being tracked
+func (rs *RoomService) HasRoom(shareID string) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	_, ok := rs.roomExpiryMap[shareID]
+	return ok
 }
 
 // GetRoomExpiry returns the expiry time for a room
@@ -185,8 +188,44 @@ func (rs *RoomService) GetRoomExpiry(shareID string) time.Time {
 	if expiresAt, ok := rs.roomExpiryMap[shareID]; ok {
 		return expiresAt
 	}
-	// Default: 24 hours from now
-	return time.Now().Add(DefaultExpiryHours * time.Hour)
+	// Default: a full lifetime from now
+	return time.Now().Add(rs.expiry)
+}
+
+// ExpireRooms announces rooms whose expiry has passed, once per expiry.
+// Expiry times are kept so reservations of expired rooms can be released.
+func (rs *RoomService) ExpireRooms() []string {
+	now := time.Now()
+	rs.mu.Lock()
+	expired := []string{}
+	for shareID, expiresAt := range rs.roomExpiryMap {
+		if expiresAt.Before(now) && !rs.expired[shareID] {
+			rs.expired[shareID] = true
+			expired = append(expired, shareID)
+		}
+	}
+	rs.mu.Unlock()
+
+	for _, shareID := range expired {
+		rs.BroadcastRoomEvent(models.RoomEvent{
+			Type:    models.EventRoomExpired,
+			ShareID: shareID,
+		})
+	}
+	return expired
+}
+
+// StartExpiryWatcher periodically announces expired rooms
+func (rs *RoomService) StartExpiryWatcher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if expired := rs.ExpireRooms(); len(expired) > 0 {
+				slog.Info("rooms expired", "event", "room_expired", "share_ids", expired)
+			}
+		}
+	}()
 }
 
 // NotifyUploadStart broadcasts upload start event
@@ -214,19 +253,107 @@ func (rs *RoomService) NotifyChunkReceived(shareID, uploadID string, received, t
 	})
 }
 
-// NotifyUploadComplete broadcasts upload complete event
-func (rs *RoomService) NotifyUploadComplete(shareID, uploadID, filename string, fileSize int64) {
+// NotifyUploadComplete broadcasts upload complete event; forced is set
+// when an operator forced the assembly
+func (rs *RoomService) NotifyUploadComplete(shareID, uploadID, filename string, fileSize int64, forced *models.ForcedCompletion) {
+	data := map[string]interface{}{
+		"file_size": fileSize,
+	}
+	if forced != nil {
+		data["forced"] = forced
+	}
 	rs.BroadcastRoomEvent(models.RoomEvent{
 		Type:     "upload_complete",
 		ShareID:  shareID,
 		UploadID: uploadID,
 		Filename: filename,
+		Data:     data,
+	})
+}
+
+// NotifyUploadFailed broadcasts that an upload was abandoned or could not be assembled
+func (rs *RoomService) NotifyUploadFailed(shareID, uploadID, filename, reason string) {
+	rs.BroadcastRoomEvent(models.RoomEvent{
+		Type:     models.EventUploadFailed,
+		ShareID:  shareID,
+		UploadID: uploadID,
+		Filename: filename,
+		Data: map[string]interface{}{
+			"reason": reason,
+		},
+	})
+}
+
+// NotifySinkProgress broadcasts how many bytes of a completed file have
+// reached a sink
+func (rs *RoomService) NotifySinkProgress(shareID, uploadID, filename, sink string, sent, total int64) {
+	percent := 100
+	if total > 0 {
+		percent = int(sent * 100 / total)
+	}
+	rs.BroadcastRoomEvent(models.RoomEvent{
+		Type:     models.EventSinkProgress,
+		ShareID:  shareID,
+		UploadID: uploadID,
+		Filename: filename,
+		Data: map[string]interface{}{
+			"sink":        sink,
+			"sent_bytes":  sent,
+			"total_bytes": total,
+			"percent":     percent,
+		},
+	})
+}
+
+// NotifySinkComplete broadcasts that a sink holds a copy of a file
+func (rs *RoomService) NotifySinkComplete(shareID, uploadID, filename, sink, url string) {
+	rs.BroadcastRoomEvent(models.RoomEvent{
+		Type:     models.EventSinkComplete,
+		ShareID:  shareID,
+		UploadID: uploadID,
+		Filename: filename,
+		Data: map[string]interface{}{
+			"sink": sink,
+			"url":  url,
+		},
+	})
+}
+
+// NotifySinkFailed broadcasts a failed push; retrying tells whether another
+// attempt is scheduled
+func (rs *RoomService) NotifySinkFailed(shareID, uploadID, filename, sink, reason string, retrying bool) {
+	rs.BroadcastRoomEvent(models.RoomEvent{
+		Type:     models.EventSinkFailed,
+		ShareID:  shareID,
+		UploadID: uploadID,
+		Filename: filename,
 		Data: map[string]interface{}{
-			"file_size": fileSize,
+			"sink":     sink,
+			"reason":   reason,
+			"retrying": retrying,
 		},
 	})
 }
 
+// RoomStateMessage builds a room_state event for a single client. It is not
+// logged, since a snapshot is only meaningful at the time it is sent.
+func (rs *RoomService) RoomStateMessage(shareID string) (string, bool) {
+	state, err := rs.GetRoomState(shareID)
+	if err != nil {
+		return "", false
+	}
+	data, err := json.Marshal(models.RoomEvent{
+		Type:      "room_state",
+		ShareID:   shareID,
+		Data:      state,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
 // NotifyRoomStateUpdate broadcasts full room state update
 func (rs *RoomService) NotifyRoomStateUpdate(shareID string) {
 	state, err := rs.GetRoomState(shareID)
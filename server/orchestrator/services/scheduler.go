@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/models"
+)
+
+// Priority classifies upload requests for admission
+type Priority int
+
+const (
+	PriorityBulk Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+var (
+	// ErrQueueFull means the request was shed because its queue is full
+	ErrQueueFull = errors.New("admission queue full")
+	// ErrQueueTimeout means no slot freed up within the maximum wait
+	ErrQueueTimeout = errors.New("timed out waiting for an admission slot")
+)
+
+// ParsePriority maps an X-Priority header value to a priority class.
+// Unknown or empty values are treated as normal.
+func ParsePriority(value string) Priority {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "emergency", "urgent", "critical", "high":
+		return PriorityHigh
+	case "bulk", "background", "low":
+		return PriorityBulk
+	default:
+		return PriorityNormal
+	}
+}
+
+// StoredPriority returns the priority an upload session was started with,
+// and false when the session or its priority is unknown
+func StoredPriority(uploadID string) (Priority, bool) {
+	data, err := os.ReadFile(filepath.Join(config.StorageRoot, uploadID, "metadata.json"))
+	if err != nil {
+		return PriorityNormal, false
+	}
+	var md models.Metadata
+	if err := json.Unmarshal(data, &md); err != nil || md.Priority == "" {
+		return PriorityNormal, false
+	}
+	return ParsePriority(md.Priority), true
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityBulk:
+		return "bulk"
+	default:
+		return "normal"
+	}
+}
+
+// SchedulerService admits init and chunk requests into a fixed number of
+// I/O slots. High-priority requests may use every slot, normal requests
+// leave the reserved slots free, and bulk requests are capped further.
+// Waiting requests are woken highest priority first.
+type SchedulerService struct {
+	mu       sync.Mutex
+	settings config.SchedulerSettings
+	inUse    int
+	queues   [3][]*admissionWaiter // indexed by Priority
+}
+
+type admissionWaiter struct {
+	ready chan struct{}
+}
+
+// SchedulerStats is a snapshot of slot usage and queue depth
+type SchedulerStats struct {
+	Slots  int            `json:"slots"`
+	InUse  int            `json:"in_use"`
+	Queued map[string]int `json:"queued"`
+}
+
+var Scheduler = NewSchedulerService(config.Defaults().Scheduler.Settings())
+
+// NewSchedulerService creates a scheduler with the given limits
+func NewSchedulerService(settings config.SchedulerSettings) *SchedulerService {
+	return &SchedulerService{settings: settings}
+}
+
+// Configure replaces the scheduler limits; queued requests are re-evaluated
+func (s *SchedulerService) Configure(settings config.SchedulerSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = settings
+	s.dispatchLocked()
+}
+
+// Acquire waits for a slot and returns a release func plus the time spent
+// queued. Bulk requests are shed first, since their queue fills first and
+// they are the last to be woken.
+func (s *SchedulerService) Acquire(p Priority) (func(), time.Duration, error) {
+	start := time.Now()
+	s.mu.Lock()
+	if s.queuedAtOrAboveLocked(p) == 0 && s.canRunLocked(p) {
+		s.inUse++
+		s.mu.Unlock()
+		return s.releaseFunc(), 0, nil
+	}
+	if len(s.queues[p]) >= s.settings.MaxQueue {
+		s.mu.Unlock()
+		return nil, 0, ErrQueueFull
+	}
+	w := &admissionWaiter{ready: make(chan struct{})}
+	s.queues[p] = append(s.queues[p], w)
+	maxWait := s.settings.MaxWait
+	s.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return s.releaseFunc(), time.Since(start), nil
+	case <-timer.C:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Granted while the timer fired
+		return s.releaseFunc(), time.Since(start), nil
+	default:
+	}
+	s.removeWaiterLocked(p, w)
+	return nil, time.Since(start), ErrQueueTimeout
+}
+
+// Stats returns current slot usage and queue depths
+func (s *SchedulerService) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queued := make(map[string]int, len(s.queues))
+	for p := range s.queues {
+		queued[Priority(p).String()] = len(s.queues[p])
+	}
+	return SchedulerStats{Slots: s.settings.Slots, InUse: s.inUse, Queued: queued}
+}
+
+// RetryAfter suggests how long a shed request should wait before retrying
+func (s *SchedulerService) RetryAfter(p Priority) time.Duration {
+	if p == PriorityBulk {
+		return 5 * time.Second
+	}
+	return time.Second
+}
+
+func (s *SchedulerService) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.inUse--
+			s.dispatchLocked()
+		})
+	}
+}
+
+func (s *SchedulerService) canRunLocked(p Priority) bool {
+	switch p {
+	case PriorityHigh:
+		return s.inUse < s.settings.Slots
+	case PriorityNormal:
+		return s.inUse < s.settings.Slots-s.settings.ReservedHigh
+	default:
+		return s.inUse < s.settings.BulkSlots
+	}
+}
+
+// queuedAtOrAboveLocked counts waiters that should be served before p
+func (s *SchedulerService) queuedAtOrAboveLocked(p Priority) int {
+	n := 0
+	for q := p; q <= PriorityHigh; q++ {
+		n += len(s.queues[q])
+	}
+	return n
+}
+
+// dispatchLocked hands free slots to waiters, highest priority first
+func (s *SchedulerService) dispatchLocked() {
+	for p := PriorityHigh; p >= PriorityBulk; p-- {
+		for len(s.queues[p]) > 0 && s.canRunLocked(p) {
+			w := s.queues[p][0]
+			s.queues[p] = s.queues[p][1:]
+			s.inUse++
+			close(w.ready)
+		}
+	}
+}
+
+func (s *SchedulerService) removeWaiterLocked(p Priority, w *admissionWaiter) {
+	q := s.queues[p]
+	for i, other := range q {
+		if other == w {
+			s.queues[p] = append(q[:i], q[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,557 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+	"aetherlink/sinks"
+)
+
+const (
+	// SinkMaxAttempts is how often a push is tried before it is failed
+	SinkMaxAttempts = 6
+	// sinkBaseBackoff doubles after every failed attempt, up to sinkMaxBackoff
+	sinkBaseBackoff = 30 * time.Second
+	sinkMaxBackoff  = time.Hour
+	sinkPutTimeout  = 10 * time.Minute
+	sinkPollEvery   = time.Second
+)
+
+var (
+	// ErrSinkPolicyNotFound means the share has no policy of its own
+	ErrSinkPolicyNotFound = errors.New("sink policy not found")
+	// ErrNoFailedSinks means an upload has no failed pushes to retry
+	ErrNoFailedSinks = errors.New("no failed sinks to retry")
+)
+
+// SinkService pushes assembled files to the sinks named by their share's
+// policy, or the default policy, using the share's or its tenant's account
+// from the Vault when it has one. Push state lives in each upload's
+// metadata.json, so pending retries survive restarts.
+type SinkService struct {
+	mu        sync.Mutex
+	root      string                   // policies
+	sinks     map[string]sinks.Sink    // process-wide accounts
+	factories map[string]sinks.Factory // build sinks from vault credentials
+	defaults  []string
+	policies  map[string][]string
+	queue     map[string]time.Time // uploadID -> next attempt of its earliest pending push
+	inFlight  map[string]bool
+	metaMu    sync.Mutex // serialises metadata.json rewrites
+	stop      chan struct{}
+	stopped   chan struct{}  // closed when the worker has returned
+	running   sync.WaitGroup // pushes in progress
+}
+
+var Sinks = NewSinkService(config.SinkRoot)
+
+// NewSinkService creates a sink service that keeps its policies under root
+func NewSinkService(root string) *SinkService {
+	return &SinkService{
+		root:      root,
+		sinks:     make(map[string]sinks.Sink),
+		factories: make(map[string]sinks.Factory),
+		policies:  make(map[string][]string),
+		queue:     make(map[string]time.Time),
+		inFlight:  make(map[string]bool),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// Register makes a sink available to policies
+func (ss *SinkService) Register(s sinks.Sink) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sinks[s.Name()] = s
+}
+
+// RegisterFactory lets vault credentials of a sink type build their own sinks
+func (ss *SinkService) RegisterFactory(name string, factory sinks.Factory) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.factories[name] = factory
+}
+
+// build creates a sink from an account's settings
+func (ss *SinkService) build(name string, config map[string]string) (sinks.Sink, error) {
+	ss.mu.Lock()
+	factory, ok := ss.factories[name]
+	ss.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("sink %q does not take credentials", name)
+	}
+	return factory(config)
+}
+
+// SetDefault sets the sinks used for shares without a policy of their own
+func (ss *SinkService) SetDefault(names []string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if err := ss.validateLocked(names); err != nil {
+		return err
+	}
+	ss.defaults = slices.Clone(names)
+	return nil
+}
+
+// Names returns the sinks policies can name: those with a process-wide
+// account and those that take vault credentials
+func (ss *SinkService) Names() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	names := make([]string, 0, len(ss.sinks)+len(ss.factories))
+	for name := range ss.sinks {
+		names = append(names, name)
+	}
+	for name := range ss.factories {
+		if _, ok := ss.sinks[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Default returns the sinks used for shares without a policy of their own
+func (ss *SinkService) Default() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return append([]string{}, ss.defaults...)
+}
+
+// Policies returns the per-share policies
+func (ss *SinkService) Policies() []models.SinkPolicy {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	policies := make([]models.SinkPolicy, 0, len(ss.policies))
+	for shareID, names := range ss.policies {
+		policies = append(policies, models.SinkPolicy{ShareID: shareID, Sinks: slices.Clone(names)})
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].ShareID < policies[j].ShareID })
+	return policies
+}
+
+// SetPolicy replaces a share's sinks; an empty list disables pushes for it
+func (ss *SinkService) SetPolicy(p models.SinkPolicy) error {
+	if p.ShareID == "" {
+		return errors.New("share_id is required")
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if err := ss.validateLocked(p.Sinks); err != nil {
+		return err
+	}
+	if p.Sinks == nil {
+		p.Sinks = []string{}
+	}
+	ss.policies[p.ShareID] = slices.Clone(p.Sinks)
+	return ss.savePoliciesLocked()
+}
+
+// RemovePolicy returns a share to the default policy
+func (ss *SinkService) RemovePolicy(shareID string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if _, ok := ss.policies[shareID]; !ok {
+		return ErrSinkPolicyNotFound
+	}
+	delete(ss.policies, shareID)
+	return ss.savePoliciesLocked()
+}
+
+func (ss *SinkService) validateLocked(names []string) error {
+	for _, name := range names {
+		_, global := ss.sinks[name]
+		_, byCredential := ss.factories[name]
+		if !global && !byCredential {
+			return fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	return nil
+}
+
+func (ss *SinkService) policyLocked(shareID string) []string {
+	if names, ok := ss.policies[shareID]; ok {
+		return names
+	}
+	return ss.defaults
+}
+
+// Load restores the per-share policies and requeues the pending pushes of
+// uploads found in storage
+func (ss *SinkService) Load() error {
+	var policies []models.SinkPolicy
+	if err := readJSONFile(filepath.Join(ss.root, "policies.json"), &policies); err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, p := range policies {
+		ss.policies[p.ShareID] = p.Sinks
+	}
+
+	entries, err := os.ReadDir(config.StorageRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		md, err := ss.readMetadata(e.Name())
+		if err != nil {
+			continue
+		}
+		if next, ok := nextSinkAttempt(md.Sinks); ok {
+			ss.queue[e.Name()] = next
+		}
+	}
+	return nil
+}
+
+// Enqueue records pending pushes of a just assembled file for every sink of
+// its share's policy. Sinks the file already reached are left alone, and
+// files assembled by a forced completion are not pushed.
+func (ss *SinkService) Enqueue(uploadID string) error {
+	ss.mu.Lock()
+	md, err := ss.readMetadata(uploadID)
+	if err != nil {
+		ss.mu.Unlock()
+		return err
+	}
+	names := slices.Clone(ss.policyLocked(md.ShareID))
+	ss.mu.Unlock()
+	if len(names) == 0 || md.Forced != nil {
+		// A forced assembly may not be the client's file; it stays local
+		return nil
+	}
+
+	now := time.Now().UTC()
+	md, err = ss.updateMetadata(uploadID, func(md *models.Metadata) {
+		for _, name := range names {
+			i := slices.IndexFunc(md.Sinks, func(s models.SinkStatus) bool { return s.Sink == name })
+			if i >= 0 && md.Sinks[i].Status == models.SinkUploaded {
+				continue
+			}
+			status := models.SinkStatus{Sink: name, Status: models.SinkPending, NextAttempt: &now, UpdatedAt: now}
+			if i >= 0 {
+				md.Sinks[i] = status
+			} else {
+				md.Sinks = append(md.Sinks, status)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	ss.schedule(uploadID, md.Sinks)
+	return nil
+}
+
+// Retry resets an upload's failed pushes so they are tried again
+func (ss *SinkService) Retry(uploadID string) (models.Metadata, error) {
+	now := time.Now().UTC()
+	retried := 0
+	md, err := ss.updateMetadata(uploadID, func(md *models.Metadata) {
+		for i := range md.Sinks {
+			if md.Sinks[i].Status == models.SinkFailed {
+				md.Sinks[i].Status = models.SinkPending
+				md.Sinks[i].Attempts = 0
+				md.Sinks[i].NextAttempt = &now
+				md.Sinks[i].UpdatedAt = now
+				retried++
+			}
+		}
+	})
+	if err != nil {
+		return md, err
+	}
+	if retried == 0 {
+		return md, ErrNoFailedSinks
+	}
+	ss.schedule(uploadID, md.Sinks)
+	return md, nil
+}
+
+// Start runs the push worker until Stop is called
+func (ss *SinkService) Start() {
+	go func() {
+		defer close(ss.stopped)
+		ticker := time.NewTicker(sinkPollEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, uploadID := range ss.due() {
+					ss.running.Add(1)
+					go func(uploadID string) {
+						defer ss.running.Done()
+						ss.process(uploadID)
+					}(uploadID)
+				}
+			case <-ss.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the push worker and waits until ctx ends for pushes in progress
+// to record their outcome in metadata.json; pushes still running then are
+// left pending and resume on next start
+func (ss *SinkService) Stop(ctx context.Context) error {
+	close(ss.stop)
+	<-ss.stopped
+	return waitGroup(ctx, &ss.running)
+}
+
+func (ss *SinkService) schedule(uploadID string, statuses []models.SinkStatus) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if next, ok := nextSinkAttempt(statuses); ok {
+		ss.queue[uploadID] = next
+	} else {
+		delete(ss.queue, uploadID)
+	}
+}
+
+// due claims uploads whose earliest pending push has come
+func (ss *SinkService) due() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	now := time.Now()
+	due := []string{}
+	for uploadID, next := range ss.queue {
+		if !ss.inFlight[uploadID] && !next.After(now) {
+			ss.inFlight[uploadID] = true
+			due = append(due, uploadID)
+		}
+	}
+	return due
+}
+
+// process makes one attempt at each due push of an upload and records the
+// outcomes
+func (ss *SinkService) process(uploadID string) {
+	defer func() {
+		ss.mu.Lock()
+		delete(ss.inFlight, uploadID)
+		ss.mu.Unlock()
+	}()
+
+	md, err := ss.readMetadata(uploadID)
+	if err != nil {
+		// Upload deleted meanwhile
+		ss.mu.Lock()
+		delete(ss.queue, uploadID)
+		ss.mu.Unlock()
+		return
+	}
+	obj := sinks.Object{
+		UploadID: uploadID,
+		ShareID:  md.ShareID,
+		Filename: md.Filename,
+		Path:     filepath.Join(config.StorageRoot, uploadID, md.Filename),
+	}
+	if fi, err := os.Stat(obj.Path); err == nil {
+		obj.Size = fi.Size()
+	}
+
+	now := time.Now()
+	for _, status := range md.Sinks {
+		if status.Status != models.SinkPending || (status.NextAttempt != nil && status.NextAttempt.After(now)) {
+			continue
+		}
+		sink, account, ok, err := ss.resolve(md.ShareID, status.Sink)
+		obj.Progress = sinkProgress(md, status.Sink)
+		var res sinks.Result
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), sinkPutTimeout)
+			res, err = sink.Put(ctx, obj)
+			cancel()
+		}
+		md, err = ss.record(uploadID, status.Sink, account, res, err, ok)
+		if err != nil {
+			slog.Error("failed to record sink push", "event", "sink", "upload_id", uploadID, "sink", status.Sink, "error", err)
+			return
+		}
+	}
+	ss.schedule(uploadID, md.Sinks)
+}
+
+// resolve picks the account a share's files are pushed to: the share's or
+// its tenant's from the vault, else the process-wide one. configured is
+// false when neither exists, which no retry can fix.
+func (ss *SinkService) resolve(shareID, name string) (sink sinks.Sink, account string, configured bool, err error) {
+	sink, account, ok, err := Vault.SinkFor(shareID, name)
+	if ok {
+		return sink, account, true, err
+	}
+	ss.mu.Lock()
+	sink, ok = ss.sinks[name]
+	ss.mu.Unlock()
+	if !ok {
+		return nil, "", false, fmt.Errorf("sink %q is not configured", name)
+	}
+	return sink, "", true, nil
+}
+
+// record stores the outcome of one push attempt
+func (ss *SinkService) record(uploadID, name, account string, res sinks.Result, putErr error, configured bool) (models.Metadata, error) {
+	return ss.updateMetadata(uploadID, func(md *models.Metadata) {
+		i := slices.IndexFunc(md.Sinks, func(s models.SinkStatus) bool { return s.Sink == name })
+		if i < 0 {
+			return
+		}
+		s := &md.Sinks[i]
+		now := time.Now().UTC()
+		s.Attempts++
+		s.Account = account
+		s.UpdatedAt = now
+		logger := slog.With("event", "sink", "upload_id", uploadID, "share_id", md.ShareID,
+			"sink", name, "account", account, "attempt", s.Attempts)
+		switch {
+		case putErr == nil:
+			s.Status = models.SinkUploaded
+			s.URL, s.RemoteID = res.URL, res.RemoteID
+			s.LastError = ""
+			s.NextAttempt = nil
+			logger.Info("file pushed to sink", "url", res.URL)
+			Room.NotifySinkComplete(md.ShareID, uploadID, md.Filename, name, res.URL)
+		case !configured || s.Attempts >= SinkMaxAttempts:
+			s.Status = models.SinkFailed
+			s.LastError = putErr.Error()
+			s.NextAttempt = nil
+			logger.Warn("sink push failed permanently", "error", putErr)
+			Room.NotifySinkFailed(md.ShareID, uploadID, md.Filename, name, putErr.Error(), false)
+		default:
+			next := now.Add(sinkBackoff(s.Attempts))
+			s.LastError = putErr.Error()
+			s.NextAttempt = &next
+			logger.Warn("sink push failed, will retry", "error", putErr, "next_attempt", next)
+			Room.NotifySinkFailed(md.ShareID, uploadID, md.Filename, name, putErr.Error(), true)
+		}
+	})
+}
+
+// sinkProgressStep is the percentage of a push between progress events
+const sinkProgressStep = 5
+
+// sinkProgress returns a progress callback that broadcasts a room event
+// every sinkProgressStep percent
+func sinkProgress(md models.Metadata, sink string) func(sent, total int64) {
+	last := -1
+	return func(sent, total int64) {
+		percent := 100
+		if total > 0 {
+			percent = int(sent * 100 / total)
+		}
+		if percent < last+sinkProgressStep && percent < 100 || percent == last {
+			return
+		}
+		last = percent
+		Room.NotifySinkProgress(md.ShareID, md.UploadID, md.Filename, sink, sent, total)
+	}
+}
+
+// nextSinkAttempt returns when the earliest pending push is due
+func nextSinkAttempt(statuses []models.SinkStatus) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, s := range statuses {
+		if s.Status != models.SinkPending {
+			continue
+		}
+		at := time.Time{}
+		if s.NextAttempt != nil {
+			at = *s.NextAttempt
+		}
+		if !found || at.Before(next) {
+			next, found = at, true
+		}
+	}
+	return next, found
+}
+
+// sinkBackoff returns the wait before the attempt after the given one
+func sinkBackoff(attempts int) time.Duration {
+	backoff := sinkBaseBackoff
+	for i := 1; i < attempts && backoff < sinkMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, sinkMaxBackoff)
+}
+
+func (ss *SinkService) readMetadata(uploadID string) (models.Metadata, error) {
+	var md models.Metadata
+	if uploadID == "" || uploadID == "." || uploadID == ".." || strings.ContainsAny(uploadID, `/\`) {
+		return md, os.ErrNotExist
+	}
+	b, err := os.ReadFile(filepath.Join(config.StorageRoot, uploadID, "metadata.json"))
+	if err != nil {
+		return md, err
+	}
+	return md, json.Unmarshal(b, &md)
+}
+
+// UpdateMetadata applies fn to an upload's metadata and rewrites it,
+// serialised with the rewrites that record sink pushes
+func (ss *SinkService) UpdateMetadata(uploadID string, fn func(*models.Metadata)) (models.Metadata, error) {
+	return ss.updateMetadata(uploadID, fn)
+}
+
+// updateMetadata applies fn to an upload's metadata and rewrites it atomically
+func (ss *SinkService) updateMetadata(uploadID string, fn func(*models.Metadata)) (models.Metadata, error) {
+	ss.metaMu.Lock()
+	defer ss.metaMu.Unlock()
+	md, err := ss.readMetadata(uploadID)
+	if err != nil {
+		return md, err
+	}
+	fn(&md)
+	b, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return md, err
+	}
+	dir := filepath.Join(config.StorageRoot, uploadID)
+	path := filepath.Join(dir, "metadata.json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return md, err
+	}
+	// The directory's mtime is reported as the upload time, so keep it
+	dirInfo, statErr := os.Stat(dir)
+	if err := os.Rename(tmp, path); err != nil {
+		return md, err
+	}
+	if statErr == nil {
+		_ = os.Chtimes(dir, dirInfo.ModTime(), dirInfo.ModTime())
+	}
+	return md, nil
+}
+
+func (ss *SinkService) savePoliciesLocked() error {
+	policies := make([]models.SinkPolicy, 0, len(ss.policies))
+	for shareID, names := range ss.policies {
+		policies = append(policies, models.SinkPolicy{ShareID: shareID, Sinks: names})
+	}
+	return helpers.WriteJSONAtomic(filepath.Join(ss.root, "policies.json"), policies)
+}
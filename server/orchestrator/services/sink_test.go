@@ -0,0 +1,260 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/models"
+	"aetherlink/sinks"
+)
+
+// fakeCloudinary stands in for the Cloudinary upload API, refusing the
+// first `failures` requests
+type fakeCloudinary struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	files    map[string]string // public_id -> uploaded content
+	fields   []map[string]string
+}
+
+func (fc *fakeCloudinary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.requests++
+	if fc.requests <= fc.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "try again"}})
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fields := make(map[string]string)
+	for key, vals := range r.MultipartForm.Value {
+		fields[key] = vals[0]
+	}
+	fc.fields = append(fc.fields, fields)
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(f)
+	fc.files[fields["public_id"]] += string(data)
+	json.NewEncoder(w).Encode(map[string]string{
+		"public_id":     fields["folder"] + "/" + fields["public_id"],
+		"secure_url":    "https://res.example.com/" + fields["public_id"],
+		"resource_type": "raw",
+	})
+}
+
+// newCloudinarySinks returns a sink service pushing to a fake Cloudinary
+// configured through CLOUDINARY_UPLOAD_PREFIX, like the server is
+func newCloudinarySinks(t *testing.T, failures int) (*SinkService, *fakeCloudinary) {
+	fc := &fakeCloudinary{failures: failures, files: make(map[string]string)}
+	srv := httptest.NewServer(fc)
+	t.Cleanup(srv.Close)
+
+	t.Setenv(config.EnvCloudinaryName, "demo")
+	t.Setenv(config.EnvCloudinaryKey, "key")
+	t.Setenv(config.EnvCloudinarySecret, "secret")
+	t.Setenv(config.EnvCloudinaryUploadPrefix, srv.URL)
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InitCloudinary(cfg.Sinks.Cloudinary); err != nil {
+		t.Fatal(err)
+	}
+	return newSinkService(t, sinks.NewCloudinary(config.Cloudinary, sinks.CloudinaryOptions{})), fc
+}
+
+func newSinkService(t *testing.T, sink sinks.Sink) *SinkService {
+	ss := NewSinkService(t.TempDir())
+	ss.Register(sink)
+	if err := ss.SetDefault([]string{sink.Name()}); err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+// storeUpload writes an assembled upload into storage
+func storeUpload(t *testing.T, uploadID, content string) {
+	dir := filepath.Join(config.StorageRoot, uploadID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	md := models.Metadata{UploadID: uploadID, ShareID: "share-" + uploadID, Filename: "notes.txt", TotalChunks: 1, ChunkSize: int64(len(content))}
+	b, _ := json.Marshal(md)
+	if err := os.WriteFile(filepath.Join(dir, "metadata.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, md.Filename), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pushStatus returns the state of an upload's only push
+func pushStatus(t *testing.T, ss *SinkService, uploadID string) models.SinkStatus {
+	t.Helper()
+	md, err := ss.readMetadata(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(md.Sinks) != 1 {
+		t.Fatalf("%d sink statuses, want 1", len(md.Sinks))
+	}
+	return md.Sinks[0]
+}
+
+// attempt makes the pending push due and processes it, as the worker does
+func attempt(t *testing.T, ss *SinkService, uploadID string) {
+	t.Helper()
+	past := time.Now().Add(-time.Second)
+	md, err := ss.updateMetadata(uploadID, func(md *models.Metadata) {
+		for i := range md.Sinks {
+			if md.Sinks[i].NextAttempt != nil {
+				md.Sinks[i].NextAttempt = &past
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.schedule(uploadID, md.Sinks)
+	due := ss.due()
+	if len(due) != 1 || due[0] != uploadID {
+		t.Fatalf("due = %v, want [%s]", due, uploadID)
+	}
+	ss.process(uploadID)
+}
+
+func TestSinkPushSucceeds(t *testing.T) {
+	ss, fc := newCloudinarySinks(t, 0)
+	storeUpload(t, "push-ok", "hello sink")
+	if err := ss.Enqueue("push-ok"); err != nil {
+		t.Fatal(err)
+	}
+	if s := pushStatus(t, ss, "push-ok"); s.Status != models.SinkPending {
+		t.Fatalf("enqueued push is %s", s.Status)
+	}
+	attempt(t, ss, "push-ok")
+
+	s := pushStatus(t, ss, "push-ok")
+	if s.Status != models.SinkUploaded || s.Attempts != 1 || s.URL != "https://res.example.com/push-ok" {
+		t.Fatalf("push = %+v", s)
+	}
+	if fc.files["push-ok"] != "hello sink" {
+		t.Errorf("cloudinary received %q", fc.files["push-ok"])
+	}
+	if f := fc.fields[0]; f["signature"] == "" || f["api_key"] != "key" || f["folder"] != "share-push-ok" {
+		t.Errorf("upload fields = %v", f)
+	}
+	if _, queued := ss.queue["push-ok"]; queued {
+		t.Error("finished push is still queued")
+	}
+}
+
+func TestSinkPushRetriesWithBackoff(t *testing.T) {
+	ss, fc := newCloudinarySinks(t, 2)
+	storeUpload(t, "push-retry", "second time lucky")
+	if err := ss.Enqueue("push-retry"); err != nil {
+		t.Fatal(err)
+	}
+
+	var waits []time.Duration
+	for i := 1; i <= 2; i++ {
+		attempt(t, ss, "push-retry")
+		s := pushStatus(t, ss, "push-retry")
+		if s.Status != models.SinkPending || s.Attempts != i || s.NextAttempt == nil || s.LastError == "" {
+			t.Fatalf("after failure %d: %+v", i, s)
+		}
+		wait := s.NextAttempt.Sub(s.UpdatedAt)
+		if want := sinkBackoff(i); wait != want {
+			t.Errorf("after failure %d waits %s, want %s", i, wait, want)
+		}
+		waits = append(waits, wait)
+	}
+	if waits[1] <= waits[0] {
+		t.Errorf("backoff did not grow: %v", waits)
+	}
+
+	attempt(t, ss, "push-retry")
+	if s := pushStatus(t, ss, "push-retry"); s.Status != models.SinkUploaded || s.Attempts != 3 || s.LastError != "" {
+		t.Fatalf("after retries: %+v", s)
+	}
+	if fc.files["push-retry"] != "second time lucky" {
+		t.Errorf("cloudinary received %q", fc.files["push-retry"])
+	}
+}
+
+func TestSinkPushFailsAfterMaxAttempts(t *testing.T) {
+	ss, fc := newCloudinarySinks(t, SinkMaxAttempts+1)
+	storeUpload(t, "push-fail", "never arrives")
+	if err := ss.Enqueue("push-fail"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < SinkMaxAttempts; i++ {
+		attempt(t, ss, "push-fail")
+	}
+
+	s := pushStatus(t, ss, "push-fail")
+	if s.Status != models.SinkFailed || s.Attempts != SinkMaxAttempts || s.NextAttempt != nil {
+		t.Fatalf("push = %+v", s)
+	}
+	if fc.requests != SinkMaxAttempts {
+		t.Errorf("%d requests, want %d", fc.requests, SinkMaxAttempts)
+	}
+	if _, queued := ss.queue["push-fail"]; queued {
+		t.Error("failed push is still queued")
+	}
+	if due := ss.due(); len(due) != 0 {
+		t.Errorf("due = %v", due)
+	}
+}
+
+func TestSinkLoadRestoresPendingPushes(t *testing.T) {
+	ss, fc := newCloudinarySinks(t, 1)
+	storeUpload(t, "push-restart", "survives restarts")
+	if err := ss.Enqueue("push-restart"); err != nil {
+		t.Fatal(err)
+	}
+	attempt(t, ss, "push-restart")
+	before := pushStatus(t, ss, "push-restart")
+	if before.Status != models.SinkPending || before.NextAttempt == nil {
+		t.Fatalf("push = %+v", before)
+	}
+
+	// A restarted server only has what metadata.json recorded
+	restarted := NewSinkService(t.TempDir())
+	restarted.Register(sinks.NewCloudinary(config.Cloudinary, sinks.CloudinaryOptions{}))
+	if err := restarted.Load(); err != nil {
+		t.Fatal(err)
+	}
+	next, queued := restarted.queue["push-restart"]
+	if !queued || !next.Equal(*before.NextAttempt) {
+		t.Fatalf("restored queue entry %v %v, want %v", next, queued, *before.NextAttempt)
+	}
+	if due := restarted.due(); len(due) != 0 {
+		t.Fatalf("push is due before its backoff: %v", due)
+	}
+
+	attempt(t, restarted, "push-restart")
+	if s := pushStatus(t, restarted, "push-restart"); s.Status != models.SinkUploaded || s.Attempts != 2 {
+		t.Fatalf("after restart: %+v", s)
+	}
+	if fc.files["push-restart"] != "survives restarts" {
+		t.Errorf("cloudinary received %q", fc.files["push-restart"])
+	}
+}
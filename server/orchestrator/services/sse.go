@@ -5,78 +5,63 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
-	"sync"
 
 	"aetherlink/helpers"
+	"aetherlink/metrics"
 	"aetherlink/models"
 )
 
-type SSEService struct {
-	clients sync.RWMutex
-	mm      map[string]map[chan string]struct{} // uploadID -> set of channels
-}
+// SSEService publishes upload progress to the upload's event log, from
+// which SSE clients are served
+type SSEService struct{}
 
-var SSE = &SSEService{
-	mm: make(map[string]map[chan string]struct{}),
-}
+var SSE = &SSEService{}
 
-// AddClient registers a new SSE client for an upload
-func (s *SSEService) AddClient(uploadID string, ch chan string) {
-	s.clients.Lock()
-	defer s.clients.Unlock()
-	if _, ok := s.mm[uploadID]; !ok {
-		s.mm[uploadID] = make(map[chan string]struct{})
-	}
-	s.mm[uploadID][ch] = struct{}{}
+// AddClient subscribes an SSE client to an upload's events
+func (s *SSEService) AddClient(uploadID string) chan struct{} {
+	metrics.SSEClients.WithLabelValues(metrics.KindUpload).Inc()
+	return EventLog.Subscribe(UploadStream(uploadID))
 }
 
-// RemoveClient unregisters an SSE client
-func (s *SSEService) RemoveClient(uploadID string, ch chan string) {
-	s.clients.Lock()
-	defer s.clients.Unlock()
-	delete(s.mm[uploadID], ch)
-	if len(s.mm[uploadID]) == 0 {
-		delete(s.mm, uploadID)
-	}
+// RemoveClient unsubscribes an SSE client
+func (s *SSEService) RemoveClient(uploadID string, ch chan struct{}) {
+	EventLog.Unsubscribe(UploadStream(uploadID), ch)
+	metrics.SSEClients.WithLabelValues(metrics.KindUpload).Dec()
 }
 
-// BroadcastProgress sends progress update to all connected clients for an upload
-func (s *SSEService) BroadcastProgress(uploadID string, storageRoot string) {
+// ProgressMessage builds the current progress message of an upload
+func (s *SSEService) ProgressMessage(uploadID string, storageRoot string) (string, bool) {
 	dir := filepath.Join(storageRoot, uploadID)
 	metaPath := filepath.Join(dir, "metadata.json")
 	mdBytes, err := os.ReadFile(metaPath)
 	if err != nil {
-		return
+		return "", false
 	}
 	var md models.Metadata
 	if err := json.Unmarshal(mdBytes, &md); err != nil {
-		return
+		return "", false
 	}
 	received, _ := helpers.ReadReceivedChunks(dir)
+	received = helpers.DataChunks(received, md.TotalChunks)
 	sort.Ints(received)
-	msgObj := map[string]interface{}{
-		"upload_id":       uploadID,
-		"filename":        md.Filename,
-		"total_chunks":    md.TotalChunks,
-		"received_chunks": received,
-		"received_count":  len(received),
-		"completed_percent": func() int {
-			if md.TotalChunks == 0 {
-				return 0
-			}
-			return int((len(received) * 100) / md.TotalChunks)
-		}(),
+	msg := models.UploadProgress{
+		UploadID:       uploadID,
+		Filename:       md.Filename,
+		TotalChunks:    md.TotalChunks,
+		ReceivedChunks: received,
+		ReceivedCount:  len(received),
+		Advice:         Advisor.Advise(uploadID, md.ChunkSize),
+	}
+	if md.TotalChunks > 0 {
+		msg.CompletedPercent = len(received) * 100 / md.TotalChunks
 	}
-	bs, _ := json.Marshal(msgObj)
+	bs, _ := json.Marshal(msg)
+	return string(bs), true
+}
 
-	s.clients.RLock()
-	chs := s.mm[uploadID]
-	s.clients.RUnlock()
-	for ch := range chs {
-		select {
-		case ch <- string(bs):
-		default:
-			// avoid blocking
-		}
+// BroadcastProgress logs a progress update for all clients of an upload
+func (s *SSEService) BroadcastProgress(uploadID string, storageRoot string) {
+	if msg, ok := s.ProgressMessage(uploadID, storageRoot); ok {
+		EventLog.Append(UploadStream(uploadID), msg)
 	}
 }
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/models"
+)
+
+// Telemetry grouping keys accepted by Aggregate
+const (
+	GroupByNetworkQuality = "network_quality"
+	GroupByChunkSize      = "chunk_size"
+	GroupByEffectiveType  = "effective_type"
+)
+
+// ErrBadGroupBy means an aggregation was requested for an unknown key
+var ErrBadGroupBy = errors.New("group_by must be network_quality, chunk_size or effective_type")
+
+// telemetryRetention is how long server measurements wait for a client report
+const telemetryRetention = 24 * time.Hour
+
+// TelemetryService keeps server-side measurements per upload until the
+// client reports its own telemetry, then stores both together in an
+// append-only JSON lines file that aggregation queries read back
+type TelemetryService struct {
+	mu      sync.Mutex
+	uploads map[string]*uploadMeasurements
+	path    string
+	fileMu  sync.Mutex
+}
+
+type uploadMeasurements struct {
+	chunks         int
+	bytes          int64
+	writeTime      time.Duration
+	replays        int
+	hashMismatches int
+	firstChunk     time.Time
+	lastChunk      time.Time
+	assembly       time.Duration
+}
+
+// TelemetryQuery filters and groups stored reports
+type TelemetryQuery struct {
+	GroupBy        string
+	Since          time.Time
+	NetworkQuality string
+}
+
+var Telemetry = &TelemetryService{
+	uploads: make(map[string]*uploadMeasurements),
+	path:    filepath.Join(config.TelemetryRoot, "reports.jsonl"),
+}
+
+// RecordChunk records a chunk of n bytes that took writeDur to persist
+func (ts *TelemetryService) RecordChunk(uploadID string, n int64, writeDur time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	m := ts.measurementsLocked(uploadID)
+	m.chunks++
+	m.bytes += n
+	m.writeTime += writeDur
+	m.lastChunk = time.Now()
+}
+
+// RecordReplay records a chunk that was already stored
+func (ts *TelemetryService) RecordReplay(uploadID string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.measurementsLocked(uploadID).replays++
+}
+
+// RecordMismatch records a chunk rejected for a hash mismatch
+func (ts *TelemetryService) RecordMismatch(uploadID string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.measurementsLocked(uploadID).hashMismatches++
+}
+
+// RecordAssembly records how long /complete took to assemble the file
+func (ts *TelemetryService) RecordAssembly(uploadID string, d time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.measurementsLocked(uploadID).assembly = d
+}
+
+// Forget drops the measurements of an upload
+func (ts *TelemetryService) Forget(uploadID string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.uploads, uploadID)
+}
+
+// Report stores a client report together with the server measurements of
+// the upload. A second report for the same upload only carries the client
+// side, since measurements are dropped once reported.
+func (ts *TelemetryService) Report(md models.Metadata, client models.ClientTelemetry, completed bool) (models.TelemetryRecord, error) {
+	server := models.ServerTelemetry{
+		TotalChunks: md.TotalChunks,
+		ChunkSize:   md.ChunkSize,
+		FileSize:    md.ExpectedBytes(),
+		Completed:   completed,
+	}
+	ts.mu.Lock()
+	if m, ok := ts.uploads[md.UploadID]; ok {
+		server.ChunksWritten = m.chunks
+		server.BytesWritten = m.bytes
+		server.IdempotentReplays = m.replays
+		server.HashMismatches = m.hashMismatches
+		if m.chunks > 0 {
+			server.AvgWriteMs = float64(m.writeTime.Microseconds()) / 1000 / float64(m.chunks)
+			server.IngestMs = m.lastChunk.Sub(m.firstChunk).Milliseconds()
+		}
+		server.AssemblyMs = m.assembly.Milliseconds()
+		delete(ts.uploads, md.UploadID)
+	}
+	ts.mu.Unlock()
+
+	record := models.TelemetryRecord{
+		UploadID:   md.UploadID,
+		ShareID:    md.ShareID,
+		ReportedAt: time.Now().UTC(),
+		Client:     client,
+		Server:     server,
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return record, err
+	}
+
+	ts.fileMu.Lock()
+	defer ts.fileMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(ts.path), 0755); err != nil {
+		return record, err
+	}
+	f, err := os.OpenFile(ts.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return record, err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return record, err
+}
+
+// Aggregate groups stored reports by network quality class, chunk size class
+// or effective connection type
+func (ts *TelemetryService) Aggregate(q TelemetryQuery) ([]models.TelemetryAggregate, error) {
+	if q.GroupBy == "" {
+		q.GroupBy = GroupByNetworkQuality
+	}
+	if q.GroupBy != GroupByNetworkQuality && q.GroupBy != GroupByChunkSize && q.GroupBy != GroupByEffectiveType {
+		return nil, ErrBadGroupBy
+	}
+
+	groups := make(map[string][]models.TelemetryRecord)
+	err := ts.each(func(r models.TelemetryRecord) {
+		if r.ReportedAt.Before(q.Since) {
+			return
+		}
+		if q.NetworkQuality != "" && r.Client.NetworkQuality != q.NetworkQuality {
+			return
+		}
+		key := telemetryGroup(r, q.GroupBy)
+		groups[key] = append(groups[key], r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := make([]models.TelemetryAggregate, 0, len(groups))
+	for key, records := range groups {
+		aggregates = append(aggregates, aggregateTelemetry(key, records))
+	}
+	sort.Slice(aggregates, func(i, j int) bool { return aggregates[i].Group < aggregates[j].Group })
+	return aggregates, nil
+}
+
+// each calls fn for every stored report, skipping malformed lines
+func (ts *TelemetryService) each(fn func(models.TelemetryRecord)) error {
+	ts.fileMu.Lock()
+	defer ts.fileMu.Unlock()
+	f, err := os.Open(ts.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var r models.TelemetryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		fn(r)
+	}
+	return scanner.Err()
+}
+
+// measurementsLocked returns the measurements of an upload, creating them on
+// first use. Measurements of uploads that never reported are pruned then.
+func (ts *TelemetryService) measurementsLocked(uploadID string) *uploadMeasurements {
+	if m, ok := ts.uploads[uploadID]; ok {
+		return m
+	}
+	now := time.Now()
+	for id, m := range ts.uploads {
+		if now.Sub(m.lastChunk) > telemetryRetention {
+			delete(ts.uploads, id)
+		}
+	}
+	m := &uploadMeasurements{firstChunk: now, lastChunk: now}
+	ts.uploads[uploadID] = m
+	return m
+}
+
+func telemetryGroup(r models.TelemetryRecord, groupBy string) string {
+	switch groupBy {
+	case GroupByChunkSize:
+		size := r.Server.ChunkSize
+		if size <= 0 {
+			size = r.Client.ChunkSize
+		}
+		return chunkSizeClass(size)
+	case GroupByEffectiveType:
+		if r.Client.EffectiveType == "" {
+			return "unknown"
+		}
+		return r.Client.EffectiveType
+	default:
+		if r.Client.NetworkQuality == "" {
+			return "unknown"
+		}
+		return r.Client.NetworkQuality
+	}
+}
+
+// chunkSizeClass rounds a chunk size up to a power of two, e.g. "512KiB"
+func chunkSizeClass(size int64) string {
+	if size <= 0 {
+		return "unknown"
+	}
+	class := uint64(1) << bits.Len64(uint64(size-1))
+	switch {
+	case class >= 1<<20:
+		return fmt.Sprintf("%dMiB", class>>20)
+	case class >= 1<<10:
+		return fmt.Sprintf("%dKiB", class>>10)
+	default:
+		return fmt.Sprintf("%dB", class)
+	}
+}
+
+func aggregateTelemetry(group string, records []models.TelemetryRecord) models.TelemetryAggregate {
+	agg := models.TelemetryAggregate{Group: group, Uploads: len(records)}
+	latencies := make([]float64, 0, len(records))
+	var throughput, latency, retries, efficiency, score, writeMs float64
+	var timed, writes int
+	for _, r := range records {
+		agg.TotalBytes += r.Client.TotalBytes
+		agg.HashMismatches += r.Server.HashMismatches
+		if r.Client.DurationMs > 0 {
+			throughput += float64(r.Client.TotalBytes) / (float64(r.Client.DurationMs) / 1000)
+			timed++
+		}
+		latency += r.Client.AverageLatencyMs
+		latencies = append(latencies, r.Client.AverageLatencyMs)
+		if r.Client.SuccessfulChunks > 0 {
+			retries += float64(r.Client.TotalRetries) / float64(r.Client.SuccessfulChunks)
+		}
+		efficiency += r.Client.AverageEfficiency
+		score += r.Client.PerformanceScore
+		if r.Server.ChunksWritten > 0 {
+			writeMs += r.Server.AvgWriteMs
+			writes++
+		}
+	}
+
+	n := float64(len(records))
+	if timed > 0 {
+		agg.AvgThroughputBps = throughput / float64(timed)
+	}
+	if writes > 0 {
+		agg.AvgServerWriteMs = writeMs / float64(writes)
+	}
+	agg.AvgLatencyMs = latency / n
+	agg.AvgRetriesPerChunk = retries / n
+	agg.AvgEfficiency = efficiency / n
+	agg.AvgPerformanceScore = score / n
+
+	// p95 over the per-upload average latencies
+	sort.Float64s(latencies)
+	agg.P95LatencyMs = latencies[(len(latencies)*95-1)/100]
+	return agg
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"aetherlink/helpers"
+	"aetherlink/models"
+)
+
+// minThrottleBurst keeps very low limits from stalling on small reads
+const minThrottleBurst = 64 << 10
+
+// throttleReadSize bounds each throttled download read so pacing stays smooth
+const throttleReadSize = 32 << 10
+
+// ThrottleService paces chunk ingest and downloads with token buckets
+// keyed globally, per priority, per share and per upload. A transfer waits
+// for the slowest bucket that applies to it.
+type ThrottleService struct {
+	mu         sync.Mutex
+	limits     models.ThrottleLimits
+	global     *helpers.TokenBucket
+	priorities map[string]*helpers.TokenBucket
+	shares     map[string]*helpers.TokenBucket
+	uploads    map[string]*helpers.TokenBucket
+}
+
+var Throttle = &ThrottleService{
+	priorities: make(map[string]*helpers.TokenBucket),
+	shares:     make(map[string]*helpers.TokenBucket),
+	uploads:    make(map[string]*helpers.TokenBucket),
+}
+
+// SetLimits replaces the active limits; buckets are rebuilt lazily
+func (ts *ThrottleService) SetLimits(limits models.ThrottleLimits) error {
+	if err := validateLimits(limits); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.limits = copyLimits(limits)
+	ts.global = newByteBucket(limits.GlobalBytesPerSec)
+	ts.priorities = make(map[string]*helpers.TokenBucket)
+	ts.shares = make(map[string]*helpers.TokenBucket)
+	ts.uploads = make(map[string]*helpers.TokenBucket)
+	return nil
+}
+
+// Limits returns a copy of the active limits
+func (ts *ThrottleService) Limits() models.ThrottleLimits {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return copyLimits(ts.limits)
+}
+
+// Enabled reports whether any bandwidth limit is set
+func (ts *ThrottleService) Enabled() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	l := ts.limits
+	return l.GlobalBytesPerSec > 0 || l.UploadBytesPerSec > 0 || l.DefaultShareBytesPerSec > 0 ||
+		len(l.ShareBytesPerSec) > 0 || len(l.PriorityBytesPerSec) > 0
+}
+
+// Delay charges n bytes to every applicable bucket and returns how long the
+// transfer must pause to stay within its limits
+func (ts *ThrottleService) Delay(uploadID, shareID string, p Priority, n int64) time.Duration {
+	ts.mu.Lock()
+	buckets := make([]*helpers.TokenBucket, 0, 4)
+	if ts.global != nil {
+		buckets = append(buckets, ts.global)
+	}
+	if b := ts.bucketLocked(ts.priorities, p.String(), ts.limits.PriorityBytesPerSec[p.String()]); b != nil {
+		buckets = append(buckets, b)
+	}
+	shareLimit, ok := ts.limits.ShareBytesPerSec[shareID]
+	if !ok {
+		shareLimit = ts.limits.DefaultShareBytesPerSec
+	}
+	if b := ts.bucketLocked(ts.shares, shareID, shareLimit); b != nil {
+		buckets = append(buckets, b)
+	}
+	if b := ts.bucketLocked(ts.uploads, uploadID, ts.limits.UploadBytesPerSec); b != nil {
+		buckets = append(buckets, b)
+	}
+	ts.mu.Unlock()
+
+	var delay time.Duration
+	for _, b := range buckets {
+		delay = max(delay, b.Reserve(float64(n)))
+	}
+	return delay
+}
+
+// Forget drops the per-upload bucket of a finished upload
+func (ts *ThrottleService) Forget(uploadID string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.uploads, uploadID)
+}
+
+// Reader wraps r so reads are paced by the limits of the given transfer
+func (ts *ThrottleService) Reader(r io.Reader, uploadID, shareID string, p Priority) io.Reader {
+	return &throttledReader{r: r, ts: ts, uploadID: uploadID, shareID: shareID, priority: p}
+}
+
+func (ts *ThrottleService) bucketLocked(m map[string]*helpers.TokenBucket, key string, limit int64) *helpers.TokenBucket {
+	if limit <= 0 {
+		return nil
+	}
+	if b, ok := m[key]; ok {
+		return b
+	}
+	b := newByteBucket(limit)
+	m[key] = b
+	return b
+}
+
+type throttledReader struct {
+	r        io.Reader
+	ts       *ThrottleService
+	uploadID string
+	shareID  string
+	priority Priority
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleReadSize {
+		p = p[:throttleReadSize]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if d := tr.ts.Delay(tr.uploadID, tr.shareID, tr.priority, int64(n)); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	return n, err
+}
+
+// Close closes the wrapped reader if it is closable, so response streams
+// release the underlying file
+func (tr *throttledReader) Close() error {
+	if c, ok := tr.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func newByteBucket(limit int64) *helpers.TokenBucket {
+	if limit <= 0 {
+		return nil
+	}
+	return helpers.NewTokenBucket(float64(limit), float64(max(limit, minThrottleBurst)))
+}
+
+func validateLimits(l models.ThrottleLimits) error {
+	if l.GlobalBytesPerSec < 0 || l.UploadBytesPerSec < 0 || l.DefaultShareBytesPerSec < 0 {
+		return errors.New("limits must not be negative")
+	}
+	for _, v := range l.ShareBytesPerSec {
+		if v < 0 {
+			return errors.New("share limits must not be negative")
+		}
+	}
+	for p, v := range l.PriorityBytesPerSec {
+		if v < 0 {
+			return errors.New("priority limits must not be negative")
+		}
+		if ParsePriority(p).String() != p {
+			return errors.New("priority limits must be keyed by high, normal or bulk")
+		}
+	}
+	return nil
+}
+
+func copyLimits(l models.ThrottleLimits) models.ThrottleLimits {
+	out := l
+	out.ShareBytesPerSec = make(map[string]int64, len(l.ShareBytesPerSec))
+	for k, v := range l.ShareBytesPerSec {
+		out.ShareBytesPerSec[k] = v
+	}
+	out.PriorityBytesPerSec = make(map[string]int64, len(l.PriorityBytesPerSec))
+	for k, v := range l.PriorityBytesPerSec {
+		out.PriorityBytesPerSec[k] = v
+	}
+	return out
+}
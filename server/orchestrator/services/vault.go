@@ -0,0 +1,308 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+	"aetherlink/sinks"
+)
+
+var (
+	// ErrVaultDisabled means no VAULT_KEY is configured
+	ErrVaultDisabled = errors.New("credential vault disabled")
+	// ErrCredentialNotFound means no credential has the given ID
+	ErrCredentialNotFound = errors.New("credential not found")
+)
+
+// VaultService keeps per-share and per-tenant sink credentials sealed with
+// AES-256-GCM, so the secrets of customer accounts never touch disk in the
+// clear. Sinks built from unsealed credentials are cached in memory.
+type VaultService struct {
+	mu          sync.Mutex
+	root        string
+	aead        cipher.AEAD // nil while the vault is disabled
+	creds       map[string]sealedCredential
+	tenants     map[string][]string // tenant -> share IDs
+	shareTenant map[string]string
+	cache       map[string]sinks.Sink // credential ID -> sink
+}
+
+// sealedCredential is a credential as stored, its config encrypted with
+// the credential ID as additional data
+type sealedCredential struct {
+	models.SinkCredential
+	Sealed string `json:"sealed"`
+}
+
+var Vault = &VaultService{
+	root:        config.VaultRoot,
+	creds:       make(map[string]sealedCredential),
+	tenants:     make(map[string][]string),
+	shareTenant: make(map[string]string),
+	cache:       make(map[string]sinks.Sink),
+}
+
+// Open enables the vault with a 32-byte key and loads stored credentials
+// and tenants
+func (v *VaultService) Open(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	var creds []sealedCredential
+	if err := readJSONFile(filepath.Join(v.root, "credentials.json"), &creds); err != nil {
+		return err
+	}
+	var tenants []models.Tenant
+	if err := readJSONFile(filepath.Join(v.root, "tenants.json"), &tenants); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.aead = aead
+	for _, c := range creds {
+		// Fail early on a wrong key rather than on the first push
+		if _, err := v.unsealLocked(c); err != nil {
+			return fmt.Errorf("credential %s: %w", c.ID, err)
+		}
+		v.creds[c.ID] = c
+	}
+	for _, t := range tenants {
+		v.tenants[t.ID] = t.ShareIDs
+		for _, shareID := range t.ShareIDs {
+			v.shareTenant[shareID] = t.ID
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether a vault key is configured
+func (v *VaultService) Enabled() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.aead != nil
+}
+
+// Register validates and seals a credential for a share or a tenant. An
+// existing credential for the same sink and scope is replaced.
+func (v *VaultService) Register(c models.SinkCredential) (models.SinkCredential, error) {
+	if (c.ShareID == "") == (c.Tenant == "") {
+		return c, errors.New("exactly one of share_id and tenant is required")
+	}
+	if _, err := Sinks.build(c.Sink, c.Config); err != nil {
+		return c, err
+	}
+	c.ID = helpers.GenerateShareID()
+	c.CreatedAt = time.Now().UTC()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.aead == nil {
+		return c, ErrVaultDisabled
+	}
+	plain, err := json.Marshal(c.Config)
+	if err != nil {
+		return c, err
+	}
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return c, err
+	}
+	sealed := sealedCredential{SinkCredential: publicCredential(c)}
+	sealed.Sealed = base64.StdEncoding.EncodeToString(v.aead.Seal(nonce, nonce, plain, []byte(c.ID)))
+
+	for id, old := range v.creds {
+		if old.Sink == c.Sink && old.ShareID == c.ShareID && old.Tenant == c.Tenant {
+			delete(v.creds, id)
+			delete(v.cache, id)
+		}
+	}
+	v.creds[c.ID] = sealed
+	return sealed.SinkCredential, v.saveCredentialsLocked()
+}
+
+// Remove deletes a credential; its scope falls back to the tenant's or the
+// process-wide account
+func (v *VaultService) Remove(id string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.aead == nil {
+		return ErrVaultDisabled
+	}
+	if _, ok := v.creds[id]; !ok {
+		return ErrCredentialNotFound
+	}
+	delete(v.creds, id)
+	delete(v.cache, id)
+	return v.saveCredentialsLocked()
+}
+
+// List returns the credentials without their configs
+func (v *VaultService) List() []models.SinkCredential {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	creds := make([]models.SinkCredential, 0, len(v.creds))
+	for _, c := range v.creds {
+		creds = append(creds, c.SinkCredential)
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].CreatedAt.Before(creds[j].CreatedAt) })
+	return creds
+}
+
+// SetTenant replaces the shares of a tenant; shares move away from any
+// tenant they belonged to before. An empty list removes the tenant.
+func (v *VaultService) SetTenant(t models.Tenant) error {
+	if t.ID == "" {
+		return errors.New("tenant id is required")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.aead == nil {
+		return ErrVaultDisabled
+	}
+	for _, shareID := range v.tenants[t.ID] {
+		delete(v.shareTenant, shareID)
+	}
+	delete(v.tenants, t.ID)
+	for _, shareID := range t.ShareIDs {
+		if owner, ok := v.shareTenant[shareID]; ok {
+			v.tenants[owner] = slices.DeleteFunc(v.tenants[owner], func(s string) bool { return s == shareID })
+		}
+		v.shareTenant[shareID] = t.ID
+	}
+	if len(t.ShareIDs) > 0 {
+		v.tenants[t.ID] = slices.Clone(t.ShareIDs)
+	}
+	return v.saveTenantsLocked()
+}
+
+// Tenants returns the tenants and their shares
+func (v *VaultService) Tenants() []models.Tenant {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	tenants := make([]models.Tenant, 0, len(v.tenants))
+	for id, shareIDs := range v.tenants {
+		tenants = append(tenants, models.Tenant{ID: id, ShareIDs: slices.Clone(shareIDs)})
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants
+}
+
+// SinkFor returns the sink a share's files are pushed to for a sink name:
+// the share's own account, else its tenant's. ok is false when neither has
+// one and the process-wide sink applies.
+func (v *VaultService) SinkFor(shareID, name string) (sink sinks.Sink, credentialID string, ok bool, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.aead == nil {
+		return nil, "", false, nil
+	}
+	cred, found := v.lookupLocked(shareID, name)
+	if !found {
+		return nil, "", false, nil
+	}
+	if sink, cached := v.cache[cred.ID]; cached {
+		return sink, cred.ID, true, nil
+	}
+	cfg, err := v.unsealLocked(cred)
+	if err != nil {
+		return nil, cred.ID, true, fmt.Errorf("credential %s: %w", cred.ID, err)
+	}
+	if sink, err = Sinks.build(name, cfg); err != nil {
+		return nil, cred.ID, true, err
+	}
+	v.cache[cred.ID] = sink
+	return sink, cred.ID, true, nil
+}
+
+// HasAccount reports whether a share has its own or its tenant's account
+// for a sink
+func (v *VaultService) HasAccount(shareID, name string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, found := v.lookupLocked(shareID, name)
+	return v.aead != nil && found
+}
+
+func (v *VaultService) lookupLocked(shareID, name string) (sealedCredential, bool) {
+	tenant := v.shareTenant[shareID]
+	var byTenant sealedCredential
+	tenantFound := false
+	for _, c := range v.creds {
+		if c.Sink != name {
+			continue
+		}
+		if c.ShareID != "" && c.ShareID == shareID {
+			return c, true
+		}
+		if tenant != "" && c.Tenant == tenant {
+			byTenant, tenantFound = c, true
+		}
+	}
+	return byTenant, tenantFound
+}
+
+func (v *VaultService) unsealLocked(c sealedCredential) (map[string]string, error) {
+	raw, err := base64.StdEncoding.DecodeString(c.Sealed)
+	if err != nil || len(raw) < v.aead.NonceSize() {
+		return nil, errors.New("malformed sealed config")
+	}
+	nonce, ciphertext := raw[:v.aead.NonceSize()], raw[v.aead.NonceSize():]
+	plain, err := v.aead.Open(nil, nonce, ciphertext, []byte(c.ID))
+	if err != nil {
+		return nil, errors.New("cannot unseal config, wrong vault key?")
+	}
+	var cfg map[string]string
+	return cfg, json.Unmarshal(plain, &cfg)
+}
+
+// publicCredential strips a credential's config, keeping its field names
+func publicCredential(c models.SinkCredential) models.SinkCredential {
+	c.Fields = make([]string, 0, len(c.Config))
+	for key := range c.Config {
+		c.Fields = append(c.Fields, key)
+	}
+	sort.Strings(c.Fields)
+	c.Config = nil
+	return c
+}
+
+func (v *VaultService) saveCredentialsLocked() error {
+	creds := make([]sealedCredential, 0, len(v.creds))
+	for _, c := range v.creds {
+		creds = append(creds, c)
+	}
+	path := filepath.Join(v.root, "credentials.json")
+	if err := helpers.WriteJSONAtomic(path, creds); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+func (v *VaultService) saveTenantsLocked() error {
+	tenants := make([]models.Tenant, 0, len(v.tenants))
+	for id, shareIDs := range v.tenants {
+		tenants = append(tenants, models.Tenant{ID: id, ShareIDs: shareIDs})
+	}
+	return helpers.WriteJSONAtomic(filepath.Join(v.root, "tenants.json"), tenants)
+}
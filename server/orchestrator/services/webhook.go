@@ -0,0 +1,396 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/models"
+)
+
+const (
+	// Signature headers sent with every webhook delivery. The signature is
+	// hex HMAC-SHA256 over "<timestamp>.<body>" keyed with the webhook secret.
+	HeaderWebhookEvent     = "X-Aetherlink-Event"
+	HeaderWebhookDelivery  = "X-Aetherlink-Delivery"
+	HeaderWebhookTimestamp = "X-Aetherlink-Timestamp"
+	HeaderWebhookSignature = "X-Aetherlink-Signature"
+
+	// WebhookMaxAttempts is how often a delivery is tried before it is failed
+	WebhookMaxAttempts = 8
+	// webhookBaseBackoff doubles after every failed attempt, up to webhookMaxBackoff
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookTimeout     = 10 * time.Second
+	webhookPollEvery   = time.Second
+	// webhookLogSize caps the finished deliveries kept for the delivery log
+	webhookLogSize = 1000
+)
+
+// ErrWebhookNotFound means no webhook has the given ID
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookService delivers lifecycle events to registered endpoints. Webhooks
+// and the delivery queue are persisted, so pending retries survive restarts.
+type WebhookService struct {
+	mu         sync.Mutex
+	root       string
+	hooks      map[string]models.Webhook
+	deliveries []*models.WebhookDelivery // oldest first
+	inFlight   map[string]bool
+	client     *http.Client
+	stop       chan struct{}
+	stopped    chan struct{}  // closed when the worker has returned
+	running    sync.WaitGroup // attempts in progress
+}
+
+var Webhooks = &WebhookService{
+	root:     config.WebhookRoot,
+	hooks:    make(map[string]models.Webhook),
+	inFlight: make(map[string]bool),
+	client:   &http.Client{Timeout: webhookTimeout},
+	stop:     make(chan struct{}),
+	stopped:  make(chan struct{}),
+}
+
+// Load restores webhooks and the delivery queue from disk
+func (ws *WebhookService) Load() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	var hooks []models.Webhook
+	if err := readJSONFile(filepath.Join(ws.root, "webhooks.json"), &hooks); err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		ws.hooks[h.ID] = h
+	}
+	return readJSONFile(filepath.Join(ws.root, "deliveries.json"), &ws.deliveries)
+}
+
+// Register validates and stores a webhook, generating its ID and, if none
+// was given, its signing secret
+func (ws *WebhookService) Register(h models.Webhook) (models.Webhook, error) {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return h, errors.New("url must be an absolute http(s) URL")
+	}
+	for _, e := range h.Events {
+		if !slices.Contains(models.WebhookEvents, e) {
+			return h, fmt.Errorf("unknown event %q", e)
+		}
+	}
+	h.ID = helpers.GenerateShareID()
+	if h.Secret == "" {
+		h.Secret = helpers.GenerateShareID()
+	}
+	h.CreatedAt = time.Now().UTC()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.hooks[h.ID] = h
+	return h, ws.saveHooksLocked()
+}
+
+// Remove deletes a webhook; its pending deliveries are dropped
+func (ws *WebhookService) Remove(id string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, ok := ws.hooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(ws.hooks, id)
+	kept := ws.deliveries[:0]
+	for _, d := range ws.deliveries {
+		if d.WebhookID != id || d.Status != models.DeliveryPending {
+			kept = append(kept, d)
+		}
+	}
+	ws.deliveries = kept
+	if err := ws.saveHooksLocked(); err != nil {
+		return err
+	}
+	return ws.saveDeliveriesLocked()
+}
+
+// List returns the registered webhooks without their secrets
+func (ws *WebhookService) List() []models.Webhook {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	hooks := make([]models.Webhook, 0, len(ws.hooks))
+	for _, h := range ws.hooks {
+		h.Secret = ""
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks
+}
+
+// Deliveries returns the delivery log, newest first, optionally filtered by
+// webhook ID and status
+func (ws *WebhookService) Deliveries(webhookID, status string) []models.WebhookDelivery {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	log := []models.WebhookDelivery{}
+	for i := len(ws.deliveries) - 1; i >= 0; i-- {
+		d := ws.deliveries[i]
+		if (webhookID == "" || d.WebhookID == webhookID) && (status == "" || d.Status == status) {
+			log = append(log, *d)
+		}
+	}
+	return log
+}
+
+// Dispatch queues a room event for every webhook subscribed to it. Events
+// that are not webhook lifecycle events are ignored.
+func (ws *WebhookService) Dispatch(event models.RoomEvent) {
+	if !slices.Contains(models.WebhookEvents, event.Type) {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	now := time.Now().UTC()
+	queued := 0
+	for _, h := range ws.hooks {
+		if h.ShareID != "" && h.ShareID != event.ShareID {
+			continue
+		}
+		if len(h.Events) > 0 && !slices.Contains(h.Events, event.Type) {
+			continue
+		}
+		ws.deliveries = append(ws.deliveries, &models.WebhookDelivery{
+			ID:          helpers.GenerateShareID(),
+			WebhookID:   h.ID,
+			Event:       event.Type,
+			ShareID:     event.ShareID,
+			Payload:     payload,
+			Status:      models.DeliveryPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+		queued++
+	}
+	if queued == 0 {
+		return
+	}
+	if err := ws.saveDeliveriesLocked(); err != nil {
+		slog.Error("failed to persist webhook deliveries", "event", "webhook", "error", err)
+	}
+}
+
+// Start runs the delivery worker until Stop is called
+func (ws *WebhookService) Start() {
+	go func() {
+		defer close(ws.stopped)
+		ticker := time.NewTicker(webhookPollEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, d := range ws.due() {
+					ws.running.Add(1)
+					go func(d models.WebhookDelivery) {
+						defer ws.running.Done()
+						ws.deliver(d)
+					}(d)
+				}
+			case <-ws.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the delivery worker and waits until ctx ends for attempts in
+// progress to record their outcome; pending deliveries resume on next start
+func (ws *WebhookService) Stop(ctx context.Context) error {
+	close(ws.stop)
+	<-ws.stopped
+	return waitGroup(ctx, &ws.running)
+}
+
+// due claims pending deliveries whose next attempt has come
+func (ws *WebhookService) due() []models.WebhookDelivery {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	now := time.Now()
+	due := []models.WebhookDelivery{}
+	for _, d := range ws.deliveries {
+		if d.Status == models.DeliveryPending && !ws.inFlight[d.ID] && !d.NextAttempt.After(now) {
+			ws.inFlight[d.ID] = true
+			due = append(due, *d)
+		}
+	}
+	return due
+}
+
+// deliver makes one signed attempt and records the outcome
+func (ws *WebhookService) deliver(d models.WebhookDelivery) {
+	ws.mu.Lock()
+	hook, ok := ws.hooks[d.WebhookID]
+	ws.mu.Unlock()
+
+	var status int
+	var err error
+	if !ok {
+		err = ErrWebhookNotFound
+	} else {
+		status, err = ws.post(hook, d)
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.inFlight, d.ID)
+	var stored *models.WebhookDelivery
+	for _, cur := range ws.deliveries {
+		if cur.ID == d.ID {
+			stored = cur
+			break
+		}
+	}
+	if stored == nil {
+		return // webhook removed meanwhile
+	}
+
+	stored.Attempts++
+	stored.LastStatus = status
+	logger := slog.With("event", "webhook", "webhook_id", d.WebhookID, "delivery_id", d.ID,
+		"webhook_event", d.Event, "share_id", d.ShareID, "attempt", stored.Attempts)
+	switch {
+	case err == nil:
+		now := time.Now().UTC()
+		stored.Status = models.DeliveryDelivered
+		stored.LastError = ""
+		stored.NextAttempt = time.Time{}
+		stored.DeliveredAt = &now
+		logger.Info("webhook delivered", "status", status)
+	case !ok || stored.Attempts >= WebhookMaxAttempts:
+		stored.Status = models.DeliveryFailed
+		stored.LastError = err.Error()
+		stored.NextAttempt = time.Time{}
+		logger.Warn("webhook delivery failed permanently", "status", status, "error", err)
+	default:
+		stored.LastError = err.Error()
+		stored.NextAttempt = time.Now().UTC().Add(webhookBackoff(stored.Attempts))
+		logger.Warn("webhook delivery failed, will retry", "status", status, "error", err,
+			"next_attempt", stored.NextAttempt)
+	}
+	ws.trimLocked()
+	if err := ws.saveDeliveriesLocked(); err != nil {
+		slog.Error("failed to persist webhook deliveries", "event", "webhook", "error", err)
+	}
+}
+
+func (ws *WebhookService) post(hook models.Webhook, d models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aetherlink-webhooks")
+	req.Header.Set(HeaderWebhookEvent, d.Event)
+	req.Header.Set(HeaderWebhookDelivery, d.ID)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, "sha256="+SignWebhook(hook.Secret, timestamp, d.Payload))
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>"; receivers
+// recompute it to authenticate a delivery
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the wait before the attempt after the given one
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+// trimLocked drops the oldest finished deliveries beyond the log size
+func (ws *WebhookService) trimLocked() {
+	finished := 0
+	for _, d := range ws.deliveries {
+		if d.Status != models.DeliveryPending {
+			finished++
+		}
+	}
+	if finished <= webhookLogSize {
+		return
+	}
+	drop := finished - webhookLogSize
+	kept := ws.deliveries[:0]
+	for _, d := range ws.deliveries {
+		if drop > 0 && d.Status != models.DeliveryPending {
+			drop--
+			continue
+		}
+		kept = append(kept, d)
+	}
+	ws.deliveries = kept
+}
+
+func (ws *WebhookService) saveHooksLocked() error {
+	hooks := make([]models.Webhook, 0, len(ws.hooks))
+	for _, h := range ws.hooks {
+		hooks = append(hooks, h)
+	}
+	return helpers.WriteJSONAtomic(filepath.Join(ws.root, "webhooks.json"), hooks)
+}
+
+func (ws *WebhookService) saveDeliveriesLocked() error {
+	return helpers.WriteJSONAtomic(filepath.Join(ws.root, "deliveries.json"), ws.deliveries)
+}
+
+// readJSONFile decodes path into v; a missing file leaves v untouched
+func readJSONFile(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"aetherlink/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// shutdown drains the server within timeout: new sessions, chunk writes and
+// completions are refused, SSE clients are told to reconnect, and chunk
+// writes and assemblies already running are waited for. The workers then
+// record the outcome of attempts in progress before the process exits, so
+// no .part file or metadata rewrite is cut off halfway.
+func shutdown(app *fiber.App, timeout time.Duration) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	slog.Info("draining for shutdown", "event", "shutdown", "in_flight", services.Lifecycle.InFlight(), "timeout", timeout.String())
+	services.Lifecycle.Drain()
+	if err := services.Lifecycle.Wait(ctx); err != nil {
+		slog.Warn("storage requests still running at shutdown deadline", "event", "shutdown",
+			"in_flight", services.Lifecycle.InFlight())
+	}
+
+	// Closes the listener and waits for the remaining connections, including
+	// SSE streams finishing their server_shutdown event
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		slog.Warn("connections still open at shutdown deadline", "event", "shutdown", "error", err)
+	}
+
+	if err := services.Webhooks.Stop(ctx); err != nil {
+		slog.Warn("webhook deliveries still running at shutdown deadline", "event", "shutdown", "error", err)
+	}
+	if err := services.Sinks.Stop(ctx); err != nil {
+		slog.Warn("sink pushes still running at shutdown deadline, they resume on next start", "event", "shutdown", "error", err)
+	}
+	services.EventLog.Detach()
+
+	slog.Info("shutdown complete", "event", "shutdown", "duration_ms", time.Since(start).Milliseconds())
+}
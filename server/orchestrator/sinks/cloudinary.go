@@ -0,0 +1,262 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"aetherlink/helpers"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+)
+
+// DefaultCloudinaryChunkSize is the size of each request of a chunked
+// upload; Cloudinary requires at least 5MB for all but the last chunk
+const DefaultCloudinaryChunkSize = 20 << 20
+
+// CloudinaryOptions controls where and how files are uploaded
+type CloudinaryOptions struct {
+	Folder       string // files are stored under <Folder>/<shareID>
+	ChunkSize    int64  // bytes per upload request
+	UploadPrefix string // upload API base URL of accounts built by CloudinaryFactory
+}
+
+// Cloudinary uploads files to a Cloudinary account through its chunked
+// upload API, with a client that is built once and shared by all pushes
+type Cloudinary struct {
+	client *cloudinary.Cloudinary
+	http   *http.Client
+	opts   CloudinaryOptions
+}
+
+// NewCloudinary wraps a configured Cloudinary client
+func NewCloudinary(client *cloudinary.Cloudinary, opts CloudinaryOptions) *Cloudinary {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultCloudinaryChunkSize
+	}
+	opts.Folder = strings.Trim(opts.Folder, "/")
+	return &Cloudinary{client: client, http: &http.Client{}, opts: opts}
+}
+
+// CloudinaryFactory builds Cloudinary sinks from "cloud_name", "api_key" and
+// "api_secret", with an optional "folder" overriding the default one
+func CloudinaryFactory(defaults CloudinaryOptions) Factory {
+	return func(config map[string]string) (Sink, error) {
+		for _, key := range []string{"cloud_name", "api_key", "api_secret"} {
+			if config[key] == "" {
+				return nil, fmt.Errorf("cloudinary %s is required", key)
+			}
+		}
+		client, err := cloudinary.NewFromParams(config["cloud_name"], config["api_key"], config["api_secret"])
+		if err != nil {
+			return nil, err
+		}
+		if defaults.UploadPrefix != "" {
+			client.Config.API.UploadPrefix = defaults.UploadPrefix
+		}
+		opts := defaults
+		if folder, ok := config["folder"]; ok {
+			opts.Folder = folder
+		}
+		return NewCloudinary(client, opts), nil
+	}
+}
+
+func (s *Cloudinary) Name() string {
+	return "cloudinary"
+}
+
+// cloudinaryResponse is the part of an upload API response the sink reads.
+// Intermediate chunks of a chunked upload answer with done=false.
+type cloudinaryResponse struct {
+	PublicID     string `json:"public_id"`
+	SecureURL    string `json:"secure_url"`
+	ResourceType string `json:"resource_type"`
+	Done         *bool  `json:"done"`
+	Error        struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Put uploads the file under the share's folder with the upload ID as public
+// ID, overwriting an earlier attempt that reached Cloudinary but was not
+// recorded. Files larger than the chunk size are sent in ranged requests.
+func (s *Cloudinary) Put(ctx context.Context, obj Object) (Result, error) {
+	f, err := os.Open(obj.Path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return Result{}, err
+	}
+	size := fi.Size()
+
+	resourceType, err := ResourceType(f, obj.Filename)
+	if err != nil {
+		return Result{}, err
+	}
+	cfg := s.client.Config
+	endpoint := fmt.Sprintf("%s/%s/%s/upload", api.BaseURL(cfg.API.UploadPrefix, ""), cfg.Cloud.CloudName, resourceType)
+
+	headers := map[string]string{}
+	if size > s.opts.ChunkSize {
+		headers["X-Unique-Upload-Id"] = helpers.GenerateShareID()
+	}
+	var sent int64
+	var res cloudinaryResponse
+	for pos := int64(0); ; {
+		n := min(size-pos, s.opts.ChunkSize)
+		if _, ok := headers["X-Unique-Upload-Id"]; ok {
+			headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", pos, pos+n-1, size)
+		}
+		// Signed per request: the timestamp must stay fresh however long the
+		// earlier chunks took
+		params, err := s.params(obj)
+		if err != nil {
+			return Result{}, err
+		}
+		body := progressReader{r: io.NewSectionReader(f, pos, n), obj: obj, sent: &sent, total: size}
+		if res, err = s.post(ctx, endpoint, params, headers, filepath.Base(obj.Filename), body); err != nil {
+			return Result{}, fmt.Errorf("chunk at byte %d: %w", pos, err)
+		}
+		if pos += n; pos >= size {
+			break
+		}
+	}
+	if res.SecureURL == "" {
+		return Result{}, errors.New("cloudinary returned no URL")
+	}
+	return Result{URL: res.SecureURL, RemoteID: res.ResourceType + "/" + res.PublicID}, nil
+}
+
+// params returns the signed form fields of one upload request
+func (s *Cloudinary) params(obj Object) (url.Values, error) {
+	folder := obj.ShareID
+	if s.opts.Folder != "" {
+		folder = s.opts.Folder + "/" + obj.ShareID
+	}
+	params := url.Values{
+		"public_id":         {obj.UploadID},
+		"folder":            {folder},
+		"tags":              {"aetherlink,share_" + obj.ShareID},
+		"filename_override": {filepath.Base(obj.Filename)},
+		"overwrite":         {"true"},
+		"timestamp":         {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+
+	cloud := s.client.Config.Cloud
+	if cloud.OAuthToken != "" {
+		return params, nil // authenticated by the bearer token instead
+	}
+	if cloud.APISecret == "" {
+		return nil, errors.New("cloudinary API secret is not set")
+	}
+	signature, err := api.SignParametersUsingAlgoAndVersion(params, cloud.APISecret,
+		cloud.GetSignatureAlgorithm(), cloud.GetSignatureVersion())
+	if err != nil {
+		return nil, err
+	}
+	params.Set("signature", signature)
+	params.Set("api_key", cloud.APIKey)
+	return params, nil
+}
+
+// post sends one multipart request of an upload
+func (s *Cloudinary) post(ctx context.Context, endpoint string, params url.Values, headers map[string]string,
+	filename string, file io.Reader) (cloudinaryResponse, error) {
+	var res cloudinaryResponse
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		for key, vals := range params {
+			if err := form.WriteField(key, vals[0]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return res, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("User-Agent", api.GetUserAgent())
+	if token := s.client.Config.Cloud.OAuthToken; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		pr.CloseWithError(err)
+		return res, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return res, err
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return res, fmt.Errorf("cloudinary answered %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if res.Error.Message != "" {
+		return res, fmt.Errorf("cloudinary answered %d: %s", resp.StatusCode, res.Error.Message)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return res, fmt.Errorf("cloudinary answered %d", resp.StatusCode)
+	}
+	return res, nil
+}
+
+// ResourceType picks the Cloudinary resource type of a file from its
+// content, falling back to its extension: images (and PDFs, which Cloudinary
+// renders as images) are "image", video and audio are "video", anything else
+// is stored as "raw"
+func ResourceType(f io.ReaderAt, filename string) (string, error) {
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	contentType := http.DetectContentType(head[:n])
+	if strings.HasPrefix(contentType, "text/") || contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); byExt != "" {
+			contentType = byExt
+		}
+	}
+	contentType, _, _ = strings.Cut(contentType, ";")
+	switch {
+	case strings.HasPrefix(contentType, "image/"), contentType == "application/pdf":
+		return "image", nil
+	case strings.HasPrefix(contentType, "video/"), strings.HasPrefix(contentType, "audio/"):
+		return "video", nil
+	default:
+		return "raw", nil
+	}
+}
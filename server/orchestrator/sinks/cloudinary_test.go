@@ -0,0 +1,117 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+)
+
+// chunkRequest is what the fake upload API saw of one request
+type chunkRequest struct {
+	timestamp    int64
+	contentRange string
+	uploadID     string
+	data         string
+}
+
+func TestCloudinaryChunkedUploadSignsEachRequest(t *testing.T) {
+	var mu sync.Mutex
+	var requests []chunkRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params := url.Values{}
+		for key, vals := range r.MultipartForm.Value {
+			if key != "signature" && key != "api_key" {
+				params[key] = vals
+			}
+		}
+		want, _ := api.SignParameters(params, "secret")
+		if got := r.FormValue("signature"); got != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"error": {"message": "invalid signature %s"}}`, got)
+			return
+		}
+		ts, _ := strconv.ParseInt(r.FormValue("timestamp"), 10, 64)
+		f, _, _ := r.FormFile("file")
+		data, _ := io.ReadAll(f)
+
+		mu.Lock()
+		requests = append(requests, chunkRequest{
+			timestamp:    ts,
+			contentRange: r.Header.Get("Content-Range"),
+			uploadID:     r.Header.Get("X-Unique-Upload-Id"),
+			data:         string(data),
+		})
+		first := len(requests) == 1
+		mu.Unlock()
+		if first {
+			// A slow first chunk: the next ones need a newer timestamp
+			time.Sleep(1100 * time.Millisecond)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"public_id":     r.FormValue("folder") + "/" + r.FormValue("public_id"),
+			"secure_url":    "https://res.example.com/file",
+			"resource_type": "raw",
+		})
+	}))
+	defer srv.Close()
+
+	client, err := cloudinary.NewFromParams("demo", "key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Config.API.UploadPrefix = srv.URL
+	sink := NewCloudinary(client, CloudinaryOptions{ChunkSize: 10})
+
+	content := strings.Repeat("0123456789", 2) + "tail"
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := sink.Put(context.Background(), Object{UploadID: "u1", ShareID: "s1", Filename: "data.bin", Path: path, Size: int64(len(content))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.URL != "https://res.example.com/file" || res.RemoteID != "raw/s1/u1" {
+		t.Errorf("result = %+v", res)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("%d requests, want 3", len(requests))
+	}
+	var got strings.Builder
+	for i, req := range requests {
+		got.WriteString(req.data)
+		start := i * 10
+		end := min(start+10, len(content)) - 1
+		if want := fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)); req.contentRange != want {
+			t.Errorf("request %d Content-Range %q, want %q", i, req.contentRange, want)
+		}
+		if req.uploadID == "" || req.uploadID != requests[0].uploadID {
+			t.Errorf("request %d X-Unique-Upload-Id %q, want %q", i, req.uploadID, requests[0].uploadID)
+		}
+	}
+	if got.String() != content {
+		t.Errorf("uploaded %q, want %q", got.String(), content)
+	}
+	if requests[1].timestamp <= requests[0].timestamp {
+		t.Errorf("chunk after a slow one reused timestamp %d", requests[0].timestamp)
+	}
+}
@@ -0,0 +1,82 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir copies files into a local directory, e.g. a mounted backup volume,
+// as <root>/<shareID>/<uploadID>/<filename>
+type Dir struct {
+	root string
+}
+
+// NewDir returns a sink writing below root
+func NewDir(root string) *Dir {
+	return &Dir{root: root}
+}
+
+func (s *Dir) Name() string {
+	return "dir"
+}
+
+func (s *Dir) Put(ctx context.Context, obj Object) (Result, error) {
+	dst, err := s.destination(obj)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return Result{}, err
+	}
+	src, err := os.Open(obj.Path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer src.Close()
+
+	tmp := dst + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return Result{}, err
+	}
+	var sent int64
+	if _, err := io.Copy(out, progressReader{r: src, obj: obj, sent: &sent, total: obj.Size}); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return Result{}, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return Result{}, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return Result{}, err
+	}
+	abs, err := filepath.Abs(dst)
+	if err != nil {
+		abs = dst
+	}
+	return Result{URL: (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(), RemoteID: dst}, nil
+}
+
+// destination returns where obj is stored, refusing paths that would leave
+// the root or its <shareID>/<uploadID> directory
+func (s *Dir) destination(obj Object) (string, error) {
+	root := filepath.Clean(s.root)
+	dir := filepath.Join(root, obj.ShareID, obj.UploadID)
+	name := filepath.Base(obj.Filename)
+	if obj.ShareID == "" || obj.UploadID == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("dir sink: invalid object path %q/%q/%q", obj.ShareID, obj.UploadID, obj.Filename)
+	}
+	dst := filepath.Join(dir, name)
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.Dir(dst) != dir {
+		return "", fmt.Errorf("dir sink: %q escapes %s", filepath.Join(obj.ShareID, obj.UploadID, name), root)
+	}
+	return dst, nil
+}
@@ -0,0 +1,55 @@
+package sinks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirPut(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(src, []byte("report"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	sink := NewDir(root)
+
+	res, err := sink.Put(context.Background(), Object{UploadID: "u1", ShareID: "s1", Filename: "report.pdf", Path: src, Size: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(root, "s1", "u1", "report.pdf")
+	if data, err := os.ReadFile(want); err != nil || string(data) != "report" {
+		t.Fatalf("stored %q, %v", data, err)
+	}
+	if res.RemoteID != want {
+		t.Errorf("remote ID %q, want %q", res.RemoteID, want)
+	}
+}
+
+func TestDirPutStaysUnderRoot(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "x")
+	if err := os.WriteFile(src, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parent := t.TempDir()
+	sink := NewDir(filepath.Join(parent, "root"))
+
+	for _, obj := range []Object{
+		{ShareID: "..", UploadID: "..", Filename: "x"},
+		{ShareID: "../..", UploadID: "u", Filename: "x"},
+		{ShareID: "s", UploadID: "../../escape", Filename: "x"},
+		{ShareID: "", UploadID: "u", Filename: "x"},
+		{ShareID: "s", UploadID: "u", Filename: ".."},
+	} {
+		obj.Path = src
+		if _, err := sink.Put(context.Background(), obj); err == nil {
+			t.Errorf("Put(%q, %q, %q) succeeded", obj.ShareID, obj.UploadID, obj.Filename)
+		}
+	}
+	entries, _ := os.ReadDir(parent)
+	if len(entries) != 0 {
+		t.Errorf("files written outside the root: %v", entries)
+	}
+}
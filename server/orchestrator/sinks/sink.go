@@ -0,0 +1,54 @@
+// Package sinks pushes assembled files to external storage after an upload
+// completes.
+package sinks
+
+import (
+	"context"
+	"io"
+)
+
+// Object is an assembled file to push
+type Object struct {
+	UploadID string
+	ShareID  string
+	Filename string
+	Path     string // local path of the assembled file
+	Size     int64
+
+	// Progress, if set, is called as bytes reach the sink
+	Progress func(sent, total int64)
+}
+
+// Result describes where a sink stored an object
+type Result struct {
+	URL      string
+	RemoteID string
+}
+
+// Sink stores assembled files outside the orchestrator. Put must be safe to
+// repeat for the same object, since failed pushes are retried.
+type Sink interface {
+	Name() string
+	Put(ctx context.Context, obj Object) (Result, error)
+}
+
+// Factory builds a sink from an account's settings, e.g. the credentials of
+// a tenant's own Cloudinary cloud
+type Factory func(config map[string]string) (Sink, error)
+
+// progressReader reports the bytes read through it to an object's Progress
+type progressReader struct {
+	r     io.Reader
+	obj   Object
+	sent  *int64
+	total int64
+}
+
+func (pr progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 && pr.obj.Progress != nil {
+		*pr.sent += int64(n)
+		pr.obj.Progress(*pr.sent, pr.total)
+	}
+	return n, err
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultServiceName is used when OTEL_SERVICE_NAME is not set
+const DefaultServiceName = "aetherlink-orchestrator"
+
+const instrumentationName = "aetherlink"
+
+// Setup installs the global tracer provider and W3C trace-context
+// propagator. Spans are exported over OTLP/HTTP when one of the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT / OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables
+// is set; otherwise tracing stays a no-op but trace context still flows.
+// The returned func flushes and stops the exporter.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Install(exporter)
+}
+
+// Install registers a tracer provider that batches spans to exporter.
+// Any SpanExporter works, e.g. stdout or in-memory exporters.
+func Install(exporter sdktrace.SpanExporter) (func(context.Context) error, error) {
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil && !errors.Is(err, resource.ErrSchemaURLConflict) {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the orchestrator's tracer
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start begins a child span of the span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// Extract reads W3C trace context from request headers
+func Extract(ctx context.Context, h *fasthttp.RequestHeader) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{h})
+}
+
+// Inject writes the trace context of ctx into request headers
+func Inject(ctx context.Context, h *fasthttp.RequestHeader) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{h})
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, or ""
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceParent returns ctx carrying the remote span described by a
+// stored traceparent value
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// RecordError marks the span as failed
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// headerCarrier adapts fasthttp request headers to a TextMapCarrier
+type headerCarrier struct {
+	h *fasthttp.RequestHeader
+}
+
+func (hc headerCarrier) Get(key string) string {
+	return string(hc.h.Peek(key))
+}
+
+func (hc headerCarrier) Set(key, value string) {
+	hc.h.Set(key, value)
+}
+
+func (hc headerCarrier) Keys() []string {
+	keys := make([]string, 0, hc.h.Len())
+	hc.h.VisitAll(func(k, _ []byte) {
+		keys = append(keys, string(k))
+	})
+	return keys
+}
@@ -0,0 +1,126 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"aetherlink/config"
+	"aetherlink/helpers"
+	"aetherlink/middleware"
+	"aetherlink/models"
+	"aetherlink/routes"
+	"aetherlink/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestMain runs the tests in a scratch directory, since storage and event
+// logs live under the working directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aetherlink-tracing")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(config.StorageRoot, 0755); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestUploadLifecycleIsOneTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := tracing.Install(exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	routes.SetupRoutes(app)
+
+	chunks := [][]byte{[]byte("trace-"), []byte("me")}
+	var hashes []string
+	for _, c := range chunks {
+		hashes = append(hashes, helpers.HashChunk(c))
+	}
+	md := models.Metadata{
+		UploadID:    "traced-upload",
+		Filename:    "traced.txt",
+		TotalChunks: len(chunks),
+		ChunkSize:   int64(len(chunks[0])),
+		ChunkHashes: hashes,
+		FileSize:    int64(len(chunks[0]) + len(chunks[1])),
+	}
+	body, _ := json.Marshal(md)
+	req := httptest.NewRequest(fiber.MethodPost, "/v1/init", bytes.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	expectStatus(t, app, req, fiber.StatusCreated)
+
+	for i, c := range chunks {
+		url := fmt.Sprintf("/v1/upload/%s/%d", md.UploadID, i)
+		expectStatus(t, app, httptest.NewRequest(fiber.MethodPut, url, bytes.NewReader(c)), fiber.StatusOK)
+	}
+	expectStatus(t, app, httptest.NewRequest(fiber.MethodPost, "/v1/complete/"+md.UploadID, nil), fiber.StatusOK)
+
+	if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) == 0 {
+		t.Fatal("no spans exported")
+	}
+
+	traceID := spans[0].SpanContext.TraceID()
+	names := make(map[string]int)
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != traceID {
+			t.Errorf("span %q is in trace %s, want %s", s.Name, s.SpanContext.TraceID(), traceID)
+		}
+		names[s.Name]++
+	}
+	for _, name := range []string{
+		"POST /v1/init",
+		"PUT /v1/upload/:uploadID/:idx",
+		"POST /v1/complete/:uploadID",
+		"hash.verify",
+		"chunk.write",
+		"received.append",
+		"sse.broadcast",
+		"assembly",
+	} {
+		if names[name] == 0 {
+			t.Errorf("no %q span; got %v", name, names)
+		}
+	}
+	if names["chunk.write"] != len(chunks) {
+		t.Errorf("%d chunk.write spans, want %d", names["chunk.write"], len(chunks))
+	}
+}
+
+func expectStatus(t *testing.T, app *fiber.App, req *http.Request, want int) {
+	t.Helper()
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		t.Fatalf("%s %s: status %d, want %d: %s", req.Method, req.URL, resp.StatusCode, want, body.String())
+	}
+}
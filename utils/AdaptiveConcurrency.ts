@@ -43,6 +43,15 @@ export type ConcurrencyEvent =
 
 type EventListener = (event: ConcurrencyEvent) => void;
 
+// Recommendation returned by the orchestrator with each chunk response
+export interface ServerAdvice {
+  chunk_size: number;
+  max_workers: number;
+  backoff_ms: number;
+  ingest_bytes_per_sec: number;
+  write_latency_ms: number;
+}
+
 interface ChunkMetrics {
   startTime: number;
   endTime?: number;
@@ -71,6 +80,9 @@ export class AdaptiveConcurrency {
   
   // Performance baseline
   private baselineMetrics: NetworkMetrics | null = null;
+
+  // Latest server-side recommendation (caps concurrency, adds backoff)
+  private serverAdvice: ServerAdvice | null = null;
   
   constructor(config: AdaptiveConcurrencyConfig) {
     // Validate and set defaults
@@ -137,6 +149,47 @@ export class AdaptiveConcurrency {
     return this.currentConcurrency;
   }
   
+  /**
+   * Apply the server's recommendation. The advised worker count caps
+   * concurrency immediately instead of waiting for local metrics to degrade.
+   */
+  public applyServerAdvice(advice: ServerAdvice): void {
+    this.serverAdvice = advice;
+    const cap = this.getMaxConcurrency();
+    if (this.currentConcurrency > cap) {
+      const oldValue = this.currentConcurrency;
+      this.currentConcurrency = cap;
+      this.emit({
+        type: 'concurrencyChanged',
+        oldValue,
+        newValue: cap,
+        reason: `Server advised max ${advice.max_workers} workers`,
+      });
+    }
+  }
+
+  /**
+   * Backoff requested by the server before the next chunk (ms)
+   */
+  public getServerBackoff(): number {
+    return this.serverAdvice?.backoff_ms ?? 0;
+  }
+
+  /**
+   * Chunk size suggested by the server for the next upload, if any. An
+   * upload keeps the chunk size it started with, so the hooks apply this
+   * to the upload that follows.
+   */
+  public getAdvisedChunkSize(): number | null {
+    return this.serverAdvice?.chunk_size || null;
+  }
+
+  private getMaxConcurrency(): number {
+    const advised = this.serverAdvice?.max_workers;
+    if (!advised) return this.config.max;
+    return Math.max(this.config.min, Math.min(this.config.max, advised));
+  }
+
   /**
    * Record start of a chunk upload
    */
@@ -443,7 +496,7 @@ export class AdaptiveConcurrency {
       
       newValue = Math.min(
         this.currentConcurrency + increaseAmount,
-        this.config.max
+        this.getMaxConcurrency()
       );
       reason = wasRecentlyDegraded
         ? `Fast recovery: ${metrics.averageUploadTime.toFixed(0)}ms/chunk, ${metrics.throughput.toFixed(2)} chunks/s`
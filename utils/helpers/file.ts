@@ -1,4 +1,6 @@
 import { NetworkProfile } from '@/types/NetworkProfile';
+import { ServerAdvice } from '@/utils/AdaptiveConcurrency';
+import { TelemetryMetrics } from '@/types/TelemetryMetrics';
 import axios from 'axios';
 const API_URL = process.env.NEXT_PUBLIC_SERVER_URL!;
 
@@ -17,13 +19,50 @@ export function formatBytes(bytes: number): string {
     return Math.round((bytes / Math.pow(k, i)) * 100) / 100 + ' ' + sizes[i];
 }
 
+// Thrown when the server asks the client to back off (429/507 with a retry hint)
+export class RetryAfterError extends Error {
+    constructor(message: string, public retryAfterMs: number) {
+        super(message);
+        this.name = 'RetryAfterError';
+    }
+}
+
+const getRetryAfterMs = (data: any, header?: string): number | null => {
+    if (data && typeof data.retry_after_ms === 'number') return data.retry_after_ms;
+    const seconds = Number(header);
+    return header && !Number.isNaN(seconds) ? seconds * 1000 : null;
+};
+
+// One W3C trace per upload: every request of an upload carries a traceparent
+// with the same trace ID and a fresh parent span ID
+const uploadTraceIds = new Map<string, string>();
+
+const randomHex = (bytes: number) => {
+    const buf = new Uint8Array(bytes);
+    crypto.getRandomValues(buf);
+    return bufferToHex(buf.buffer);
+};
+
+export const traceHeaders = (uploadID: string): Record<string, string> => {
+    let traceId = uploadTraceIds.get(uploadID);
+    if (!traceId) {
+        traceId = randomHex(16);
+        uploadTraceIds.set(uploadID, traceId);
+    }
+    return { traceparent: `00-${traceId}-${randomHex(8)}-01` };
+};
+
+export const endUploadTrace = (uploadID: string) => {
+    uploadTraceIds.delete(uploadID);
+};
+
 export const uploadChunk = async (
     uploadID: string,
     idx: number,
     blob: Blob,
     networkProfile: NetworkProfile,
     endpoint?: string
-): Promise<void> => {
+): Promise<ServerAdvice | undefined> => {
     const uploadEndpoint = endpoint || API_URL;
 
     if (networkProfile.delay > 0) {
@@ -35,14 +74,68 @@ export const uploadChunk = async (
         throw new Error('Simulated network failure');
     }
 
-    const res = await axios.put(`${uploadEndpoint}/upload/${uploadID}/${idx}`, blob, {
-        headers: { "Content-Type": blob.type || "application/octet-stream" },
-    });
+    let res;
+    try {
+        res = await axios.put(`${uploadEndpoint}/upload/${uploadID}/${idx}`, blob, {
+            headers: {
+                "Content-Type": blob.type || "application/octet-stream",
+                ...traceHeaders(uploadID),
+            },
+        });
+    } catch (err) {
+        if (axios.isAxiosError(err) && err.response) {
+            const retryAfterMs = getRetryAfterMs(err.response.data, err.response.headers['retry-after']);
+            if (retryAfterMs !== null) {
+                throw new RetryAfterError(`chunk ${idx} throttled: ${err.response.status}`, retryAfterMs);
+            }
+        }
+        throw err;
+    }
 
     if (res.status !== 200) {
         const text = await res.data.text().catch(() => "");
         throw new Error(`chunk ${idx} failed: ${res.status} ${text}`);
     }
+    return res.data?.advice;
+};
+
+// Sends the upload's telemetry summary to the server so it outlives the tab.
+// Best effort: failures are logged and never surface to the user.
+export const reportTelemetry = async (
+    uploadID: string,
+    telemetry: TelemetryMetrics,
+    chunkSize: number,
+    adaptive: boolean,
+    endpoint?: string
+) => {
+    const lastNetwork = telemetry.networkHistory[telemetry.networkHistory.length - 1];
+    const lastConcurrency = telemetry.concurrencyHistory[telemetry.concurrencyHistory.length - 1];
+    const endTime = telemetry.endTime ?? Date.now();
+
+    try {
+        await axios.post(`${endpoint || API_URL}/telemetry/${uploadID}`, {
+            network_quality: lastConcurrency?.networkQuality ?? '',
+            effective_type: lastNetwork?.effectiveType ?? '',
+            downlink_mbps: lastNetwork?.downlink ?? 0,
+            rtt_ms: lastNetwork?.rtt ?? 0,
+            chunk_size: chunkSize,
+            total_bytes: telemetry.totalBytes,
+            duration_ms: telemetry.startTime > 0 ? endTime - telemetry.startTime : 0,
+            successful_chunks: telemetry.latencyPoints.length,
+            total_retries: telemetry.totalRetries,
+            wasted_bytes: telemetry.wastedBytes,
+            average_latency_ms: telemetry.averageLatency,
+            peak_latency_ms: telemetry.peakLatency,
+            jitter_ms: telemetry.jitter,
+            peak_concurrency: telemetry.peakConcurrency,
+            concurrency_drops: telemetry.concurrencyDrops,
+            average_efficiency: telemetry.averageEfficiency,
+            performance_score: telemetry.performanceScore,
+            adaptive,
+        }, { headers: traceHeaders(uploadID) });
+    } catch (err) {
+        console.warn(`Telemetry report for ${uploadID} failed`, err);
+    }
 };
 
 export const formatFileSize = (bytes: number) => {